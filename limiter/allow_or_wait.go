@@ -0,0 +1,44 @@
+package limiter
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// AllowOrWait takes tokens for key from l now if it can, and otherwise waits
+// for them for at most maxWait. It reports whether the tokens were taken and
+// how long it blocked. Running out of maxWait is a denial rather than an
+// error; the error is ctx's if the caller gives up first, or one from l's
+// Wait, such as ErrExceedsCapacity. A maxWait of zero or less never waits.
+func AllowOrWait(ctx context.Context, l Limiter, key string, tokens int, maxWait time.Duration) (bool, time.Duration, error) {
+	return allowOrWait(ctx, maxWait,
+		func() bool { return l.Allow(key, tokens) },
+		func(ctx context.Context) error { return l.Wait(ctx, key, tokens) })
+}
+
+func allowOrWait(ctx context.Context, maxWait time.Duration, allow func() bool, wait func(context.Context) error) (bool, time.Duration, error) {
+	if allow() {
+		return true, 0, nil
+	}
+
+	if maxWait <= 0 {
+		return false, 0, nil
+	}
+
+	waitCtx, cancel := context.WithTimeout(ctx, maxWait)
+	defer cancel()
+
+	start := time.Now()
+	err := wait(waitCtx)
+	waited := time.Since(start)
+
+	switch {
+	case err == nil:
+		return true, waited, nil
+	case errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil:
+		return false, waited, nil
+	default:
+		return false, waited, err
+	}
+}
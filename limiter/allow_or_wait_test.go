@@ -0,0 +1,64 @@
+package limiter
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestAllowOrWait_AllowsImmediately(t *testing.T) {
+	keyedLimiter := NewKeyedLimiter(1, 0, RealClock{})
+
+	allowed, waited, err := keyedLimiter.AllowOrWait(context.Background(), "key", 1, time.Second)
+	if !allowed || waited != 0 || err != nil {
+		t.Errorf("expected an immediate allow, got %v, %v, %v", allowed, waited, err)
+	}
+}
+
+func TestAllowOrWait_WaitsWithinBudget(t *testing.T) {
+	bucket := NewTokenBucket(1, 20, RealClock{})
+	bucket.Allow(1)
+
+	allowed, waited, err := bucket.AllowOrWait(context.Background(), 1, time.Second)
+	if !allowed || err != nil {
+		t.Fatalf("expected the wait to succeed, got %v, %v", allowed, err)
+	}
+
+	if waited < 30*time.Millisecond || waited > 500*time.Millisecond {
+		t.Errorf("expected to wait about 50ms, waited %v", waited)
+	}
+}
+
+func TestAllowOrWait_BudgetExhaustedIsADenial(t *testing.T) {
+	keyedLimiter := NewKeyedLimiter(1, 1, RealClock{})
+	keyedLimiter.Allow("key", 1)
+
+	allowed, waited, err := AllowOrWait(context.Background(), keyedLimiter, "key", 1, 20*time.Millisecond)
+	if allowed || err != nil {
+		t.Fatalf("expected a denial without error, got %v, %v", allowed, err)
+	}
+
+	if waited < 20*time.Millisecond {
+		t.Errorf("expected to wait out the budget, waited %v", waited)
+	}
+
+	if allowed, waited, _ := keyedLimiter.AllowOrWait(context.Background(), "key", 1, 0); allowed || waited != 0 {
+		t.Errorf("expected no wait without a budget, got %v, %v", allowed, waited)
+	}
+}
+
+func TestAllowOrWait_ReturnsCallerAndLimiterErrors(t *testing.T) {
+	keyedLimiter := NewKeyedLimiter(1, 1, RealClock{})
+	keyedLimiter.Allow("key", 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if _, _, err := keyedLimiter.AllowOrWait(ctx, "key", 1, time.Second); err != context.DeadlineExceeded {
+		t.Errorf("expected the caller's deadline error, got %v", err)
+	}
+
+	if _, _, err := keyedLimiter.AllowOrWait(context.Background(), "key", 2, time.Second); err != ErrExceedsCapacity {
+		t.Errorf("expected ErrExceedsCapacity, got %v", err)
+	}
+}
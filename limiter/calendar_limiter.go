@@ -0,0 +1,256 @@
+package limiter
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// maxCalendarScan bounds how many days are searched for a business day so a
+// calendar that never returns true can't loop forever.
+const maxCalendarScan = 366
+
+type Calendar interface {
+	IsBusinessDay(t time.Time) bool
+}
+
+type EveryDayCalendar struct{}
+
+func (EveryDayCalendar) IsBusinessDay(t time.Time) bool { return true }
+
+type quotaUsage struct {
+	used        float64
+	periodStart time.Time
+	// nextAt is when a tapered key may next be granted tokens.
+	nextAt time.Time
+}
+
+// CalendarLimiter grants each key a daily quota that resets at midnight, but
+// only on business days: usage accumulated on a non-business day carries over
+// until the next business day starts.
+type CalendarLimiter struct {
+	mu           sync.Mutex
+	quota        float64
+	pendingQuota float64
+	location     *time.Location
+	calendar     Calendar
+	clock        Clock
+	usage        map[string]*quotaUsage
+	lastReset    time.Time
+
+	taperThreshold float64
+	taperRate      float64
+}
+
+type CalendarOption func(*CalendarLimiter)
+
+func WithCalendar(c Calendar) CalendarOption {
+	return func(cl *CalendarLimiter) {
+		cl.calendar = c
+	}
+}
+
+func WithLocation(loc *time.Location) CalendarOption {
+	return func(cl *CalendarLimiter) {
+		cl.location = loc
+	}
+}
+
+// WithTaper paces a key once its remaining quota falls below threshold, a
+// fraction of the quota, so it can't spend the rest in one burst. At the
+// threshold grants are spaced to rate tokens per second, and the rate shrinks
+// in proportion to the remaining fraction from there, reaching zero as the
+// quota runs out.
+func WithTaper(threshold float64, rate float64) CalendarOption {
+	return func(cl *CalendarLimiter) {
+		cl.taperThreshold = threshold
+		cl.taperRate = rate
+	}
+}
+
+func NewCalendarLimiter(quota float64, clock Clock, opts ...CalendarOption) *CalendarLimiter {
+	cl := &CalendarLimiter{
+		quota:        quota,
+		pendingQuota: quota,
+		location:     time.UTC,
+		calendar:     EveryDayCalendar{},
+		clock:        clock,
+		usage:        make(map[string]*quotaUsage),
+	}
+
+	for _, opt := range opts {
+		opt(cl)
+	}
+
+	cl.lastReset = cl.periodStart(clock.Now())
+
+	return cl
+}
+
+func (cl *CalendarLimiter) Allow(key string, tokens int) bool {
+	if tokens < 0 {
+		return false
+	}
+
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	now := cl.clock.Now()
+	usage := cl.usageFor(key)
+
+	if usage.used+float64(tokens) > cl.quota || now.Before(usage.nextAt) {
+		return false
+	}
+
+	if rate := cl.taperedRate(usage); rate > 0 {
+		usage.nextAt = now.Add(time.Duration(float64(tokens) / rate * float64(time.Second)))
+	}
+
+	usage.used += float64(tokens)
+	return true
+}
+
+// taperedRate returns the rate a key is paced to, or 0 if it isn't tapered.
+// Must be called with cl.mu held.
+func (cl *CalendarLimiter) taperedRate(usage *quotaUsage) float64 {
+	if cl.taperRate <= 0 || cl.quota <= 0 {
+		return 0
+	}
+
+	remaining := (cl.quota - usage.used) / cl.quota
+	if remaining > cl.taperThreshold {
+		return 0
+	}
+
+	return cl.taperRate * remaining / cl.taperThreshold
+}
+
+// Wait blocks until the quota allows the request or the context is cancelled.
+// Since quota only comes back on a reset, it sleeps until the next business day,
+// or until a tapered key's next grant if the quota still has room.
+func (cl *CalendarLimiter) Wait(ctx context.Context, key string, tokens int) error {
+	if tokens < 0 {
+		return ErrNegativeTokens
+	}
+
+	for {
+		cl.mu.Lock()
+		if float64(tokens) > cl.quota {
+			cl.mu.Unlock()
+			return ErrExceedsCapacity
+		}
+		cl.mu.Unlock()
+
+		if cl.Allow(key, tokens) {
+			return nil
+		}
+
+		now := cl.clock.Now()
+		timer := time.NewTimer(cl.retryAt(key, tokens, now).Sub(now))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func (cl *CalendarLimiter) Status(key string) Status {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	now := cl.clock.Now()
+	usage := cl.usageFor(key)
+
+	return Status{
+		Key:       key,
+		Used:      usage.used,
+		Limit:     cl.quota,
+		ResetIn:   cl.NextReset(now).Sub(now),
+		Throttled: cl.quota-usage.used < 1,
+	}
+}
+
+func (cl *CalendarLimiter) Describe(key string) string {
+	return cl.Status(key).String()
+}
+
+// SetQuota changes the quota. Like resets, the change only takes effect at the
+// start of the next business day.
+func (cl *CalendarLimiter) SetQuota(quota float64) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	cl.pendingQuota = quota
+}
+
+// retryAt returns when a denied request for key could next be allowed.
+func (cl *CalendarLimiter) retryAt(key string, tokens int, now time.Time) time.Time {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	usage := cl.usageFor(key)
+	if usage.used+float64(tokens) <= cl.quota && now.Before(usage.nextAt) {
+		return usage.nextAt
+	}
+
+	return cl.NextReset(now)
+}
+
+// NextReset returns the start of the next business day after t.
+func (cl *CalendarLimiter) NextReset(t time.Time) time.Time {
+	day := midnight(t.In(cl.location))
+
+	for range maxCalendarScan {
+		day = day.AddDate(0, 0, 1)
+		if cl.calendar.IsBusinessDay(day) {
+			return day
+		}
+	}
+
+	return day
+}
+
+// usageFor returns the key's usage, resetting it if a business day has
+// started since it was last touched. Must be called with cl.mu held.
+func (cl *CalendarLimiter) usageFor(key string) *quotaUsage {
+	start := cl.periodStart(cl.clock.Now())
+
+	if start.After(cl.lastReset) {
+		cl.quota = cl.pendingQuota
+		cl.lastReset = start
+	}
+
+	usage, ok := cl.usage[key]
+	if !ok {
+		usage = &quotaUsage{periodStart: start}
+		cl.usage[key] = usage
+	}
+
+	if !usage.periodStart.Equal(start) {
+		usage.used = 0
+		usage.periodStart = start
+		usage.nextAt = time.Time{}
+	}
+
+	return usage
+}
+
+// periodStart returns midnight of the most recent business day at or before t.
+func (cl *CalendarLimiter) periodStart(t time.Time) time.Time {
+	day := midnight(t.In(cl.location))
+
+	for range maxCalendarScan {
+		if cl.calendar.IsBusinessDay(day) {
+			return day
+		}
+		day = day.AddDate(0, 0, -1)
+	}
+
+	return day
+}
+
+func midnight(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
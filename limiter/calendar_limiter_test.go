@@ -0,0 +1,206 @@
+package limiter
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+type WeekdayCalendar struct{}
+
+func (WeekdayCalendar) IsBusinessDay(t time.Time) bool {
+	return t.Weekday() != time.Saturday && t.Weekday() != time.Sunday
+}
+
+func TestCalendarLimiter_EnforcesQuota(t *testing.T) {
+	clock := &MockClock{current: time.Date(2026, 10, 14, 9, 0, 0, 0, time.UTC)}
+	limiter := NewCalendarLimiter(10, clock)
+
+	if !limiter.Allow("user-1", 10) {
+		t.Error("expected allow to return true within quota")
+	}
+
+	if limiter.Allow("user-1", 1) {
+		t.Error("expected allow to return false once quota is used")
+	}
+
+	if !limiter.Allow("user-2", 1) {
+		t.Error("expected allow to return true for user-2")
+	}
+}
+
+func TestCalendarLimiter_RejectsNegativeTokens(t *testing.T) {
+	clock := &MockClock{current: time.Date(2026, 10, 14, 9, 0, 0, 0, time.UTC)}
+	limiter := NewCalendarLimiter(10, clock)
+
+	limiter.Allow("user-1", 10)
+
+	if limiter.Allow("user-1", -5) {
+		t.Error("expected negative tokens to be denied")
+	}
+
+	if err := limiter.Wait(context.Background(), "user-1", -5); err != ErrNegativeTokens {
+		t.Errorf("expected ErrNegativeTokens, got %v", err)
+	}
+
+	if limiter.Allow("user-1", 1) {
+		t.Error("expected negative tokens not to raise the quota")
+	}
+}
+
+func TestCalendarLimiter_ResetsEveryDayByDefault(t *testing.T) {
+	clock := &MockClock{current: time.Date(2026, 10, 17, 9, 0, 0, 0, time.UTC)}
+	limiter := NewCalendarLimiter(10, clock)
+
+	limiter.Allow("user-1", 10)
+	clock.Advance(24 * time.Hour)
+
+	if !limiter.Allow("user-1", 10) {
+		t.Error("expected quota to reset on sunday with the default calendar")
+	}
+}
+
+func TestCalendarLimiter_SkipsWeekendResets(t *testing.T) {
+	// Friday
+	clock := &MockClock{current: time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC)}
+	limiter := NewCalendarLimiter(10, clock, WithCalendar(WeekdayCalendar{}))
+
+	limiter.Allow("user-1", 10)
+
+	clock.Advance(24 * time.Hour)
+	if limiter.Allow("user-1", 1) {
+		t.Error("expected no reset on saturday")
+	}
+
+	clock.Advance(24 * time.Hour)
+	if limiter.Allow("user-1", 1) {
+		t.Error("expected no reset on sunday")
+	}
+
+	clock.Advance(24 * time.Hour)
+	if !limiter.Allow("user-1", 10) {
+		t.Error("expected quota to reset on monday")
+	}
+}
+
+func TestCalendarLimiter_NextResetSkipsWeekend(t *testing.T) {
+	friday := time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC)
+	clock := &MockClock{current: friday}
+	limiter := NewCalendarLimiter(10, clock, WithCalendar(WeekdayCalendar{}))
+
+	expected := time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)
+	if next := limiter.NextReset(friday); !next.Equal(expected) {
+		t.Errorf("expected next reset to be %v, got %v", expected, next)
+	}
+}
+
+func TestCalendarLimiter_SetQuotaAppliesOnBusinessDay(t *testing.T) {
+	clock := &MockClock{current: time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC)}
+	limiter := NewCalendarLimiter(10, clock, WithCalendar(WeekdayCalendar{}))
+
+	limiter.SetQuota(20)
+
+	if limiter.Allow("user-1", 15) {
+		t.Error("expected the old quota to apply until the next business day")
+	}
+
+	clock.Advance(24 * time.Hour)
+	if limiter.Allow("user-1", 15) {
+		t.Error("expected the old quota to still apply on saturday")
+	}
+
+	clock.Advance(48 * time.Hour)
+	if !limiter.Allow("user-1", 15) {
+		t.Error("expected the new quota to apply on monday")
+	}
+}
+
+func TestCalendarLimiter_WaitExceedsCapacity(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	limiter := NewCalendarLimiter(10, clock)
+
+	err := limiter.Wait(context.Background(), "user-1", 20)
+
+	if err != ErrExceedsCapacity {
+		t.Errorf("expected ErrExceedsCapacity, got %v", err)
+	}
+}
+
+func TestCalendarLimiter_Describe(t *testing.T) {
+	clock := &MockClock{current: time.Date(2026, 10, 14, 23, 48, 0, 0, time.UTC)}
+	limiter := NewCalendarLimiter(100, clock)
+
+	limiter.Allow("user-1", 45)
+
+	expected := "Used 45 of 100 this window, resets in 12m."
+	if s := limiter.Describe("user-1"); s != expected {
+		t.Errorf("expected %q, got %q", expected, s)
+	}
+
+	limiter.Allow("user-1", 55)
+
+	if !limiter.Status("user-1").Throttled {
+		t.Error("expected status to be throttled once quota is used")
+	}
+}
+
+func TestCalendarLimiter_TaperSlowsNearExhaustion(t *testing.T) {
+	clock := &MockClock{current: time.Date(2026, 10, 14, 9, 0, 0, 0, time.UTC)}
+	limiter := NewCalendarLimiter(100, clock, WithTaper(0.2, 10))
+
+	if !limiter.Allow("user-1", 80) {
+		t.Fatal("expected quota above the threshold to be granted in one burst")
+	}
+
+	// spacing returns how long the key must wait after its next grant.
+	spacing := func() time.Duration {
+		if !limiter.Allow("user-1", 1) {
+			t.Fatal("expected the key to be allowed once its spacing elapsed")
+		}
+
+		var waited time.Duration
+		for !limiter.Allow("user-1", 0) {
+			clock.Advance(10 * time.Millisecond)
+			waited += 10 * time.Millisecond
+		}
+
+		return waited
+	}
+
+	// 20% remaining paces to 10/s; 10% remaining to 5/s; 5% to 2.5/s.
+	first := spacing()
+	for range 9 {
+		spacing()
+	}
+	middle := spacing()
+	for range 4 {
+		spacing()
+	}
+	late := spacing()
+
+	if first != 100*time.Millisecond {
+		t.Errorf("expected 100ms spacing at the threshold, got %v", first)
+	}
+
+	if middle != 200*time.Millisecond {
+		t.Errorf("expected 200ms spacing at half the threshold, got %v", middle)
+	}
+
+	if late != 400*time.Millisecond {
+		t.Errorf("expected 400ms spacing at a quarter of the threshold, got %v", late)
+	}
+}
+
+func TestCalendarLimiter_TaperResetsWithQuota(t *testing.T) {
+	clock := &MockClock{current: time.Date(2026, 10, 14, 9, 0, 0, 0, time.UTC)}
+	limiter := NewCalendarLimiter(10, clock, WithTaper(0.5, 1))
+
+	limiter.Allow("user-1", 9)
+	limiter.Allow("user-1", 1)
+
+	clock.Advance(24 * time.Hour)
+
+	if !limiter.Allow("user-1", 5) {
+		t.Error("expected the reset to clear the taper spacing")
+	}
+}
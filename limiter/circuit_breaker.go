@@ -13,6 +13,25 @@ const (
 	CircuitHalfOpen
 )
 
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+type BreakerSnapshot struct {
+	State       CircuitState
+	Failures    int
+	LastFailure time.Time
+}
+
 type CircuitBreaker struct {
 	mu          sync.Mutex
 	state       CircuitState
@@ -21,60 +40,306 @@ type CircuitBreaker struct {
 	timeout     time.Duration
 	lastFailure time.Time
 	clock       Clock
+	probeOnly   bool
+
+	// window, if set, limits failures to those recorded within it, with
+	// failureTimes holding their times oldest first.
+	window       time.Duration
+	failureTimes []time.Time
+
+	// baseTimeout, maxTimeout and backoffFactor, if backoffFactor is set,
+	// grow timeout after each failed half-open trial.
+	baseTimeout   time.Duration
+	maxTimeout    time.Duration
+	backoffFactor float64
+
+	successThreshold  int
+	halfOpenMax       int
+	halfOpenSuccesses int
+	halfOpenInFlight  int
+
+	onStateChange func(from, to CircuitState)
+}
+
+type BreakerOption func(*CircuitBreaker)
+
+// WithSuccessThreshold requires n consecutive successes while half-open before
+// the breaker closes, instead of one. Any failure reopens it.
+func WithSuccessThreshold(n int) BreakerOption {
+	return func(cb *CircuitBreaker) {
+		cb.successThreshold = n
+	}
+}
+
+// WithCircuitBreakerWindow trips the breaker on threshold failures within the
+// last d, letting older failures age out, instead of on threshold failures
+// since the last success.
+func WithCircuitBreakerWindow(d time.Duration) BreakerOption {
+	return func(cb *CircuitBreaker) {
+		cb.window = d
+	}
+}
+
+// WithStateChangeHook calls hook on every state transition, after the
+// breaker's lock is released, so hook may call back into the breaker.
+func WithStateChangeHook(hook func(from, to CircuitState)) BreakerOption {
+	return func(cb *CircuitBreaker) {
+		cb.onStateChange = hook
+	}
 }
 
-func NewCircuitBreaker(threshold int, timeout time.Duration, clock Clock) *CircuitBreaker {
-	return &CircuitBreaker{
-		state:     CircuitClosed,
-		threshold: threshold,
-		timeout:   timeout,
-		clock:     clock,
+// WithHalfOpenMaxRequests caps the trial requests let through while half-open
+// that haven't yet reported a success. By default there is no cap.
+func WithHalfOpenMaxRequests(n int) BreakerOption {
+	return func(cb *CircuitBreaker) {
+		cb.halfOpenMax = n
 	}
 }
 
+// WithCircuitBreakerBackoff replaces the breaker's fixed timeout with one that
+// starts at base and is multiplied by factor, up to max, each time a half-open
+// trial fails, so a Redis that stays down is probed less and less often. It
+// returns to base once the breaker closes.
+func WithCircuitBreakerBackoff(base, max time.Duration, factor float64) BreakerOption {
+	return func(cb *CircuitBreaker) {
+		cb.timeout = base
+		cb.baseTimeout = base
+		cb.maxTimeout = max
+		cb.backoffFactor = factor
+	}
+}
+
+func NewCircuitBreaker(threshold int, timeout time.Duration, clock Clock, opts ...BreakerOption) *CircuitBreaker {
+	cb := &CircuitBreaker{
+		state:            CircuitClosed,
+		threshold:        threshold,
+		timeout:          timeout,
+		clock:            clock,
+		successThreshold: 1,
+	}
+
+	for _, opt := range opts {
+		opt(cb)
+	}
+
+	return cb
+}
+
 func (cb *CircuitBreaker) Allow() bool {
 	cb.mu.Lock()
-	defer cb.mu.Unlock()
+	defer cb.unlock(cb.state)
 
 	switch cb.state {
 	case CircuitClosed:
 		return true
 	case CircuitOpen:
 		if cb.clock.Now().Sub(cb.lastFailure) >= cb.timeout {
-			cb.state = CircuitHalfOpen
+			cb.halfOpen()
+			return cb.admitTrial()
+		}
+		return false
+	case CircuitHalfOpen:
+		return cb.admitTrial()
+	default:
+		return true
+	}
+}
+
+// halfOpen moves the breaker to half-open with fresh trial counts. Must be
+// called with cb.mu held.
+func (cb *CircuitBreaker) halfOpen() {
+	cb.state = CircuitHalfOpen
+	cb.halfOpenSuccesses = 0
+	cb.halfOpenInFlight = 0
+}
+
+// admitTrial reports whether a half-open breaker lets a request through. Must
+// be called with cb.mu held.
+func (cb *CircuitBreaker) admitTrial() bool {
+	if cb.probeOnly {
+		return false
+	}
+
+	if cb.halfOpenMax > 0 && cb.halfOpenInFlight >= cb.halfOpenMax {
+		return false
+	}
+
+	cb.halfOpenInFlight++
+	return true
+}
+
+// releaseTrial frees the slot of a half-open trial that ended without a
+// success or failure being recorded, e.g. because the caller gave up or the
+// error says nothing about the backend's health.
+func (cb *CircuitBreaker) releaseTrial() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.state == CircuitHalfOpen && cb.halfOpenInFlight > 0 {
+		cb.halfOpenInFlight--
+	}
+}
+
+// ProbeDue reports whether a recovery probe should be sent, moving an open
+// breaker to half-open once its timeout has elapsed.
+func (cb *CircuitBreaker) ProbeDue() bool {
+	cb.mu.Lock()
+	defer cb.unlock(cb.state)
+
+	switch cb.state {
+	case CircuitOpen:
+		if cb.clock.Now().Sub(cb.lastFailure) >= cb.timeout {
+			cb.halfOpen()
 			return true
 		}
 		return false
 	case CircuitHalfOpen:
 		return true
 	default:
-		return true
+		return false
 	}
 }
 
 func (cb *CircuitBreaker) RecordSuccess() {
 	cb.mu.Lock()
-	defer cb.mu.Unlock()
+	defer cb.unlock(cb.state)
+
+	if cb.state == CircuitHalfOpen {
+		cb.halfOpenSuccesses++
+		if cb.halfOpenInFlight > 0 {
+			cb.halfOpenInFlight--
+		}
+
+		if cb.halfOpenSuccesses < cb.successThreshold {
+			return
+		}
+	}
 
 	cb.failures = 0
+	cb.failureTimes = cb.failureTimes[:0]
 	cb.state = CircuitClosed
+	if cb.backoffFactor > 0 {
+		cb.timeout = cb.baseTimeout
+	}
 }
 
 func (cb *CircuitBreaker) RecordFailure() {
 	cb.mu.Lock()
-	defer cb.mu.Unlock()
+	defer cb.unlock(cb.state)
 
-	cb.failures++
 	cb.lastFailure = cb.clock.Now()
 
-	if cb.failures >= cb.threshold {
+	if cb.window > 0 {
+		cb.failureTimes = append(cb.failureTimes, cb.lastFailure)
+		cb.ageFailures(cb.lastFailure)
+	} else {
+		cb.failures++
+	}
+
+	if cb.state == CircuitHalfOpen {
+		cb.backOff()
+	}
+
+	if cb.failures >= cb.threshold || cb.state == CircuitHalfOpen {
 		cb.state = CircuitOpen
 	}
 }
 
+// backOff grows the timeout after a failed half-open trial, if backoff is
+// configured. Must be called with cb.mu held.
+func (cb *CircuitBreaker) backOff() {
+	if cb.backoffFactor <= 0 {
+		return
+	}
+
+	timeout := time.Duration(float64(cb.timeout) * cb.backoffFactor)
+	if cb.maxTimeout > 0 && timeout > cb.maxTimeout {
+		timeout = cb.maxTimeout
+	}
+	cb.timeout = timeout
+}
+
+// ageFailures drops failures older than the window. Must be called with cb.mu
+// held.
+func (cb *CircuitBreaker) ageFailures(now time.Time) {
+	cutoff := now.Add(-cb.window)
+
+	i := 0
+	for i < len(cb.failureTimes) && !cb.failureTimes[i].After(cutoff) {
+		i++
+	}
+
+	cb.failureTimes = append(cb.failureTimes[:0], cb.failureTimes[i:]...)
+	cb.failures = len(cb.failureTimes)
+}
+
+// unlock releases cb.mu and reports a transition away from from, the state
+// when the lock was taken.
+func (cb *CircuitBreaker) unlock(from CircuitState) {
+	to := cb.state
+	cb.mu.Unlock()
+
+	if to != from && cb.onStateChange != nil {
+		cb.onStateChange(from, to)
+	}
+}
+
 func (cb *CircuitBreaker) State() CircuitState {
 	cb.mu.Lock()
 	defer cb.mu.Unlock()
 
 	return cb.state
 }
+
+// Snapshot captures the breaker state so it can be handed to another
+// instance, e.g. across a restart.
+func (cb *CircuitBreaker) Snapshot() BreakerSnapshot {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.window > 0 {
+		cb.ageFailures(cb.clock.Now())
+	}
+
+	return BreakerSnapshot{
+		State:       cb.state,
+		Failures:    cb.failures,
+		LastFailure: cb.lastFailure,
+	}
+}
+
+// Restore replaces the breaker state with a previously taken snapshot.
+func (cb *CircuitBreaker) Restore(s BreakerSnapshot) {
+	cb.mu.Lock()
+	defer cb.unlock(cb.state)
+
+	cb.state = s.State
+	cb.failures = s.Failures
+	cb.lastFailure = s.LastFailure
+
+	// Individual failure times aren't kept, so restored failures age out
+	// together from the last one.
+	cb.failureTimes = cb.failureTimes[:0]
+	if cb.window > 0 {
+		for range s.Failures {
+			cb.failureTimes = append(cb.failureTimes, s.LastFailure)
+		}
+	}
+	cb.halfOpenSuccesses = 0
+	cb.halfOpenInFlight = 0
+}
+
+// addStateChangeHook adds hook alongside any hook the breaker already has. It
+// must be called before the breaker is shared.
+func (cb *CircuitBreaker) addStateChangeHook(hook func(from, to CircuitState)) {
+	prev := cb.onStateChange
+	if prev == nil {
+		cb.onStateChange = hook
+		return
+	}
+
+	cb.onStateChange = func(from, to CircuitState) {
+		prev(from, to)
+		hook(from, to)
+	}
+}
@@ -93,3 +93,229 @@ func TestCircuitBreaker_ReopensOnFailureInHalfOpen(t *testing.T) {
 		t.Errorf("expecting state to be CircuitOpen, got %d", cb.State())
 	}
 }
+
+func TestCircuitBreaker_SnapshotRestore(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	cb := NewCircuitBreaker(3, 30*time.Second, clock)
+
+	cb.RecordFailure()
+	cb.RecordFailure()
+	cb.RecordFailure()
+
+	restored := NewCircuitBreaker(3, 30*time.Second, clock)
+	restored.Restore(cb.Snapshot())
+
+	if restored.State() != CircuitOpen {
+		t.Errorf("expecting state to be CircuitOpen, got %d", restored.State())
+	}
+
+	if restored.failures != 3 {
+		t.Errorf("expecting failures to be 3, got %d", restored.failures)
+	}
+
+	if restored.Allow() {
+		t.Error("expecting allow to be false")
+	}
+}
+
+func TestCircuitBreaker_ProbeOnlyDeniesHalfOpen(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	cb := NewCircuitBreaker(3, 30*time.Second, clock)
+	cb.probeOnly = true
+
+	cb.RecordFailure()
+	cb.RecordFailure()
+	cb.RecordFailure()
+	clock.Advance(35 * time.Second)
+
+	if cb.Allow() {
+		t.Error("expecting allow to be false in probe-only half-open")
+	}
+
+	if !cb.ProbeDue() {
+		t.Error("expecting a probe to be due")
+	}
+
+	cb.RecordSuccess()
+
+	if !cb.Allow() {
+		t.Error("expecting allow to be true after a successful probe")
+	}
+}
+
+func TestCircuitState_String(t *testing.T) {
+	states := map[CircuitState]string{
+		CircuitClosed:   "closed",
+		CircuitOpen:     "open",
+		CircuitHalfOpen: "half-open",
+	}
+
+	for state, want := range states {
+		if state.String() != want {
+			t.Errorf("expected %q, got %q", want, state.String())
+		}
+	}
+}
+
+func TestCircuitBreaker_SuccessThreshold(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	cb := NewCircuitBreaker(1, 30*time.Second, clock, WithSuccessThreshold(3))
+
+	cb.RecordFailure()
+	clock.Advance(35 * time.Second)
+
+	for i := range 2 {
+		cb.Allow()
+		cb.RecordSuccess()
+
+		if cb.State() != CircuitHalfOpen {
+			t.Fatalf("expecting state to stay CircuitHalfOpen after %d successes, got %v", i+1, cb.State())
+		}
+	}
+
+	cb.Allow()
+	cb.RecordFailure()
+
+	if cb.State() != CircuitOpen {
+		t.Fatalf("expecting a half-open failure to reopen, got %v", cb.State())
+	}
+
+	clock.Advance(35 * time.Second)
+
+	for range 2 {
+		cb.Allow()
+		cb.RecordSuccess()
+	}
+
+	if cb.State() != CircuitHalfOpen {
+		t.Fatalf("expecting successes before the failure not to count, got %v", cb.State())
+	}
+
+	cb.Allow()
+	cb.RecordSuccess()
+
+	if cb.State() != CircuitClosed {
+		t.Errorf("expecting state to be CircuitClosed, got %v", cb.State())
+	}
+}
+
+func TestCircuitBreaker_HalfOpenMaxRequests(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	cb := NewCircuitBreaker(1, 30*time.Second, clock, WithSuccessThreshold(3), WithHalfOpenMaxRequests(2))
+
+	cb.RecordFailure()
+	clock.Advance(35 * time.Second)
+
+	if !cb.Allow() || !cb.Allow() {
+		t.Fatal("expecting two trial requests to be let through")
+	}
+
+	if cb.Allow() {
+		t.Error("expecting a third concurrent trial to be rejected")
+	}
+
+	cb.RecordSuccess()
+
+	if !cb.Allow() {
+		t.Error("expecting a completed trial to free a slot")
+	}
+}
+
+func TestCircuitBreaker_WindowAgesOutFailures(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	cb := NewCircuitBreaker(3, 30*time.Second, clock, WithCircuitBreakerWindow(time.Minute))
+
+	cb.RecordFailure()
+	clock.Advance(40 * time.Second)
+	cb.RecordFailure()
+	clock.Advance(40 * time.Second)
+	cb.RecordFailure()
+
+	if cb.State() != CircuitClosed {
+		t.Fatalf("expecting failures spread beyond the window not to trip, got %v", cb.State())
+	}
+
+	if s := cb.Snapshot(); s.Failures != 2 {
+		t.Errorf("expecting 2 failures within the window, got %d", s.Failures)
+	}
+
+	clock.Advance(10 * time.Second)
+	cb.RecordFailure()
+
+	if cb.State() != CircuitOpen {
+		t.Errorf("expecting 3 failures within the window to trip, got %v", cb.State())
+	}
+}
+
+func TestCircuitBreaker_StateChangeHook(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	var cb *CircuitBreaker
+	var seen []CircuitState
+	cb = NewCircuitBreaker(2, 30*time.Second, clock, WithStateChangeHook(func(from, to CircuitState) {
+		seen = append(seen, cb.State())
+	}))
+
+	cb.RecordFailure()
+	cb.RecordFailure()
+	cb.RecordFailure()
+
+	if len(seen) != 1 || seen[0] != CircuitOpen {
+		t.Errorf("expecting a single transition to open, got %v", seen)
+	}
+}
+
+func TestCircuitBreaker_BackoffGrowsAndResets(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	cb := NewCircuitBreaker(1, time.Minute, clock, WithCircuitBreakerBackoff(time.Second, 4*time.Second, 2))
+
+	cb.RecordFailure()
+
+	// Each failed trial doubles the wait before the next one, up to 4s.
+	for _, timeout := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 4 * time.Second} {
+		clock.Advance(timeout - time.Millisecond)
+		if cb.Allow() {
+			t.Fatalf("expected no trial before %v", timeout)
+		}
+
+		clock.Advance(time.Millisecond)
+		if !cb.Allow() {
+			t.Fatalf("expected a trial after %v", timeout)
+		}
+		cb.RecordFailure()
+	}
+
+	clock.Advance(4 * time.Second)
+	if !cb.Allow() {
+		t.Fatal("expected a trial after the max timeout")
+	}
+	cb.RecordSuccess()
+	if cb.State() != CircuitClosed {
+		t.Fatalf("expected the breaker to close, got %v", cb.State())
+	}
+
+	cb.RecordFailure()
+	clock.Advance(time.Second)
+	if !cb.Allow() {
+		t.Error("expected the timeout to reset to base once closed")
+	}
+}
+
+func TestCircuitBreaker_ReleaseTrialFreesSlot(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	cb := NewCircuitBreaker(1, time.Second, clock, WithHalfOpenMaxRequests(1))
+
+	cb.RecordFailure()
+	clock.Advance(time.Second)
+
+	if !cb.Allow() {
+		t.Fatal("expected the first half-open trial to be admitted")
+	}
+	if cb.Allow() {
+		t.Fatal("expected the trial cap to hold")
+	}
+
+	cb.releaseTrial()
+	if !cb.Allow() {
+		t.Error("expected a released trial slot to admit another trial")
+	}
+}
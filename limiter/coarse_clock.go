@@ -0,0 +1,61 @@
+package limiter
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CoarseClock caches another Clock's time and refreshes it every granularity
+// from a background goroutine, so hot paths like Allow read a cached value
+// instead of calling Now on every request. Refill is then accurate only to
+// within the granularity. Call Stop to release the goroutine.
+type CoarseClock struct {
+	clock     Clock
+	now       atomic.Pointer[time.Time]
+	stop      chan struct{}
+	closeOnce sync.Once
+}
+
+func NewCoarseClock(clock Clock, granularity time.Duration) *CoarseClock {
+	c := &CoarseClock{
+		clock: clock,
+		stop:  make(chan struct{}),
+	}
+	c.update()
+
+	go c.run(granularity)
+
+	return c
+}
+
+func (c *CoarseClock) Now() time.Time {
+	return *c.now.Load()
+}
+
+// Stop halts updates; Now keeps returning the last cached time. It is safe to
+// call more than once.
+func (c *CoarseClock) Stop() {
+	c.closeOnce.Do(func() {
+		close(c.stop)
+	})
+}
+
+func (c *CoarseClock) run(granularity time.Duration) {
+	ticker := time.NewTicker(granularity)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+			c.update()
+		}
+	}
+}
+
+func (c *CoarseClock) update() {
+	now := c.clock.Now()
+	c.now.Store(&now)
+}
@@ -0,0 +1,70 @@
+package limiter
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type countingClock struct {
+	calls atomic.Int64
+}
+
+func (c *countingClock) Now() time.Time {
+	c.calls.Add(1)
+	return time.Now()
+}
+
+func TestCoarseClock_StaysWithinGranularity(t *testing.T) {
+	granularity := 5 * time.Millisecond
+	clock := NewCoarseClock(RealClock{}, granularity)
+	defer clock.Stop()
+
+	for range 20 {
+		time.Sleep(time.Millisecond)
+
+		// Allow for scheduling delay on top of the tick.
+		if lag := time.Since(clock.Now()); lag < 0 || lag > 10*granularity {
+			t.Fatalf("expected the cached time to lag real time by about %v, got %v", granularity, lag)
+		}
+	}
+}
+
+func TestCoarseClock_StopFreezesTime(t *testing.T) {
+	mock := &MockClock{current: time.Now()}
+	clock := NewCoarseClock(mock, time.Millisecond)
+	clock.Stop()
+	clock.Stop()
+
+	frozen := clock.Now()
+	mock.Advance(time.Minute)
+	time.Sleep(5 * time.Millisecond)
+
+	if !clock.Now().Equal(frozen) {
+		t.Error("expected a stopped clock not to update")
+	}
+}
+
+func BenchmarkAllow_Clock(b *testing.B) {
+	inner := &countingClock{}
+	bucket := NewTokenBucket(1e9, 1e9, inner)
+
+	for b.Loop() {
+		bucket.Allow(1)
+	}
+
+	b.ReportMetric(float64(inner.calls.Load())/float64(b.N), "now-calls/op")
+}
+
+func BenchmarkAllow_CoarseClock(b *testing.B) {
+	inner := &countingClock{}
+	clock := NewCoarseClock(inner, time.Millisecond)
+	defer clock.Stop()
+	bucket := NewTokenBucket(1e9, 1e9, clock)
+
+	for b.Loop() {
+		bucket.Allow(1)
+	}
+
+	b.ReportMetric(float64(inner.calls.Load())/float64(b.N), "now-calls/op")
+}
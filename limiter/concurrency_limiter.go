@@ -0,0 +1,251 @@
+package limiter
+
+import (
+	"context"
+	_ "embed"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+//go:embed scripts/concurrency_acquire.lua
+var concurrencyAcquireScript string
+
+//go:embed scripts/concurrency_release.lua
+var concurrencyReleaseScript string
+
+var ErrConcurrencyLimit = errors.New("too many requests in flight")
+
+// defaultConcurrencyPollInterval is how often a blocked
+// RedisConcurrencyLimiter.Acquire retries.
+const defaultConcurrencyPollInterval = 10 * time.Millisecond
+
+type concurrencyConfig struct {
+	metrics      Metrics
+	pollInterval time.Duration
+}
+
+type ConcurrencyOption func(*concurrencyConfig)
+
+// WithConcurrencyMetrics reports each acquired slot to OnAllow and each
+// failed acquire to OnDeny.
+func WithConcurrencyMetrics(m Metrics) ConcurrencyOption {
+	return func(c *concurrencyConfig) {
+		c.metrics = m
+	}
+}
+
+// WithConcurrencyPollInterval sets how often a blocked
+// RedisConcurrencyLimiter.Acquire retries, 10ms by default. The in-memory
+// limiter wakes waiters on release and ignores it.
+func WithConcurrencyPollInterval(d time.Duration) ConcurrencyOption {
+	return func(c *concurrencyConfig) {
+		c.pollInterval = d
+	}
+}
+
+func newConcurrencyConfig(opts []ConcurrencyOption) concurrencyConfig {
+	c := concurrencyConfig{metrics: NoopMetrics{}, pollInterval: defaultConcurrencyPollInterval}
+	for _, opt := range opts {
+		opt(&c)
+	}
+
+	return c
+}
+
+// ConcurrencyLimiter bounds each key to at most limit holders at once, like a
+// semaphore per key. Token buckets limit how often requests start; this limits
+// how many are running, and the two are often used together.
+type ConcurrencyLimiter struct {
+	mu       sync.Mutex
+	inflight map[string]*inflight
+	limit    int
+	concurrencyConfig
+}
+
+type inflight struct {
+	count int
+	// freed is closed and replaced on every release to wake waiters.
+	freed chan struct{}
+}
+
+func NewConcurrencyLimiter(limit int, opts ...ConcurrencyOption) *ConcurrencyLimiter {
+	return &ConcurrencyLimiter{
+		inflight:          make(map[string]*inflight),
+		limit:             limit,
+		concurrencyConfig: newConcurrencyConfig(opts),
+	}
+}
+
+// Acquire blocks until key has a free slot or ctx is done. The returned
+// release frees the slot; it must be called once the work is done and is safe
+// to call more than once.
+func (cl *ConcurrencyLimiter) Acquire(ctx context.Context, key string) (func(), error) {
+	for {
+		release, freed := cl.tryAcquire(key)
+		if release != nil {
+			return release, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			cl.metrics.OnDeny(key)
+			return nil, ctx.Err()
+		case <-freed:
+		}
+	}
+}
+
+// TryAcquire behaves like Acquire but fails with ErrConcurrencyLimit instead
+// of blocking.
+func (cl *ConcurrencyLimiter) TryAcquire(key string) (func(), error) {
+	release, _ := cl.tryAcquire(key)
+	if release == nil {
+		cl.metrics.OnDeny(key)
+		return nil, ErrConcurrencyLimit
+	}
+
+	return release, nil
+}
+
+// InFlight returns how many holders key has.
+func (cl *ConcurrencyLimiter) InFlight(key string) int {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	if f, ok := cl.inflight[key]; ok {
+		return f.count
+	}
+
+	return 0
+}
+
+// tryAcquire takes a slot for key if one is free. Otherwise it returns a
+// channel closed on the key's next release.
+func (cl *ConcurrencyLimiter) tryAcquire(key string) (func(), <-chan struct{}) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	f, ok := cl.inflight[key]
+	if !ok {
+		f = &inflight{freed: make(chan struct{})}
+		cl.inflight[key] = f
+	}
+
+	if f.count >= cl.limit {
+		return nil, f.freed
+	}
+
+	f.count++
+	cl.metrics.OnAllow(key)
+
+	var once sync.Once
+	return func() { once.Do(func() { cl.release(key) }) }, nil
+}
+
+func (cl *ConcurrencyLimiter) release(key string) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	f := cl.inflight[key]
+	f.count--
+	close(f.freed)
+	f.freed = make(chan struct{})
+
+	if f.count == 0 {
+		delete(cl.inflight, key)
+	}
+}
+
+// RedisConcurrencyLimiter is the distributed ConcurrencyLimiter, counting each
+// key's holders with INCR and DECR. A holder that crashes never releases its
+// slot, so the count carries a TTL, pushed back on every acquire, after which
+// it resets; ttl should comfortably exceed the longest hold. If the count
+// expires under a holder that is still running, more than limit may briefly
+// hold the key.
+type RedisConcurrencyLimiter struct {
+	client        redis.Cmdable
+	acquireScript *redis.Script
+	releaseScript *redis.Script
+	limit         int
+	ttl           time.Duration
+	keyPrefix     string
+	concurrencyConfig
+}
+
+func NewRedisConcurrencyLimiter(client redis.Cmdable, limit int, ttl time.Duration, keyPrefix string, opts ...ConcurrencyOption) *RedisConcurrencyLimiter {
+	return &RedisConcurrencyLimiter{
+		client:            client,
+		acquireScript:     redis.NewScript(concurrencyAcquireScript),
+		releaseScript:     redis.NewScript(concurrencyReleaseScript),
+		limit:             limit,
+		ttl:               ttl,
+		keyPrefix:         keyPrefix,
+		concurrencyConfig: newConcurrencyConfig(opts),
+	}
+}
+
+// Acquire blocks until key has a free slot or ctx is done, polling Redis. The
+// returned release frees the slot and is safe to call more than once. Redis
+// errors are returned rather than failing open, since a slot that can't be
+// counted can't be released either.
+func (cl *RedisConcurrencyLimiter) Acquire(ctx context.Context, key string) (func(), error) {
+	for {
+		release, err := cl.tryAcquire(ctx, key)
+		if release != nil || err != nil {
+			return release, err
+		}
+
+		timer := time.NewTimer(cl.pollInterval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			cl.metrics.OnDeny(key)
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// TryAcquire behaves like Acquire but fails with ErrConcurrencyLimit instead
+// of blocking.
+func (cl *RedisConcurrencyLimiter) TryAcquire(ctx context.Context, key string) (func(), error) {
+	release, err := cl.tryAcquire(ctx, key)
+	if release == nil && err == nil {
+		cl.metrics.OnDeny(key)
+		return nil, ErrConcurrencyLimit
+	}
+
+	return release, err
+}
+
+func (cl *RedisConcurrencyLimiter) tryAcquire(ctx context.Context, key string) (func(), error) {
+	start := time.Now()
+	acquired, err := cl.acquireScript.Run(ctx, cl.client, []string{cl.keyPrefix + key}, cl.limit, cl.ttl.Milliseconds()).Int64()
+	cl.metrics.OnLatency(key, time.Since(start))
+
+	if err != nil {
+		cl.metrics.OnError(key, err)
+		return nil, err
+	}
+
+	if acquired != 1 {
+		return nil, nil
+	}
+
+	cl.metrics.OnAllow(key)
+
+	var once sync.Once
+	return func() { once.Do(func() { cl.release(key) }) }, nil
+}
+
+// release frees a slot. It runs on a fresh context, since the caller's may
+// well be done by the time the work is.
+func (cl *RedisConcurrencyLimiter) release(key string) {
+	err := cl.releaseScript.Run(context.Background(), cl.client, []string{cl.keyPrefix + key}).Err()
+	if err != nil {
+		cl.metrics.OnError(key, err)
+	}
+}
@@ -0,0 +1,123 @@
+package limiter
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestConcurrencyLimiter_BoundsHoldersPerKey(t *testing.T) {
+	metrics := &MockMetrics{}
+	cl := NewConcurrencyLimiter(2, WithConcurrencyMetrics(metrics))
+
+	first, err := cl.TryAcquire("user1")
+	if err != nil {
+		t.Fatalf("expected the first acquire to succeed, got %v", err)
+	}
+	if _, err := cl.TryAcquire("user1"); err != nil {
+		t.Fatalf("expected the second acquire to succeed, got %v", err)
+	}
+	if _, err := cl.TryAcquire("user1"); !errors.Is(err, ErrConcurrencyLimit) {
+		t.Fatalf("expected ErrConcurrencyLimit, got %v", err)
+	}
+	if _, err := cl.TryAcquire("user2"); err != nil {
+		t.Errorf("expected other keys to be unaffected, got %v", err)
+	}
+
+	first()
+	first()
+	if got := cl.InFlight("user1"); got != 1 {
+		t.Errorf("expected a double release to free one slot, %d in flight", got)
+	}
+
+	if len(metrics.allows) != 3 || len(metrics.denies) != 1 {
+		t.Errorf("expected 3 allows and 1 deny, got %v and %v", metrics.allows, metrics.denies)
+	}
+}
+
+func TestConcurrencyLimiter_AcquireBlocksUntilRelease(t *testing.T) {
+	cl := NewConcurrencyLimiter(1)
+	release, _ := cl.TryAcquire("user1")
+
+	acquired := make(chan error)
+	go func() {
+		_, err := cl.Acquire(context.Background(), "user1")
+		acquired <- err
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("expected Acquire to block while the key is full")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	release()
+
+	select {
+	case err := <-acquired:
+		if err != nil {
+			t.Errorf("expected Acquire to succeed after the release, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the release to wake Acquire")
+	}
+}
+
+func TestConcurrencyLimiter_AcquireHonorsContext(t *testing.T) {
+	cl := NewConcurrencyLimiter(1)
+	cl.TryAcquire("user1")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if _, err := cl.Acquire(ctx, "user1"); err != context.DeadlineExceeded {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+}
+
+func TestRedisConcurrencyLimiter_BoundsHolders(t *testing.T) {
+	mr, client := setupMiniRedis(t)
+	cl := NewRedisConcurrencyLimiter(client, 1, time.Minute, "inflight:")
+	ctx := context.Background()
+
+	release, err := cl.TryAcquire(ctx, "user1")
+	if err != nil {
+		t.Fatalf("expected the first acquire to succeed, got %v", err)
+	}
+	if _, err := cl.TryAcquire(ctx, "user1"); !errors.Is(err, ErrConcurrencyLimit) {
+		t.Fatalf("expected ErrConcurrencyLimit, got %v", err)
+	}
+
+	release()
+	release()
+	if mr.Exists("inflight:user1") {
+		t.Error("expected the count to be deleted once the last holder released")
+	}
+
+	if _, err := cl.Acquire(ctx, "user1"); err != nil {
+		t.Errorf("expected Acquire to succeed after the release, got %v", err)
+	}
+}
+
+func TestRedisConcurrencyLimiter_TTLFreesCrashedHolders(t *testing.T) {
+	mr, client := setupMiniRedis(t)
+	cl := NewRedisConcurrencyLimiter(client, 1, time.Second, "inflight:")
+	ctx := context.Background()
+
+	// A holder that never releases.
+	cl.TryAcquire(ctx, "user1")
+	mr.FastForward(time.Second)
+
+	release, err := cl.TryAcquire(ctx, "user1")
+	if err != nil {
+		t.Fatalf("expected the TTL to free the crashed holder's slot, got %v", err)
+	}
+
+	// Releasing after the count expired must not take it below zero.
+	mr.FastForward(time.Second)
+	release()
+	if mr.Exists("inflight:user1") {
+		t.Error("expected a release after expiry to leave no count behind")
+	}
+}
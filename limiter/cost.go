@@ -0,0 +1,35 @@
+package limiter
+
+import "context"
+
+// CostFunc adjusts the cost of a request, e.g. by tier or endpoint weight.
+type CostFunc func(ctx context.Context, key string, cost int) int
+
+// CostPipeline applies CostFuncs in order to a request's base cost. The result
+// is clamped to [1, maxCost] so no combination of modifiers can make a request
+// free or exceed what a bucket could ever hold. A base cost of zero, which only
+// touches the bucket, is passed through unchanged.
+type CostPipeline struct {
+	funcs   []CostFunc
+	maxCost int
+}
+
+func NewCostPipeline(maxCost int, funcs ...CostFunc) *CostPipeline {
+	return &CostPipeline{
+		funcs:   funcs,
+		maxCost: maxCost,
+	}
+}
+
+func (p *CostPipeline) Cost(ctx context.Context, key string, base int) int {
+	if base == 0 {
+		return 0
+	}
+
+	cost := base
+	for _, fn := range p.funcs {
+		cost = fn(ctx, key, cost)
+	}
+
+	return min(max(cost, 1), p.maxCost)
+}
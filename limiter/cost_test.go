@@ -0,0 +1,62 @@
+package limiter
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func multiplier(n int) CostFunc {
+	return func(ctx context.Context, key string, cost int) int {
+		return cost * n
+	}
+}
+
+func endpointWeight(ctx context.Context, key string, cost int) int {
+	if strings.HasSuffix(key, ":search") {
+		return cost + 3
+	}
+	return cost
+}
+
+func TestCostPipeline_AppliesInOrder(t *testing.T) {
+	pipeline := NewCostPipeline(100, multiplier(2), endpointWeight)
+
+	if cost := pipeline.Cost(context.Background(), "user-1:search", 1); cost != 5 {
+		t.Errorf("expected cost 5, got %d", cost)
+	}
+
+	if cost := pipeline.Cost(context.Background(), "user-1:get", 1); cost != 2 {
+		t.Errorf("expected cost 2, got %d", cost)
+	}
+}
+
+func TestCostPipeline_ClampsResult(t *testing.T) {
+	pipeline := NewCostPipeline(10, multiplier(0))
+
+	if cost := pipeline.Cost(context.Background(), "user-1", 5); cost != 1 {
+		t.Errorf("expected cost to be clamped to 1, got %d", cost)
+	}
+
+	pipeline = NewCostPipeline(10, multiplier(100))
+
+	if cost := pipeline.Cost(context.Background(), "user-1", 5); cost != 10 {
+		t.Errorf("expected cost to be clamped to 10, got %d", cost)
+	}
+}
+
+func TestCostPipeline_ZeroBaseStaysFree(t *testing.T) {
+	pipeline := NewCostPipeline(10, multiplier(3))
+
+	if cost := pipeline.Cost(context.Background(), "user-1", 0); cost != 0 {
+		t.Errorf("expected a zero-token touch to stay free, got %d", cost)
+	}
+
+	_, client := setupMiniRedis(t)
+	limiter := NewRedisLimiter(client, 5, 0, "ratelimit:", WithCostPipeline(pipeline))
+	limiter.Allow("user-1", 0)
+
+	if remaining, _ := limiter.Remaining("user-1"); remaining != 5 {
+		t.Errorf("expected Allow(key, 0) not to consume, got %f remaining", remaining)
+	}
+}
@@ -0,0 +1,80 @@
+package limiter
+
+import (
+	"context"
+	"time"
+)
+
+// DualRateLimiter separates burst tolerance from the sustained rate by drawing
+// each key's requests from two buckets: a small, fast refilling burst bucket
+// that bounds how many requests can arrive at once, and a large, slow
+// refilling sustained bucket that caps the long-term average. A request is
+// allowed only if both buckets have the tokens; if the sustained bucket
+// denies, the burst bucket's tokens are refunded.
+type DualRateLimiter struct {
+	burst     *KeyedLimiter
+	sustained *KeyedLimiter
+}
+
+// NewDualRate returns a limiter allowing bursts of up to burstCap refilling at
+// burstRate, while over any period T no key is allowed more than sustainedCap +
+// sustainedRate*T tokens.
+func NewDualRate(burstCap float64, burstRate float64, sustainedCap float64, sustainedRate float64, clock Clock, opts ...KeyedOption) *DualRateLimiter {
+	return &DualRateLimiter{
+		burst:     NewKeyedLimiter(burstCap, burstRate, clock, opts...),
+		sustained: NewKeyedLimiter(sustainedCap, sustainedRate, clock, opts...),
+	}
+}
+
+func (d *DualRateLimiter) Allow(key string, tokens int) bool {
+	allowed, _ := d.AllowWithRetry(key, tokens)
+	return allowed
+}
+
+// AllowWithRetry behaves like Allow and, on denial, returns how long until
+// whichever bucket denied the request can satisfy it.
+func (d *DualRateLimiter) AllowWithRetry(key string, tokens int) (bool, time.Duration) {
+	burst := d.burst.getOrCreateBucket(key)
+
+	if allowed, retryAfter := burst.AllowWithRetry(tokens); !allowed {
+		return false, retryAfter
+	}
+
+	if allowed, retryAfter := d.sustained.AllowWithRetry(key, tokens); !allowed {
+		burst.refund(float64(tokens))
+		return false, retryAfter
+	}
+
+	return true, 0
+}
+
+func (d *DualRateLimiter) Wait(ctx context.Context, key string, tokens int) error {
+	if tokens < 0 {
+		return ErrNegativeTokens
+	}
+
+	for {
+		allowed, waitDuration := d.AllowWithRetry(key, tokens)
+		if allowed {
+			return nil
+		}
+
+		if waitDuration == NeverAvailable {
+			return ErrExceedsCapacity
+		}
+
+		timer := time.NewTimer(waitDuration)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// Close stops the buckets' idle sweepers, if any were configured.
+func (d *DualRateLimiter) Close() error {
+	d.burst.Close()
+	return d.sustained.Close()
+}
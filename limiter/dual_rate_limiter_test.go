@@ -0,0 +1,59 @@
+package limiter
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestDualRateLimiter_AllowsBurst(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	limiter := NewDualRate(5, 5, 60, 1, clock)
+
+	for i := range 5 {
+		if !limiter.Allow("key", 1) {
+			t.Fatalf("expected request %d of the burst to be allowed", i+1)
+		}
+	}
+
+	if allowed, retryAfter := limiter.AllowWithRetry("key", 1); allowed || retryAfter != 200*time.Millisecond {
+		t.Errorf("expected the burst bucket to deny with a 200ms retry, got %v, %v", allowed, retryAfter)
+	}
+}
+
+func TestDualRateLimiter_EnforcesSustainedRate(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	limiter := NewDualRate(5, 5, 60, 1, clock)
+
+	// Offer 10 requests a second for a minute. The burst bucket alone would
+	// allow 5 + 5*60 = 305, but the sustained bucket caps the minute at its
+	// capacity plus a minute of refill: 60 + 1*60 = 120.
+	allowed := 0
+	for range 600 {
+		clock.Advance(100 * time.Millisecond)
+		if limiter.Allow("key", 1) {
+			allowed++
+		}
+	}
+
+	if allowed < 119 || allowed > 120 {
+		t.Errorf("expected about 120 requests allowed over the minute, got %d", allowed)
+	}
+
+	// Denials by the sustained bucket refund the burst bucket, so once the
+	// sustained bucket refills the burst is available again.
+	clock.Advance(5 * time.Second)
+	for i := range 5 {
+		if !limiter.Allow("key", 1) {
+			t.Fatalf("expected request %d of a fresh burst to be allowed", i+1)
+		}
+	}
+}
+
+func TestDualRateLimiter_WaitExceedsCapacity(t *testing.T) {
+	limiter := NewDualRate(5, 5, 60, 1, RealClock{})
+
+	if err := limiter.Wait(context.Background(), "key", 6); err != ErrExceedsCapacity {
+		t.Errorf("expected ErrExceedsCapacity, got %v", err)
+	}
+}
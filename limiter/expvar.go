@@ -0,0 +1,42 @@
+package limiter
+
+import (
+	"expvar"
+	"fmt"
+	"sync"
+)
+
+// expvarMu makes PublishExpvar's check and publish atomic, since expvar.Publish
+// panics on a duplicate name.
+var expvarMu sync.Mutex
+
+// PublishExpvar publishes the limiter's decision totals, failover counts and
+// circuit breaker state as the expvar name, which expvar serves as JSON at
+// /debug/vars on http.DefaultServeMux. Each Wait poll counts as a decision.
+// It returns an error if name is already published.
+func (r *RedisLimiter) PublishExpvar(name string) error {
+	expvarMu.Lock()
+	defer expvarMu.Unlock()
+
+	if expvar.Get(name) != nil {
+		return fmt.Errorf("expvar %q is already published", name)
+	}
+
+	expvar.Publish(name, expvar.Func(r.expvarStats))
+	return nil
+}
+
+func (r *RedisLimiter) expvarStats() any {
+	failover := r.FailoverStats()
+
+	return map[string]any{
+		"allows":        r.allows.Load(),
+		"denies":        r.denies.Load(),
+		"errors":        r.errors.Load(),
+		"circuit_state": r.CircuitState().String(),
+		"redis_served":  failover.RedisServed,
+		"failed_open":   failover.FailedOpen,
+		"failed_closed": failover.FailedClosed,
+		"degraded":      failover.Degraded,
+	}
+}
@@ -0,0 +1,61 @@
+package limiter
+
+import (
+	"encoding/json"
+	"expvar"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestRedisLimiter_PublishExpvar(t *testing.T) {
+	_, client := setupMiniRedis(t)
+	limiter := NewRedisLimiter(client, 1, 0, "ratelimit:", WithCircuitBreaker(5, 0))
+
+	if err := limiter.PublishExpvar("test_ratelimit"); err != nil {
+		t.Fatalf("expected publish to succeed, got %v", err)
+	}
+	if err := limiter.PublishExpvar("test_ratelimit"); err == nil {
+		t.Error("expected publishing the same name twice to fail")
+	}
+
+	limiter.Allow("user1", 1)
+	limiter.Allow("user1", 1)
+
+	var stats struct {
+		Allows       int64  `json:"allows"`
+		Denies       int64  `json:"denies"`
+		Errors       int64  `json:"errors"`
+		CircuitState string `json:"circuit_state"`
+		RedisServed  int64  `json:"redis_served"`
+	}
+	if err := json.Unmarshal([]byte(expvar.Get("test_ratelimit").String()), &stats); err != nil {
+		t.Fatalf("expected JSON stats, got %v", err)
+	}
+
+	if stats.Allows != 1 || stats.Denies != 1 || stats.Errors != 0 || stats.RedisServed != 2 || stats.CircuitState != "closed" {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestRedisLimiter_PublishExpvarConcurrently(t *testing.T) {
+	_, client := setupMiniRedis(t)
+	limiter := NewRedisLimiter(client, 1, 0, "ratelimit:")
+
+	var wg sync.WaitGroup
+	var published atomic.Int64
+	for range 16 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if limiter.PublishExpvar("test_ratelimit_concurrent") == nil {
+				published.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if published.Load() != 1 {
+		t.Errorf("expected exactly one publish to succeed, got %d", published.Load())
+	}
+}
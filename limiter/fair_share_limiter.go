@@ -0,0 +1,154 @@
+package limiter
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+type fairShareKey struct {
+	bucket   *TokenBucket
+	lastSeen time.Time
+}
+
+// FairShareLimiter splits a total capacity and refill rate evenly across the
+// keys that have been seen within the idle timeout. Shares are only recomputed
+// once the active key count moves by more than the hysteresis fraction, so a
+// key coming and going doesn't resize every bucket on each call.
+type FairShareLimiter struct {
+	mu          sync.Mutex
+	keys        map[string]*fairShareKey
+	capacity    float64
+	refillRate  float64
+	idleTimeout time.Duration
+	hysteresis  float64
+	activeCount int
+	lastPrune   time.Time
+	clock       Clock
+}
+
+type FairShareOption func(*FairShareLimiter)
+
+func WithShareHysteresis(fraction float64) FairShareOption {
+	return func(fl *FairShareLimiter) {
+		fl.hysteresis = fraction
+	}
+}
+
+func NewFairShareLimiter(capacity float64, refillRate float64, idleTimeout time.Duration, clock Clock, opts ...FairShareOption) *FairShareLimiter {
+	fl := &FairShareLimiter{
+		keys:        make(map[string]*fairShareKey),
+		capacity:    capacity,
+		refillRate:  refillRate,
+		idleTimeout: idleTimeout,
+		hysteresis:  0.1,
+		activeCount: 1,
+		lastPrune:   clock.Now(),
+		clock:       clock,
+	}
+
+	for _, opt := range opts {
+		opt(fl)
+	}
+
+	return fl
+}
+
+func (fl *FairShareLimiter) Allow(key string, tokens int) bool {
+	return fl.touch(key).Allow(tokens)
+}
+
+func (fl *FairShareLimiter) Wait(ctx context.Context, key string, tokens int) error {
+	if tokens < 0 {
+		return ErrNegativeTokens
+	}
+
+	if float64(tokens) > fl.capacity {
+		return ErrExceedsCapacity
+	}
+
+	for {
+		bucket := fl.touch(key)
+		if bucket.Allow(tokens) {
+			return nil
+		}
+
+		// A request over the key's current share never fits until the share
+		// grows, which happens on a rebalance. Wake on that, and touch again
+		// every half idle timeout so idle keys are pruned meanwhile.
+		bucket.mu.Lock()
+		waitDuration := bucket.timeUntilAvailable(float64(tokens))
+		changed := bucket.changed
+		bucket.mu.Unlock()
+
+		if waitDuration == NeverAvailable && fl.idleTimeout > 0 {
+			waitDuration = fl.idleTimeout / 2
+		}
+
+		timer := time.NewTimer(waitDuration)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-changed:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// Share returns the capacity currently allotted to each active key.
+func (fl *FairShareLimiter) Share() float64 {
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+
+	return fl.capacity / float64(fl.activeCount)
+}
+
+// touch marks the key as active, rebalancing shares if the active key count
+// has shifted, and returns its bucket.
+func (fl *FairShareLimiter) touch(key string) *TokenBucket {
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+
+	now := fl.clock.Now()
+
+	if now.Sub(fl.lastPrune) >= fl.idleTimeout/2 {
+		for k, v := range fl.keys {
+			if now.Sub(v.lastSeen) > fl.idleTimeout {
+				delete(fl.keys, k)
+			}
+		}
+		fl.lastPrune = now
+	}
+
+	entry, ok := fl.keys[key]
+	if !ok {
+		n := float64(fl.activeCount)
+		entry = &fairShareKey{
+			bucket: NewTokenBucket(fl.capacity/n, fl.refillRate/n, fl.clock),
+		}
+		fl.keys[key] = entry
+	}
+	entry.lastSeen = now
+
+	fl.rebalance()
+
+	return entry.bucket
+}
+
+// rebalance recomputes every key's share once the active key count has moved
+// past the hysteresis band. Must be called with fl.mu held.
+func (fl *FairShareLimiter) rebalance() {
+	n := max(len(fl.keys), 1)
+
+	if math.Abs(float64(n-fl.activeCount)) <= fl.hysteresis*float64(fl.activeCount) {
+		return
+	}
+
+	fl.activeCount = n
+	for _, v := range fl.keys {
+		v.bucket.SetLimits(fl.capacity/float64(n), fl.refillRate/float64(n))
+	}
+}
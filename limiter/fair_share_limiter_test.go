@@ -0,0 +1,129 @@
+package limiter
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestFairShareLimiter_SingleKeyGetsFullCapacity(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	limiter := NewFairShareLimiter(100, 10, time.Minute, clock)
+
+	if !limiter.Allow("user-1", 100) {
+		t.Error("expected a lone key to use the full capacity")
+	}
+
+	if limiter.Allow("user-1", 1) {
+		t.Error("expected allow to return false once capacity is used")
+	}
+}
+
+func TestFairShareLimiter_ShareShrinksAsKeysBecomeActive(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	limiter := NewFairShareLimiter(100, 10, time.Minute, clock)
+
+	limiter.Allow("user-1", 0)
+	limiter.Allow("user-2", 0)
+	limiter.Allow("user-3", 0)
+	limiter.Allow("user-4", 0)
+
+	if limiter.Share() != 25 {
+		t.Errorf("expected share to be 25, got %f", limiter.Share())
+	}
+
+	if limiter.Allow("user-1", 26) {
+		t.Error("expected allow to return false above the share")
+	}
+
+	if !limiter.Allow("user-1", 25) {
+		t.Error("expected allow to return true within the share")
+	}
+}
+
+func TestFairShareLimiter_ShareGrowsAsKeysIdleOut(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	limiter := NewFairShareLimiter(100, 10, time.Minute, clock)
+
+	limiter.Allow("user-1", 0)
+	limiter.Allow("user-2", 0)
+	limiter.Allow("user-3", 0)
+	limiter.Allow("user-4", 0)
+
+	clock.Advance(2 * time.Minute)
+	limiter.Allow("user-1", 0)
+
+	if limiter.Share() != 100 {
+		t.Errorf("expected share to be 100, got %f", limiter.Share())
+	}
+
+	clock.Advance(10 * time.Second)
+
+	if !limiter.Allow("user-1", 100) {
+		t.Error("expected the remaining key to use the released capacity")
+	}
+}
+
+func TestFairShareLimiter_Hysteresis(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	limiter := NewFairShareLimiter(100, 10, time.Minute, clock, WithShareHysteresis(0.5))
+
+	for _, key := range []string{"a", "b", "c", "d"} {
+		limiter.Allow(key, 0)
+	}
+	share := limiter.Share()
+
+	limiter.Allow("e", 0)
+
+	if limiter.Share() != share {
+		t.Errorf("expected share to hold at %f within the hysteresis band, got %f", share, limiter.Share())
+	}
+}
+
+func TestFairShareLimiter_WaitExceedsCapacity(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	limiter := NewFairShareLimiter(100, 10, time.Minute, clock)
+
+	err := limiter.Wait(context.Background(), "user-1", 101)
+
+	if err != ErrExceedsCapacity {
+		t.Errorf("expected ErrExceedsCapacity, got %v", err)
+	}
+}
+
+func TestFairShareLimiter_WaitWakesWhenShareGrows(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	limiter := NewFairShareLimiter(100, 100, time.Minute, clock)
+
+	for _, key := range []string{"user-1", "user-2", "user-3", "user-4"} {
+		limiter.Allow(key, 0)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- limiter.Wait(ctx, "user-1", 50)
+	}()
+
+	// user-1 stays active while the others idle out.
+	time.Sleep(20 * time.Millisecond)
+	clock.Advance(50 * time.Second)
+	limiter.Allow("user-1", 0)
+	clock.Advance(40 * time.Second)
+	limiter.Allow("user-1", 0)
+
+	// The grown share refills the rest of the request at the new rate.
+	time.Sleep(20 * time.Millisecond)
+	clock.Advance(time.Second)
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("expected Wait to succeed once the share grew, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("expected Wait to wake when the share grew")
+	}
+}
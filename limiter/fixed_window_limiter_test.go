@@ -0,0 +1,71 @@
+package limiter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func TestFixedWindowLimiter_EnforcesLimit(t *testing.T) {
+	mr, client := setupMiniRedis(t)
+	clock := &MockClock{current: time.Date(2025, 1, 1, 12, 0, 10, 0, time.UTC)}
+	metrics := &MockMetrics{}
+	limiter := NewFixedWindowLimiter(client, 3, time.Minute, "fw:",
+		WithFixedWindowClock(clock), WithFixedWindowMetrics(metrics))
+
+	if !limiter.Allow("user-1", 2) {
+		t.Error("expected 2 tokens to be allowed")
+	}
+
+	if limiter.Allow("user-1", 2) {
+		t.Error("expected 2 more tokens to exceed the limit")
+	}
+
+	if !limiter.Allow("user-1", 1) {
+		t.Error("expected a denied request not to consume the window")
+	}
+
+	key := "fw:user-1:" + "1735732800000"
+	if got, _ := mr.Get(key); got != "3" {
+		t.Errorf("expected count 3 under %s, got %q", key, got)
+	}
+
+	if ttl := mr.TTL(key); ttl != 50*time.Second {
+		t.Errorf("expected TTL of the remaining 50s, got %v", ttl)
+	}
+
+	if len(metrics.allows) != 2 || len(metrics.denies) != 1 || len(metrics.latencies) != 3 {
+		t.Errorf("expected 2 allows, 1 deny and 3 latencies, got %d, %d, %d",
+			len(metrics.allows), len(metrics.denies), len(metrics.latencies))
+	}
+
+	clock.Advance(time.Minute)
+	if !limiter.Allow("user-1", 3) {
+		t.Error("expected the next window to start fresh")
+	}
+}
+
+func TestFixedWindowLimiter_FailsOpen(t *testing.T) {
+	client := redis.NewClient(&redis.Options{Addr: "localhost:9999", MaxRetries: -1})
+	metrics := &MockMetrics{}
+	limiter := NewFixedWindowLimiter(client, 1, time.Minute, "fw:", WithFixedWindowMetrics(metrics))
+
+	if !limiter.Allow("user-1", 5) {
+		t.Error("expected redis errors to fail open")
+	}
+
+	if len(metrics.errors) != 1 {
+		t.Errorf("expected 1 error, got %d", len(metrics.errors))
+	}
+}
+
+func TestFixedWindowLimiter_WaitExceedsCapacity(t *testing.T) {
+	_, client := setupMiniRedis(t)
+	limiter := NewFixedWindowLimiter(client, 3, time.Minute, "fw:")
+
+	if err := limiter.Wait(context.Background(), "user-1", 4); err != ErrExceedsCapacity {
+		t.Errorf("expected ErrExceedsCapacity, got %v", err)
+	}
+}
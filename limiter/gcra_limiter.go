@@ -0,0 +1,227 @@
+package limiter
+
+import (
+	"context"
+	_ "embed"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+//go:embed scripts/gcra.lua
+var gcraScript string
+
+// GCRALimiter paces each key to limit requests per period with the generic
+// cell rate algorithm, admitting bursts of up to burst requests. Only a single
+// theoretical arrival time (TAT) is stored per key, so memory stays constant
+// regardless of the limit. Keys whose TAT has passed are dropped on their next
+// call, or by EvictIdle for keys that aren't seen again. A limit of zero or
+// less admits nothing.
+type GCRALimiter struct {
+	mu       sync.Mutex
+	tats     map[string]time.Time
+	interval time.Duration
+	burst    int
+	clock    Clock
+	sweeper  *Sweeper
+}
+
+type GCRAOption func(*GCRALimiter)
+
+// WithGCRASweeper registers the limiter with a shared Sweeper, which drops
+// keys whose TAT has passed. Close deregisters it.
+func WithGCRASweeper(s *Sweeper) GCRAOption {
+	return func(g *GCRALimiter) {
+		g.sweeper = s
+	}
+}
+
+func NewGCRALimiter(period time.Duration, limit int, burst int, clock Clock, opts ...GCRAOption) *GCRALimiter {
+	g := &GCRALimiter{
+		tats:     make(map[string]time.Time),
+		interval: gcraInterval(period, limit),
+		burst:    burst,
+		clock:    clock,
+	}
+
+	for _, opt := range opts {
+		opt(g)
+	}
+
+	if g.sweeper != nil {
+		g.sweeper.Register(g)
+	}
+
+	return g
+}
+
+// gcraInterval returns the emission interval, or NeverAvailable if limit
+// admits nothing.
+func gcraInterval(period time.Duration, limit int) time.Duration {
+	if limit <= 0 {
+		return NeverAvailable
+	}
+
+	return period / time.Duration(limit)
+}
+
+// EvictIdle drops every key whose TAT has passed, which a later call would
+// treat the same as a new key.
+func (g *GCRALimiter) EvictIdle() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := g.clock.Now()
+	evicted := 0
+	for key, tat := range g.tats {
+		if !tat.After(now) {
+			delete(g.tats, key)
+			evicted++
+		}
+	}
+
+	return evicted
+}
+
+// Close deregisters from the shared Sweeper, if any. It always returns nil.
+func (g *GCRALimiter) Close() error {
+	if g.sweeper != nil {
+		g.sweeper.Deregister(g)
+	}
+
+	return nil
+}
+
+func (g *GCRALimiter) Allow(key string, tokens int) bool {
+	allowed, _ := g.allow(key, tokens)
+	return allowed
+}
+
+func (g *GCRALimiter) Wait(ctx context.Context, key string, tokens int) error {
+	if tokens < 0 {
+		return ErrNegativeTokens
+	}
+
+	if tokens > g.burst || g.interval == NeverAvailable {
+		return ErrExceedsCapacity
+	}
+
+	for {
+		allowed, waitDuration := g.allow(key, tokens)
+		if allowed {
+			return nil
+		}
+
+		timer := time.NewTimer(waitDuration)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// allow advances the key's TAT by one emission interval per token if the
+// result stays within the burst tolerance. On denial it returns how long until
+// it would.
+func (g *GCRALimiter) allow(key string, tokens int) (bool, time.Duration) {
+	if tokens < 0 || tokens > g.burst || g.interval == NeverAvailable {
+		return false, NeverAvailable
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := g.clock.Now()
+	tat, ok := g.tats[key]
+	if !ok || tat.Before(now) {
+		tat = now
+	}
+
+	newTat := tat.Add(time.Duration(tokens) * g.interval)
+	if wait := newTat.Sub(now) - time.Duration(g.burst)*g.interval; wait > 0 {
+		return false, wait
+	}
+
+	if newTat.After(now) {
+		g.tats[key] = newTat
+	} else {
+		delete(g.tats, key)
+	}
+
+	return true, 0
+}
+
+// RedisGCRALimiter is the distributed GCRALimiter. Each key holds just its TAT
+// in microseconds of Redis server time and expires once the TAT has passed. A
+// limit of zero or less admits nothing.
+type RedisGCRALimiter struct {
+	client    redis.Cmdable
+	script    *redis.Script
+	interval  time.Duration
+	burst     int
+	keyPrefix string
+}
+
+func NewRedisGCRALimiter(client redis.Cmdable, period time.Duration, limit int, burst int, keyPrefix string) *RedisGCRALimiter {
+	return &RedisGCRALimiter{
+		client:    client,
+		script:    redis.NewScript(gcraScript),
+		interval:  gcraInterval(period, limit),
+		burst:     burst,
+		keyPrefix: keyPrefix,
+	}
+}
+
+// Allow reports whether the request conforms. Redis errors fail open.
+func (g *RedisGCRALimiter) Allow(key string, tokens int) bool {
+	allowed, _, err := g.allow(context.Background(), key, tokens)
+	if err != nil {
+		return true
+	}
+
+	return allowed
+}
+
+func (g *RedisGCRALimiter) Wait(ctx context.Context, key string, tokens int) error {
+	if tokens < 0 {
+		return ErrNegativeTokens
+	}
+
+	if tokens > g.burst || g.interval == NeverAvailable {
+		return ErrExceedsCapacity
+	}
+
+	for {
+		allowed, waitDuration, err := g.allow(ctx, key, tokens)
+		if err != nil || allowed {
+			return err
+		}
+
+		timer := time.NewTimer(waitDuration)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func (g *RedisGCRALimiter) allow(ctx context.Context, key string, tokens int) (bool, time.Duration, error) {
+	if tokens < 0 || tokens > g.burst || g.interval == NeverAvailable {
+		return false, NeverAvailable, nil
+	}
+
+	interval := strconv.FormatFloat(float64(g.interval)/float64(time.Microsecond), 'f', -1, 64)
+	result, err := g.script.Run(ctx, g.client, []string{g.keyPrefix + key}, tokens, interval, g.burst).Result()
+	if err != nil {
+		return false, 0, err
+	}
+
+	resSlice := result.([]interface{})
+	return resSlice[0].(int64) == 1, time.Duration(resSlice[1].(int64)) * time.Microsecond, nil
+}
@@ -0,0 +1,142 @@
+package limiter
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestGCRALimiter_AllowsBurstThenPaces(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	limiter := NewGCRALimiter(time.Second, 10, 3, clock)
+
+	for i := range 3 {
+		if !limiter.Allow("user-1", 1) {
+			t.Errorf("burst request %d should be allowed", i+1)
+		}
+	}
+
+	if limiter.Allow("user-1", 1) {
+		t.Error("expected the burst to be exhausted")
+	}
+
+	clock.Advance(100 * time.Millisecond)
+	if !limiter.Allow("user-1", 1) {
+		t.Error("expected one request per emission interval")
+	}
+
+	if limiter.Allow("user-1", 1) {
+		t.Error("expected pacing to deny a second request in the same interval")
+	}
+
+	_, wait := limiter.allow("user-1", 1)
+	if wait != 100*time.Millisecond {
+		t.Errorf("expected a 100ms wait, got %v", wait)
+	}
+}
+
+func TestGCRALimiter_DropsConformingKeys(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	limiter := NewGCRALimiter(time.Second, 10, 3, clock)
+
+	limiter.Allow("user-1", 2)
+	clock.Advance(time.Second)
+	limiter.Allow("user-1", 0)
+
+	if _, ok := limiter.tats["user-1"]; ok {
+		t.Error("expected a key with a passed TAT to be dropped")
+	}
+}
+
+func TestGCRALimiter_EvictIdleDropsPassedTATs(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	limiter := NewGCRALimiter(time.Second, 10, 3, clock)
+
+	limiter.Allow("user-1", 1)
+	limiter.Allow("user-2", 3)
+	clock.Advance(200 * time.Millisecond)
+
+	if evicted := limiter.EvictIdle(); evicted != 1 {
+		t.Errorf("expected 1 key evicted, got %d", evicted)
+	}
+
+	if _, ok := limiter.tats["user-2"]; !ok {
+		t.Error("expected a key still being paced to be kept")
+	}
+}
+
+func TestGCRALimiter_SweeperEvicts(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	sweeper := NewSweeper(10 * time.Millisecond)
+	defer sweeper.Close()
+
+	limiter := NewGCRALimiter(time.Second, 10, 3, clock, WithGCRASweeper(sweeper))
+	defer limiter.Close()
+
+	limiter.Allow("user-1", 1)
+	clock.Advance(time.Second)
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		limiter.mu.Lock()
+		n := len(limiter.tats)
+		limiter.mu.Unlock()
+		if n == 0 {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Error("expected the sweeper to drop the passed TAT")
+}
+
+func TestGCRALimiter_ZeroLimitDeniesAll(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	limiter := NewGCRALimiter(time.Second, 0, 3, clock)
+
+	if limiter.Allow("user-1", 1) {
+		t.Error("expected a zero limit to deny")
+	}
+
+	if err := limiter.Wait(context.Background(), "user-1", 1); err != ErrExceedsCapacity {
+		t.Errorf("expected ErrExceedsCapacity, got %v", err)
+	}
+
+	_, client := setupMiniRedis(t)
+	if NewRedisGCRALimiter(client, time.Second, 0, 3, "gcra:").Allow("user-1", 1) {
+		t.Error("expected a zero limit to deny in Redis too")
+	}
+}
+
+func TestGCRALimiter_WaitExceedsBurst(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	limiter := NewGCRALimiter(time.Second, 10, 3, clock)
+
+	if err := limiter.Wait(context.Background(), "user-1", 4); err != ErrExceedsCapacity {
+		t.Errorf("expected ErrExceedsCapacity, got %v", err)
+	}
+}
+
+func TestRedisGCRALimiter_AllowsBurstThenPaces(t *testing.T) {
+	mr, client := setupMiniRedis(t)
+	now := time.Now()
+	mr.SetTime(now)
+	limiter := NewRedisGCRALimiter(client, time.Second, 10, 3, "gcra:")
+
+	if !limiter.Allow("user-1", 3) {
+		t.Error("expected the full burst to be allowed")
+	}
+
+	allowed, wait, err := limiter.allow(context.Background(), "user-1", 1)
+	if allowed || err != nil || wait != 100*time.Millisecond {
+		t.Errorf("expected deny with a 100ms wait, got %v, %v, %v", allowed, wait, err)
+	}
+
+	mr.SetTime(now.Add(100 * time.Millisecond))
+	if !limiter.Allow("user-1", 1) {
+		t.Error("expected one request after an emission interval")
+	}
+
+	if ttl := mr.TTL("gcra:user-1"); ttl != 300*time.Millisecond {
+		t.Errorf("expected the key to expire with its TAT, got %v", ttl)
+	}
+}
@@ -0,0 +1,88 @@
+package limiter
+
+import (
+	"context"
+	"time"
+)
+
+// GlobalCappedKeyedLimiter limits each key like a KeyedLimiter while also
+// drawing every request from one shared bucket, so the total granted across
+// all keys can't exceed the global rate however many keys there are. A request
+// the global bucket denies is refunded to its key.
+type GlobalCappedKeyedLimiter struct {
+	keyed  *KeyedLimiter
+	global *TokenBucket
+}
+
+func NewGlobalCappedKeyedLimiter(capacity float64, refillRate float64, globalCapacity float64, globalRefillRate float64, clock Clock, opts ...KeyedOption) *GlobalCappedKeyedLimiter {
+	return &GlobalCappedKeyedLimiter{
+		keyed:  NewKeyedLimiter(capacity, refillRate, clock, opts...),
+		global: NewTokenBucket(globalCapacity, globalRefillRate, clock),
+	}
+}
+
+// NewHierarchicalLimiter combines an existing global bucket and per-key
+// limiter, e.g. to share one global bucket between several limiters. A request
+// is taken from its key's bucket first and then from global; if global denies
+// it, the key's tokens are refunded. Between the two steps the key's tokens
+// are briefly held, so a concurrent request on the same key may be denied
+// that would otherwise have been allowed; the global bucket is never
+// over-granted.
+func NewHierarchicalLimiter(global *TokenBucket, perKey *KeyedLimiter) *GlobalCappedKeyedLimiter {
+	return &GlobalCappedKeyedLimiter{
+		keyed:  perKey,
+		global: global,
+	}
+}
+
+func (g *GlobalCappedKeyedLimiter) Allow(key string, tokens int) bool {
+	allowed, _ := g.AllowWithRetry(key, tokens)
+	return allowed
+}
+
+// AllowWithRetry behaves like Allow and, on denial, returns how long until
+// whichever bucket denied the request can satisfy it.
+func (g *GlobalCappedKeyedLimiter) AllowWithRetry(key string, tokens int) (bool, time.Duration) {
+	bucket := g.keyed.getOrCreateBucket(key)
+
+	if allowed, retryAfter := bucket.AllowWithRetry(tokens); !allowed {
+		return false, retryAfter
+	}
+
+	if allowed, retryAfter := g.global.AllowWithRetry(tokens); !allowed {
+		bucket.refund(float64(tokens))
+		return false, retryAfter
+	}
+
+	return true, 0
+}
+
+func (g *GlobalCappedKeyedLimiter) Wait(ctx context.Context, key string, tokens int) error {
+	if tokens < 0 {
+		return ErrNegativeTokens
+	}
+
+	for {
+		allowed, waitDuration := g.AllowWithRetry(key, tokens)
+		if allowed {
+			return nil
+		}
+
+		if waitDuration == NeverAvailable {
+			return ErrExceedsCapacity
+		}
+
+		timer := time.NewTimer(waitDuration)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// Global returns the shared bucket, e.g. to read or tune the global rate.
+func (g *GlobalCappedKeyedLimiter) Global() *TokenBucket {
+	return g.global
+}
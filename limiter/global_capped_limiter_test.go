@@ -0,0 +1,81 @@
+package limiter
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestGlobalCappedKeyedLimiter_EnforcesGlobalCeiling(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	limiter := NewGlobalCappedKeyedLimiter(5, 5, 20, 20, clock)
+
+	allowed := 0
+	for i := range 10 {
+		for range 3 {
+			if limiter.Allow(fmt.Sprintf("user-%d", i), 1) {
+				allowed++
+			}
+		}
+	}
+
+	if allowed != 20 {
+		t.Errorf("expected keys under their own limit to be capped at 20 in total, got %d", allowed)
+	}
+
+	if remaining, _ := limiter.keyed.Remaining("user-9"); remaining != 5 {
+		t.Errorf("expected globally denied requests to be refunded to the key, got %f", remaining)
+	}
+
+	clock.Advance(500 * time.Millisecond)
+	if !limiter.Allow("user-9", 1) {
+		t.Error("expected the global bucket to refill")
+	}
+}
+
+func TestGlobalCappedKeyedLimiter_PerKeyLimitStillApplies(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	limiter := NewGlobalCappedKeyedLimiter(2, 1, 100, 100, clock)
+
+	limiter.Allow("user-1", 2)
+	if limiter.Allow("user-1", 1) {
+		t.Error("expected the per-key limit to apply")
+	}
+
+	if limiter.Global().Tokens() != 98 {
+		t.Errorf("expected per-key denials not to draw from the global bucket, got %f", limiter.Global().Tokens())
+	}
+}
+
+func TestGlobalCappedKeyedLimiter_WaitExceedsCapacity(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	limiter := NewGlobalCappedKeyedLimiter(10, 1, 5, 1, clock)
+
+	if err := limiter.Wait(context.Background(), "user-1", 6); err != ErrExceedsCapacity {
+		t.Errorf("expected ErrExceedsCapacity above the global capacity, got %v", err)
+	}
+}
+
+func TestHierarchicalLimiter_SharesGlobalBucket(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	global := NewTokenBucket(10, 0, clock)
+	api := NewHierarchicalLimiter(global, NewKeyedLimiter(8, 0, clock))
+	jobs := NewHierarchicalLimiter(global, NewKeyedLimiter(8, 0, clock))
+
+	if !api.Allow("user-1", 6) {
+		t.Fatal("expected the first request to be allowed")
+	}
+
+	if jobs.Allow("user-1", 6) {
+		t.Error("expected the shared global bucket to deny")
+	}
+
+	if remaining, _ := jobs.keyed.Remaining("user-1"); remaining != 8 {
+		t.Errorf("expected the key's tokens to be rolled back, got %f", remaining)
+	}
+
+	if !jobs.Allow("user-1", 4) {
+		t.Error("expected the rest of the global bucket to be available")
+	}
+}
@@ -0,0 +1,200 @@
+package limiter
+
+import (
+	"sync"
+	"time"
+)
+
+// RateController is a limiter whose refill rate can be tuned at runtime.
+type RateController interface {
+	Rate() float64
+	SetRate(refillRate float64)
+}
+
+// TargetDenyRateGovernor tunes a limiter's refill rate so the share of denied
+// requests settles near a target. It implements Metrics so it can observe the
+// allow/deny stream directly, and adjusts at most once per interval as measured
+// by its Clock. The rate is raised by step when the deny rate is above target,
+// lowered by step when it is under half the target, and kept within bounds.
+//
+// The target may be nil and set later with Bind, so the governor can be passed
+// to the limiter it controls through WithMetrics.
+type TargetDenyRateGovernor struct {
+	NoopMetrics
+
+	mu         sync.Mutex
+	target     RateController
+	denyRate   float64
+	minRate    float64
+	maxRate    float64
+	step       float64
+	interval   time.Duration
+	clock      Clock
+	lastAdjust time.Time
+	allows     int64
+	denies     int64
+}
+
+func NewTargetDenyRateGovernor(target RateController, denyRate float64, minRate float64, maxRate float64, interval time.Duration, clock Clock) *TargetDenyRateGovernor {
+	return &TargetDenyRateGovernor{
+		target:     target,
+		denyRate:   denyRate,
+		minRate:    minRate,
+		maxRate:    maxRate,
+		step:       0.05,
+		interval:   interval,
+		clock:      clock,
+		lastAdjust: clock.Now(),
+	}
+}
+
+// Bind sets the limiter the governor controls. Until it is bound, the
+// governor only counts.
+func (g *TargetDenyRateGovernor) Bind(target RateController) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.target = target
+}
+
+// SetStep sets the fractional change applied to the rate per adjustment.
+func (g *TargetDenyRateGovernor) SetStep(step float64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.step = step
+}
+
+func (g *TargetDenyRateGovernor) OnAllow(key string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.allows++
+	g.maybeAdjust()
+}
+
+func (g *TargetDenyRateGovernor) OnDeny(key string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.denies++
+	g.maybeAdjust()
+}
+
+// maybeAdjust nudges the rate once an interval has elapsed and resets the
+// counters. Must be called with g.mu held.
+func (g *TargetDenyRateGovernor) maybeAdjust() {
+	now := g.clock.Now()
+	if g.target == nil || now.Sub(g.lastAdjust) < g.interval {
+		return
+	}
+	g.lastAdjust = now
+
+	total := g.allows + g.denies
+	if total == 0 {
+		return
+	}
+
+	observed := float64(g.denies) / float64(total)
+	g.allows, g.denies = 0, 0
+
+	rate := g.target.Rate()
+	switch {
+	case observed > g.denyRate:
+		rate *= 1 + g.step
+	case observed < g.denyRate/2:
+		rate *= 1 - g.step
+	default:
+		return
+	}
+
+	g.target.SetRate(min(max(rate, g.minRate), g.maxRate))
+}
+
+// ReliabilityGovernor tunes a limiter's refill rate by the downstream's success
+// rate rather than its latency. Outcomes are fed in with Report and evaluated
+// once per interval: below minSuccess the rate is cut by step, otherwise it is
+// raised by step until it is back at maxRate, where it holds. Like
+// TargetDenyRateGovernor, it may be created without a target and bound later.
+type ReliabilityGovernor struct {
+	mu         sync.Mutex
+	target     RateController
+	minSuccess float64
+	minRate    float64
+	maxRate    float64
+	step       float64
+	interval   time.Duration
+	clock      Clock
+	lastAdjust time.Time
+	successes  int64
+	failures   int64
+}
+
+func NewReliabilityGovernor(target RateController, minSuccess float64, minRate float64, maxRate float64, interval time.Duration, clock Clock) *ReliabilityGovernor {
+	return &ReliabilityGovernor{
+		target:     target,
+		minSuccess: minSuccess,
+		minRate:    minRate,
+		maxRate:    maxRate,
+		step:       0.1,
+		interval:   interval,
+		clock:      clock,
+		lastAdjust: clock.Now(),
+	}
+}
+
+// Bind sets the limiter the governor controls.
+func (g *ReliabilityGovernor) Bind(target RateController) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.target = target
+}
+
+// SetStep sets the fractional change applied to the rate per adjustment.
+func (g *ReliabilityGovernor) SetStep(step float64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.step = step
+}
+
+// Report records the outcome of a downstream call.
+func (g *ReliabilityGovernor) Report(success bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if success {
+		g.successes++
+	} else {
+		g.failures++
+	}
+	g.maybeAdjust()
+}
+
+// maybeAdjust applies the interval's success rate and resets the counters.
+// Must be called with g.mu held.
+func (g *ReliabilityGovernor) maybeAdjust() {
+	now := g.clock.Now()
+	if g.target == nil || now.Sub(g.lastAdjust) < g.interval {
+		return
+	}
+	g.lastAdjust = now
+
+	total := g.successes + g.failures
+	if total == 0 {
+		return
+	}
+
+	observed := float64(g.successes) / float64(total)
+	g.successes, g.failures = 0, 0
+
+	rate := g.target.Rate()
+	if observed < g.minSuccess {
+		rate *= 1 - g.step
+	} else {
+		rate *= 1 + g.step
+	}
+
+	g.target.SetRate(min(max(rate, g.minRate), g.maxRate))
+}
@@ -0,0 +1,165 @@
+package limiter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTargetDenyRateGovernor_ConvergesNearTarget(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	bucket := NewTokenBucket(10, 50, clock)
+	governor := NewTargetDenyRateGovernor(bucket, 0.01, 1, 1000, time.Second, clock)
+	governor.SetStep(0.02)
+
+	var allows, denies int
+	for second := range 180 {
+		for range 100 {
+			clock.Advance(10 * time.Millisecond)
+			if bucket.Allow(1) {
+				governor.OnAllow("user-1")
+				if second >= 150 {
+					allows++
+				}
+			} else {
+				governor.OnDeny("user-1")
+				if second >= 150 {
+					denies++
+				}
+			}
+		}
+	}
+
+	denyRate := float64(denies) / float64(allows+denies)
+	if denyRate > 0.03 {
+		t.Errorf("expected deny rate to converge near 1%%, got %f", denyRate)
+	}
+
+	if bucket.Rate() < 90 || bucket.Rate() > 110 {
+		t.Errorf("expected rate to settle near the offered load of 100/s, got %f", bucket.Rate())
+	}
+}
+
+func TestTargetDenyRateGovernor_RespectsBounds(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	bucket := NewTokenBucket(10, 50, clock)
+	governor := NewTargetDenyRateGovernor(bucket, 0.01, 40, 60, time.Second, clock)
+	governor.SetStep(0.5)
+
+	for range 10 {
+		clock.Advance(time.Second)
+		governor.OnDeny("user-1")
+	}
+
+	if bucket.Rate() != 60 {
+		t.Errorf("expected rate to be capped at 60, got %f", bucket.Rate())
+	}
+
+	for range 10 {
+		clock.Advance(time.Second)
+		governor.OnAllow("user-1")
+	}
+
+	if bucket.Rate() != 40 {
+		t.Errorf("expected rate to be floored at 40, got %f", bucket.Rate())
+	}
+}
+
+func TestReliabilityGovernor_TightensAndRecovers(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	bucket := NewTokenBucket(10, 100, clock)
+	governor := NewReliabilityGovernor(bucket, 0.95, 10, 100, time.Second, clock)
+
+	report := func(seconds int, successRate float64) {
+		for range seconds {
+			for i := range 100 {
+				clock.Advance(10 * time.Millisecond)
+				governor.Report(float64(i) < successRate*100)
+			}
+		}
+	}
+
+	report(5, 1)
+	if bucket.Rate() != 100 {
+		t.Errorf("expected a healthy downstream to hold the rate at 100, got %f", bucket.Rate())
+	}
+
+	report(5, 0.8)
+	tightened := bucket.Rate()
+	if tightened >= 100*0.9*0.9 {
+		t.Errorf("expected the rate to tighten under failures, got %f", tightened)
+	}
+
+	report(60, 1)
+	if bucket.Rate() != 100 {
+		t.Errorf("expected the rate to recover to 100, got %f", bucket.Rate())
+	}
+}
+
+func TestReliabilityGovernor_RespectsMinRate(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	bucket := NewTokenBucket(10, 100, clock)
+	governor := NewReliabilityGovernor(bucket, 0.95, 40, 100, time.Second, clock)
+	governor.SetStep(0.5)
+
+	for range 10 {
+		clock.Advance(time.Second)
+		governor.Report(false)
+	}
+
+	if bucket.Rate() != 40 {
+		t.Errorf("expected rate to be floored at 40, got %f", bucket.Rate())
+	}
+}
+
+func TestTargetDenyRateGovernor_ControlsRedisLimiterThroughMetrics(t *testing.T) {
+	_, client := setupMiniRedis(t)
+	clock := &MockClock{current: time.Now()}
+
+	governor := NewTargetDenyRateGovernor(nil, 0.01, 0.001, 10, time.Second, clock)
+	limiter := NewRedisLimiter(client, 1, 0.001, "ratelimit:", WithMetrics(governor))
+	governor.Bind(limiter)
+
+	for range 5 {
+		limiter.Allow("user-1", 1)
+	}
+	clock.Advance(2 * time.Second)
+	limiter.Allow("user-1", 1)
+
+	if rate := limiter.Rate(); rate <= 0.001 {
+		t.Errorf("expected the governor to raise the rate under denials, got %f", rate)
+	}
+}
+
+func TestReliabilityGovernor_ControlsKeyedLimiter(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	keyed := NewKeyedLimiter(10, 100, clock)
+	keyed.Allow("user-1", 1)
+	governor := NewReliabilityGovernor(keyed, 0.95, 40, 100, time.Second, clock)
+	governor.SetStep(0.5)
+
+	clock.Advance(time.Second)
+	governor.Report(false)
+
+	if keyed.Rate() != 50 {
+		t.Errorf("expected the default rate to be cut to 50, got %f", keyed.Rate())
+	}
+	if rate := bucketOf(keyed, "user-1").Rate(); rate != 50 {
+		t.Errorf("expected the existing bucket to follow, got %f", rate)
+	}
+}
+
+func TestTargetDenyRateGovernor_UnboundOnlyCounts(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	governor := NewTargetDenyRateGovernor(nil, 0.01, 1, 100, time.Second, clock)
+
+	clock.Advance(2 * time.Second)
+	governor.OnDeny("user-1")
+
+	bucket := NewTokenBucket(10, 50, clock)
+	governor.Bind(bucket)
+	governor.OnDeny("user-1")
+
+	if bucket.Rate() <= 50 {
+		t.Errorf("expected the bound limiter's rate to be raised, got %f", bucket.Rate())
+	}
+}
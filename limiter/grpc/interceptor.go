@@ -0,0 +1,81 @@
+// Package grpc rate limits gRPC servers with a limiter.Limiter. It keeps the
+// gRPC dependency out of the core limiter package.
+package grpc
+
+import (
+	"context"
+	"time"
+
+	"github.com/schoolboybru/distributed-rate-limiter/limiter"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	ggrpc "google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// KeyFunc derives the rate limit key for a call from its context and, for
+// unary calls, its request message. Stream calls pass a nil request.
+type KeyFunc func(ctx context.Context, req interface{}) string
+
+// retryLimiter is implemented by limiters that can say when a denied request
+// would be allowed, such as KeyedLimiter and RedisLimiter.
+type retryLimiter interface {
+	AllowWithRetry(key string, tokens int) (bool, time.Duration)
+}
+
+// UnaryServerInterceptor takes one token per call from l under the key
+// derived by keyFunc. Denied calls fail with codes.ResourceExhausted; if l
+// can estimate when the call would be allowed, the status carries it as an
+// errdetails.RetryInfo.
+func UnaryServerInterceptor(l limiter.Limiter, keyFunc KeyFunc) ggrpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *ggrpc.UnaryServerInfo, handler ggrpc.UnaryHandler) (interface{}, error) {
+		if err := check(l, keyFunc(ctx, req)); err != nil {
+			return nil, err
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor takes one token when a stream is opened, under the
+// key keyFunc derives from the stream's context with a nil request. Messages
+// on an open stream aren't limited.
+func StreamServerInterceptor(l limiter.Limiter, keyFunc KeyFunc) ggrpc.StreamServerInterceptor {
+	return func(srv interface{}, ss ggrpc.ServerStream, info *ggrpc.StreamServerInfo, handler ggrpc.StreamHandler) error {
+		if err := check(l, keyFunc(ss.Context(), nil)); err != nil {
+			return err
+		}
+
+		return handler(srv, ss)
+	}
+}
+
+// check returns nil if the call is allowed, or its ResourceExhausted status.
+func check(l limiter.Limiter, key string) error {
+	var allowed bool
+	retryAfter := limiter.NeverAvailable
+	if rl, ok := l.(retryLimiter); ok {
+		allowed, retryAfter = rl.AllowWithRetry(key, 1)
+	} else {
+		allowed = l.Allow(key, 1)
+	}
+
+	if allowed {
+		return nil
+	}
+
+	// A deny without a positive estimate (fail-closed, ignored errors) has
+	// no meaningful retry delay, so RetryInfo is left out.
+	st := status.New(codes.ResourceExhausted, "rate limit exceeded")
+	if retryAfter <= 0 || retryAfter == limiter.NeverAvailable {
+		return st.Err()
+	}
+
+	detailed, err := st.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(retryAfter)})
+	if err != nil {
+		return st.Err()
+	}
+
+	return detailed.Err()
+}
@@ -0,0 +1,117 @@
+package grpc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/schoolboybru/distributed-rate-limiter/limiter"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	ggrpc "google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func tenantKey(ctx context.Context, req interface{}) string {
+	return "tenant-1"
+}
+
+func TestUnaryServerInterceptor_ReturnsResourceExhausted(t *testing.T) {
+	keyed := limiter.NewKeyedLimiter(1, 0.5, limiter.RealClock{})
+	interceptor := UnaryServerInterceptor(keyed, tenantKey)
+
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "ok", nil
+	}
+	info := &ggrpc.UnaryServerInfo{FullMethod: "/test.Service/Call"}
+
+	resp, err := interceptor(context.Background(), nil, info, handler)
+	if err != nil || resp != "ok" {
+		t.Fatalf("expected the call to pass through, got %v, %v", resp, err)
+	}
+
+	_, err = interceptor(context.Background(), nil, info, handler)
+	st := status.Convert(err)
+	if st.Code() != codes.ResourceExhausted {
+		t.Fatalf("expected ResourceExhausted, got %v", st.Code())
+	}
+
+	details := st.Details()
+	if len(details) != 1 {
+		t.Fatalf("expected one status detail, got %d", len(details))
+	}
+
+	retry, ok := details[0].(*errdetails.RetryInfo)
+	if !ok {
+		t.Fatalf("expected RetryInfo, got %T", details[0])
+	}
+
+	if delay := retry.RetryDelay.AsDuration(); delay <= 0 || delay > 2*time.Second {
+		t.Errorf("expected a retry delay of up to 2s, got %v", delay)
+	}
+}
+
+// zeroRetry denies every call without a retry estimate, as a fail-closed
+// limiter does while Redis is down.
+type zeroRetry struct{}
+
+func (zeroRetry) Allow(key string, tokens int) bool { return false }
+
+func (zeroRetry) Wait(ctx context.Context, key string, tokens int) error { return ctx.Err() }
+
+func (zeroRetry) AllowWithRetry(key string, tokens int) (bool, time.Duration) { return false, 0 }
+
+func TestUnaryServerInterceptor_OmitsRetryInfoWithoutEstimate(t *testing.T) {
+	interceptor := UnaryServerInterceptor(zeroRetry{}, tenantKey)
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "ok", nil
+	}
+
+	_, err := interceptor(context.Background(), nil, &ggrpc.UnaryServerInfo{FullMethod: "/test.Service/Call"}, handler)
+	st := status.Convert(err)
+	if st.Code() != codes.ResourceExhausted {
+		t.Fatalf("expected ResourceExhausted, got %v", st.Code())
+	}
+
+	if details := st.Details(); len(details) != 0 {
+		t.Errorf("expected no RetryInfo, got %v", details)
+	}
+}
+
+// fakeStream is a ServerStream whose only working method is Context.
+type fakeStream struct {
+	ggrpc.ServerStream
+}
+
+func (fakeStream) Context() context.Context {
+	return context.Background()
+}
+
+func TestStreamServerInterceptor_LimitsStreamOpens(t *testing.T) {
+	keyed := limiter.NewKeyedLimiter(1, 0, limiter.RealClock{})
+	interceptor := StreamServerInterceptor(keyed, tenantKey)
+
+	opened := 0
+	handler := func(srv interface{}, ss ggrpc.ServerStream) error {
+		opened++
+		return nil
+	}
+	info := &ggrpc.StreamServerInfo{FullMethod: "/test.Service/Stream"}
+
+	if err := interceptor(nil, fakeStream{}, info, handler); err != nil {
+		t.Fatalf("expected the stream to open, got %v", err)
+	}
+
+	err := interceptor(nil, fakeStream{}, info, handler)
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("expected ResourceExhausted, got %v", err)
+	}
+
+	if len(status.Convert(err).Details()) != 0 {
+		t.Error("expected no RetryInfo when the bucket never refills")
+	}
+
+	if opened != 1 {
+		t.Errorf("expected one stream to reach the handler, got %d", opened)
+	}
+}
@@ -3,15 +3,24 @@ package limiter
 import (
 	"context"
 	"errors"
+	"math"
 	"time"
 )
 
 var ErrExceedsCapacity = errors.New("requested tokens exceeds bucket capacity")
+var ErrNegativeTokens = errors.New("requested tokens must not be negative")
+var ErrWouldExceedDeadline = errors.New("tokens won't be available before the context deadline")
+
+// NeverAvailable is the retry duration reported for requests that can't
+// succeed by waiting, e.g. because they exceed capacity or nothing refills.
+const NeverAvailable = time.Duration(math.MaxInt64)
 
 type Clock interface {
 	Now() time.Time
 }
 
+// RealClock reads time.Now, whose monotonic reading keeps intervals measured
+// between its times correct across wall clock adjustments.
 type RealClock struct{}
 
 func (RealClock) Now() time.Time { return time.Now() }
@@ -21,6 +30,51 @@ type Limiter interface {
 	Wait(ctx context.Context, key string, tokens int) error
 }
 
+// WaitPath describes how a Wait was eventually satisfied.
+type WaitPath int
+
+const (
+	// WaitFastPath means tokens were available on the first attempt.
+	WaitFastPath WaitPath = iota
+	// WaitAfterRefill means the caller waited for tokens to refill.
+	WaitAfterRefill
+	// WaitAfterRecovery means earlier attempts failed over and Redis served
+	// the successful one.
+	WaitAfterRecovery
+	// WaitAfterDegrade means the failure mode admitted the request.
+	WaitAfterDegrade
+)
+
+type WaitResult struct {
+	Iterations int
+	Waited     time.Duration
+	Path       WaitPath
+}
+
+// Result is the full outcome of a Check: whether the request was allowed, the
+// tokens left and the bucket's capacity, how long until the bucket is full
+// again and, if denied, how long until the request could be allowed. Either
+// duration is NeverAvailable if the bucket doesn't refill.
+type Result struct {
+	Allowed    bool
+	Remaining  float64
+	Limit      float64
+	ResetAfter time.Duration
+	RetryAfter time.Duration
+}
+
+// LimiterConfig is a snapshot of a limiter's settings. Fields that don't apply
+// to a limiter are left at their zero value.
+type LimiterConfig struct {
+	Capacity       float64
+	RefillRate     float64
+	KeyPrefix      string
+	FailureMode    FailureMode
+	CircuitBreaker bool
+	Metrics        bool
+	Shadow         bool
+}
+
 type Metrics interface {
 	OnAllow(key string)
 	OnDeny(key string)
@@ -28,9 +82,81 @@ type Metrics interface {
 	OnLatency(key string, d time.Duration)
 }
 
+// DriftMetrics is an optional extension to Metrics. If the configured Metrics
+// implements it, DetectClockDrift reports each measurement as a gauge.
+type DriftMetrics interface {
+	OnClockDrift(d time.Duration)
+}
+
+// ConsumeMetrics is an optional extension to Metrics. If the configured
+// Metrics implements it, every allowed request also reports the tokens it
+// cost, so adapters can sum weighted load rather than count requests.
+type ConsumeMetrics interface {
+	OnConsume(key string, tokens int)
+}
+
+// recordAllow reports an allowed request of the given cost to m. Fractional
+// costs are rounded up for ConsumeMetrics.
+func recordAllow(m Metrics, key string, tokens float64) {
+	m.OnAllow(key)
+
+	if cm, ok := m.(ConsumeMetrics); ok {
+		cm.OnConsume(key, int(math.Ceil(tokens)))
+	}
+}
+
+// ShadowMetrics is an optional extension to Metrics. If the configured Metrics
+// implements it, a limiter in shadow mode reports the requests it would have
+// denied to OnShadowDeny instead of OnDeny.
+type ShadowMetrics interface {
+	OnShadowDeny(key string)
+}
+
+// recordDeny reports a denied request to m, as a shadow deny if shadow is set
+// and m tells them apart.
+func recordDeny(m Metrics, key string, shadow bool) {
+	if sm, ok := m.(ShadowMetrics); ok && shadow {
+		sm.OnShadowDeny(key)
+		return
+	}
+
+	m.OnDeny(key)
+}
+
+// CircuitMetrics is an optional extension to Metrics. If the configured Metrics
+// implements it, RedisLimiter reports every circuit breaker transition.
+type CircuitMetrics interface {
+	OnCircuitStateChange(from, to CircuitState)
+}
+
+// EvictionMetrics receives a call for each key a KeyedLimiter drops to stay
+// under its key limit. Such an eviction resets the key's bucket.
+type EvictionMetrics interface {
+	OnEvict(key string)
+}
+
 type NoopMetrics struct{}
 
 func (NoopMetrics) OnAllow(key string)                    {}
 func (NoopMetrics) OnDeny(key string)                     {}
 func (NoopMetrics) OnError(key string, err error)         {}
 func (NoopMetrics) OnLatency(key string, d time.Duration) {}
+
+func (NoopMetrics) OnConsume(key string, tokens int)           {}
+func (NoopMetrics) OnCircuitStateChange(from, to CircuitState) {}
+
+// Logger receives a RedisLimiter's diagnostic messages: circuit breaker
+// transitions at Warn, Redis errors at Error and failure mode decisions at
+// Debug. Its printf-style methods match most logging libraries, so adapting
+// one doesn't pull it into this package.
+type Logger interface {
+	Debugf(format string, args ...any)
+	Warnf(format string, args ...any)
+	Errorf(format string, args ...any)
+}
+
+type NoopLogger struct{}
+
+func (NoopLogger) Debugf(format string, args ...any) {}
+func (NoopLogger) Warnf(format string, args ...any)  {}
+func (NoopLogger) Errorf(format string, args ...any) {}
@@ -1,25 +1,144 @@
 package limiter
 
 import (
+	"container/list"
 	"context"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// KeyedLimiter gives each key its own TokenBucket. Buckets are spread over
+// shards by a hash of their key, each with its own lock, so calls on different
+// keys rarely contend. mu guards the limits and the LRU list and is always
+// taken before a shard's lock.
 type KeyedLimiter struct {
 	mu         sync.RWMutex
-	buckets    map[string]*TokenBucket
+	shards     []bucketShard
 	capacity   float64
 	refillRate float64
 	clock      Clock
+	idleTTL    time.Duration
+	stop       chan struct{}
+	closeOnce  sync.Once
+	sweeper    *Sweeper
+	maxKeys    int
+	lru        *list.List
+	lruElems   map[string]*list.Element
+	onEvict    EvictionMetrics
+	keyLimits  map[string]keyLimit
+	// nextRevert is the earliest expiry of a SetLimitFor override in Unix
+	// nanoseconds, or 0 if there is none, so calls only take the write lock
+	// when an override is due.
+	nextRevert atomic.Int64
 }
 
-func NewKeyedLimiter(capacity float64, refillRate float64, clock Clock) *KeyedLimiter {
-	return &KeyedLimiter{
+// defaultShards is how many shards a KeyedLimiter splits its buckets over.
+const defaultShards = 64
+
+type bucketShard struct {
+	mu      sync.RWMutex
+	buckets map[string]*TokenBucket
+}
+
+type keyLimit struct {
+	capacity   float64
+	refillRate float64
+	// expires is set for SetLimitFor overrides, which revert to base.
+	expires time.Time
+	base    *keyLimit
+}
+
+type KeyedOption func(*KeyedLimiter)
+
+// WithIdleTTL lets EvictIdle remove buckets that haven't been touched for d,
+// as measured by the limiter's Clock.
+func WithIdleTTL(d time.Duration) KeyedOption {
+	return func(kl *KeyedLimiter) {
+		kl.idleTTL = d
+	}
+}
+
+// WithSweeper registers the limiter with a shared Sweeper instead of giving it
+// a sweeper goroutine of its own. Close deregisters it. Set an idle TTL with
+// WithIdleTTL, or nothing will be evicted.
+func WithSweeper(s *Sweeper) KeyedOption {
+	return func(kl *KeyedLimiter) {
+		kl.sweeper = s
+	}
+}
+
+// WithMaxKeys caps the number of buckets. Creating a bucket beyond the cap
+// evicts the least recently used one, resetting that key's limit.
+func WithMaxKeys(n int) KeyedOption {
+	return func(kl *KeyedLimiter) {
+		kl.maxKeys = n
+	}
+}
+
+// withShards sets how many shards the buckets are spread over. One shard puts
+// every key behind the same lock, as before the bucket map was sharded. n <= 0
+// keeps the default.
+func withShards(n int) KeyedOption {
+	return func(kl *KeyedLimiter) {
+		if n > 0 {
+			kl.shards = make([]bucketShard, n)
+		}
+	}
+}
+
+func WithEvictionMetrics(m EvictionMetrics) KeyedOption {
+	return func(kl *KeyedLimiter) {
+		kl.onEvict = m
+	}
+}
+
+func NewKeyedLimiter(capacity float64, refillRate float64, clock Clock, opts ...KeyedOption) *KeyedLimiter {
+	kl := &KeyedLimiter{
 		capacity:   capacity,
 		refillRate: refillRate,
 		clock:      clock,
-		buckets:    make(map[string]*TokenBucket),
 	}
+
+	for _, opt := range opts {
+		opt(kl)
+	}
+
+	if kl.shards == nil {
+		kl.shards = make([]bucketShard, defaultShards)
+	}
+	for i := range kl.shards {
+		kl.shards[i].buckets = make(map[string]*TokenBucket)
+	}
+
+	if kl.maxKeys > 0 {
+		kl.lru = list.New()
+		kl.lruElems = make(map[string]*list.Element)
+	}
+
+	if kl.sweeper != nil {
+		kl.sweeper.Register(kl)
+	}
+
+	return kl
+}
+
+// NewKeyedLimiterRate returns a KeyedLimiter whose buckets refill at rate and
+// hold up to burst tokens, or rate.Limit tokens if burst is zero.
+func NewKeyedLimiterRate(rate Rate, burst int, clock Clock, opts ...KeyedOption) *KeyedLimiter {
+	return NewKeyedLimiter(rate.capacity(burst), rate.TokensPerSecond(), clock, opts...)
+}
+
+// NewKeyedLimiterWithEviction returns a KeyedLimiter that evicts buckets idle
+// for idleTimeout every sweepInterval from a background goroutine. Call Close
+// to stop it.
+func NewKeyedLimiterWithEviction(capacity float64, refillRate float64, clock Clock, idleTimeout time.Duration, sweepInterval time.Duration) *KeyedLimiter {
+	kl := NewKeyedLimiter(capacity, refillRate, clock, WithIdleTTL(idleTimeout))
+	kl.stop = make(chan struct{})
+
+	go kl.sweep(sweepInterval)
+
+	return kl
 }
 
 func (kl *KeyedLimiter) Allow(key string, tokens int) bool {
@@ -29,33 +148,617 @@ func (kl *KeyedLimiter) Allow(key string, tokens int) bool {
 
 }
 
+// AllowPartial behaves like TokenBucket.AllowPartial for key's bucket.
+func (kl *KeyedLimiter) AllowPartial(key string, requested int) int {
+	bucket := kl.getOrCreateBucket(key)
+
+	return bucket.AllowPartial(requested)
+}
+
+// AllowAt behaves like Allow as of at, as TokenBucket.AllowAt does. The times
+// must be non-decreasing for each key, but different keys may be replayed out
+// of order with each other. A new key's bucket starts full as of its first
+// at. Idle eviction still measures idleness against the clock.
+func (kl *KeyedLimiter) AllowAt(key string, tokens int, at time.Time) bool {
+	bucket := kl.getOrCreateBucket(key)
+
+	return bucket.AllowAt(tokens, at)
+}
+
+// AllowFloat behaves like Allow for a fractional cost.
+func (kl *KeyedLimiter) AllowFloat(key string, tokens float64) bool {
+	bucket := kl.getOrCreateBucket(key)
+
+	return bucket.AllowFloat(tokens)
+}
+
+func (kl *KeyedLimiter) AllowChecked(key string, tokens int) (bool, error) {
+	bucket := kl.getOrCreateBucket(key)
+
+	return bucket.AllowChecked(tokens)
+}
+
+// AllowWithLevel behaves like Allow and also returns the bucket's token count
+// after the decision, saving caching layers a second locked read.
+func (kl *KeyedLimiter) AllowWithLevel(key string, tokens int) (bool, float64) {
+	bucket := kl.getOrCreateBucket(key)
+
+	return bucket.AllowWithLevel(tokens)
+}
+
+func (kl *KeyedLimiter) AllowWithRetry(key string, tokens int) (bool, time.Duration) {
+	bucket := kl.getOrCreateBucket(key)
+
+	return bucket.AllowWithRetry(tokens)
+}
+
+// Check takes tokens for key if available and returns the decision with the
+// bucket's state. The error is ErrNegativeTokens for a negative request.
+func (kl *KeyedLimiter) Check(key string, tokens int) (Result, error) {
+	bucket := kl.getOrCreateBucket(key)
+
+	return bucket.check(tokens)
+}
+
+func (kl *KeyedLimiter) Reserve(key string, tokens int) (*Reservation, error) {
+	bucket := kl.getOrCreateBucket(key)
+
+	return bucket.Reserve(tokens)
+}
+
 func (kl *KeyedLimiter) Wait(ctx context.Context, key string, tokens int) error {
 	bucket := kl.getOrCreateBucket(key)
 
 	return bucket.Wait(ctx, tokens)
 }
 
-func (kl *KeyedLimiter) getOrCreateBucket(key string) *TokenBucket {
+// WaitClamp behaves like TokenBucket.WaitClamp for key's bucket.
+func (kl *KeyedLimiter) WaitClamp(ctx context.Context, key string, tokens int) (float64, error) {
+	bucket := kl.getOrCreateBucket(key)
+
+	return bucket.WaitClamp(ctx, tokens)
+}
+
+// WaitFloat behaves like Wait for a fractional cost.
+func (kl *KeyedLimiter) WaitFloat(ctx context.Context, key string, tokens float64) error {
+	bucket := kl.getOrCreateBucket(key)
+
+	return bucket.WaitFloat(ctx, tokens)
+}
+
+// DelayFor returns how long until the key has tokens available, without
+// consuming any. See TokenBucket.DelayFor.
+func (kl *KeyedLimiter) DelayFor(key string, tokens int) time.Duration {
+	bucket := kl.getOrCreateBucket(key)
+
+	return bucket.DelayFor(tokens)
+}
+
+// Acquire takes tokens for key now, waits if they will be available before
+// ctx's deadline, and otherwise fails fast with ErrWouldExceedDeadline.
+func (kl *KeyedLimiter) Acquire(ctx context.Context, key string, tokens int) error {
+	bucket := kl.getOrCreateBucket(key)
+
+	return bucket.Acquire(ctx, tokens)
+}
+
+// AllowOrWait takes tokens for key now if available, and otherwise waits up to
+// maxWait for them. See the package-level AllowOrWait.
+func (kl *KeyedLimiter) AllowOrWait(ctx context.Context, key string, tokens int, maxWait time.Duration) (bool, time.Duration, error) {
+	return AllowOrWait(ctx, kl, key, tokens, maxWait)
+}
+
+// WaitPriority behaves like Wait, serving higher priority waiters on the key
+// first. See TokenBucket.WaitPriority.
+func (kl *KeyedLimiter) WaitPriority(ctx context.Context, key string, tokens int, priority int) error {
+	bucket := kl.getOrCreateBucket(key)
+
+	return bucket.WaitPriority(ctx, tokens, priority)
+}
+
+// Remaining returns the key's current token count, refilled to now, without
+// consuming anything. Unknown keys report a full bucket.
+func (kl *KeyedLimiter) Remaining(key string) (float64, error) {
 	kl.mu.RLock()
-	if value, ok := kl.buckets[key]; ok {
-		kl.mu.RUnlock()
-		return value
+	bucket, ok := kl.bucket(key)
+	capacity, _ := kl.limitsFor(key)
+	kl.mu.RUnlock()
+
+	if !ok {
+		return capacity, nil
 	}
 
+	return bucket.Tokens(), nil
+}
+
+// Snapshot returns the key's bucket state refilled to now. Unknown keys report
+// a full bucket as of now, without creating one.
+func (kl *KeyedLimiter) Snapshot(key string) BucketState {
+	kl.mu.RLock()
+	bucket, ok := kl.bucket(key)
+	capacity, refillRate := kl.limitsFor(key)
+	kl.mu.RUnlock()
+
+	if !ok {
+		return BucketState{
+			Capacity:   capacity,
+			RefillRate: refillRate,
+			Tokens:     capacity,
+			LastRefill: kl.clock.Now(),
+		}
+	}
+
+	return bucket.Snapshot()
+}
+
+// Status reports the key's usage, where ResetIn is the time until its bucket
+// has fully refilled. Unknown keys report a full bucket.
+func (kl *KeyedLimiter) Status(key string) Status {
+	kl.mu.RLock()
+	bucket, ok := kl.bucket(key)
+	capacity, _ := kl.limitsFor(key)
 	kl.mu.RUnlock()
+
+	if !ok {
+		return Status{Key: key, Limit: capacity}
+	}
+
+	bucket.mu.Lock()
+	defer bucket.mu.Unlock()
+
+	bucket.refill()
+	used := bucket.capacity - bucket.tokens
+
+	var resetIn time.Duration
+	switch {
+	case used <= 0:
+	case bucket.refillRate <= 0:
+		resetIn = NeverAvailable
+	default:
+		resetIn = time.Duration(used / bucket.refillRate * float64(time.Second))
+	}
+
+	return Status{
+		Key:       key,
+		Used:      used,
+		Limit:     bucket.capacity,
+		ResetIn:   resetIn,
+		Throttled: bucket.tokens < 1,
+	}
+}
+
+func (kl *KeyedLimiter) Describe(key string) string {
+	return kl.Status(key).String()
+}
+
+func (kl *KeyedLimiter) Config() LimiterConfig {
+	kl.mu.RLock()
+	defer kl.mu.RUnlock()
+
+	return LimiterConfig{
+		Capacity:   kl.capacity,
+		RefillRate: kl.refillRate,
+	}
+}
+
+func (kl *KeyedLimiter) WaitDetailed(ctx context.Context, key string, tokens int) (WaitResult, error) {
+	bucket := kl.getOrCreateBucket(key)
+
+	return bucket.WaitDetailed(ctx, tokens)
+}
+
+func (kl *KeyedLimiter) getOrCreateBucket(key string) *TokenBucket {
+	if next := kl.nextRevert.Load(); next != 0 && kl.clock.Now().UnixNano() >= next {
+		kl.revertExpired()
+	}
+
+	if kl.lru != nil {
+		return kl.getOrCreateBucketLRU(key)
+	}
+
+	if bucket, ok := kl.bucket(key); ok {
+		return bucket
+	}
+
+	// The read lock on mu keeps the key's limits from changing until its
+	// bucket is in place, without serializing creation across shards.
+	kl.mu.RLock()
+	defer kl.mu.RUnlock()
+
+	shard := kl.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	if bucket, ok := shard.buckets[key]; ok {
+		return bucket
+	}
+
+	capacity, refillRate := kl.limitsFor(key)
+	bucket := NewTokenBucket(capacity, refillRate, kl.clock)
+	shard.buckets[key] = bucket
+
+	return bucket
+}
+
+// ShardFor returns the index of the shard that holds key's bucket.
+func (kl *KeyedLimiter) ShardFor(key string) int {
+	// FNV-1a, inlined so hashing doesn't allocate.
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+
+	return int(h % uint32(len(kl.shards)))
+}
+
+// shard returns the shard holding key's bucket.
+func (kl *KeyedLimiter) shard(key string) *bucketShard {
+	return &kl.shards[kl.ShardFor(key)]
+}
+
+// bucket returns key's bucket, if it has one.
+func (kl *KeyedLimiter) bucket(key string) (*TokenBucket, bool) {
+	shard := kl.shard(key)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+
+	bucket, ok := shard.buckets[key]
+	return bucket, ok
+}
+
+// size returns the number of buckets.
+func (kl *KeyedLimiter) size() int {
+	n := 0
+	for i := range kl.shards {
+		shard := &kl.shards[i]
+		shard.mu.RLock()
+		n += len(shard.buckets)
+		shard.mu.RUnlock()
+	}
+
+	return n
+}
+
+// Preload creates full buckets for keys that don't have one yet, so their
+// first calls don't pay for creating them, e.g. to warm up known keys on
+// deploy. With WithMaxKeys, preloading more keys than the cap evicts the
+// earliest ones; with an idle TTL, preloaded keys are evicted like any other
+// once idle.
+func (kl *KeyedLimiter) Preload(keys []string) {
+	if kl.lru != nil {
+		for _, key := range keys {
+			kl.getOrCreateBucketLRU(key)
+		}
+		return
+	}
+
+	kl.mu.RLock()
+	defer kl.mu.RUnlock()
+
+	for _, key := range keys {
+		shard := kl.shard(key)
+		shard.mu.Lock()
+		if _, ok := shard.buckets[key]; !ok {
+			capacity, refillRate := kl.limitsFor(key)
+			shard.buckets[key] = NewTokenBucket(capacity, refillRate, kl.clock)
+		}
+		shard.mu.Unlock()
+	}
+}
+
+// EvictIdle removes buckets idle for longer than the idle TTL and returns how
+// many were removed. A bucket that isn't full yet is kept, so eviction never
+// resets a key that is still being limited. It does nothing without an idle TTL.
+//
+// Every call on a bucket refills it, so its lastRefill is also its last access.
+func (kl *KeyedLimiter) EvictIdle() int {
+	if kl.idleTTL <= 0 {
+		return 0
+	}
+
+	kl.mu.Lock()
+	defer kl.mu.Unlock()
+
+	now := kl.clock.Now()
+	evicted := 0
+
+	for i := range kl.shards {
+		shard := &kl.shards[i]
+		shard.mu.Lock()
+
+		for key, bucket := range shard.buckets {
+			bucket.mu.Lock()
+			idle := now.Sub(bucket.lastRefill)
+			full := bucket.tokens+idle.Seconds()*bucket.refillRate >= bucket.capacity
+			bucket.mu.Unlock()
+
+			if idle > kl.idleTTL && full {
+				kl.removeLocked(shard, key)
+				evicted++
+			}
+		}
+
+		shard.mu.Unlock()
+	}
+
+	return evicted
+}
+
+// getOrCreateBucketLRU is getOrCreateBucket for limiters with a key cap. Every
+// lookup reorders the LRU list, so it always takes the write lock.
+func (kl *KeyedLimiter) getOrCreateBucketLRU(key string) *TokenBucket {
 	kl.mu.Lock()
+	defer kl.mu.Unlock()
 
-	if value, ok := kl.buckets[key]; ok {
-		kl.mu.Unlock()
-		return value
+	if bucket, ok := kl.bucket(key); ok {
+		kl.lru.MoveToFront(kl.lruElems[key])
+		return bucket
 	}
 
-	bucket := NewTokenBucket(kl.capacity, kl.refillRate, kl.clock)
+	for kl.lru.Len() >= kl.maxKeys {
+		oldest := kl.lru.Back().Value.(string)
+		kl.remove(oldest)
 
-	kl.buckets[key] = bucket
+		if kl.onEvict != nil {
+			kl.onEvict.OnEvict(oldest)
+		}
+	}
+
+	capacity, refillRate := kl.limitsFor(key)
+	bucket := NewTokenBucket(capacity, refillRate, kl.clock)
 
-	kl.mu.Unlock()
+	shard := kl.shard(key)
+	shard.mu.Lock()
+	shard.buckets[key] = bucket
+	shard.mu.Unlock()
+	kl.lruElems[key] = kl.lru.PushFront(key)
 
 	return bucket
+}
+
+// keys returns the keys that currently have a bucket.
+func (kl *KeyedLimiter) keys() []string {
+	var keys []string
+	for i := range kl.shards {
+		shard := &kl.shards[i]
+		shard.mu.RLock()
+		for key := range shard.buckets {
+			keys = append(keys, key)
+		}
+		shard.mu.RUnlock()
+	}
+
+	return keys
+}
+
+// lowerTokens caps the key's bucket at tokens if it has one.
+func (kl *KeyedLimiter) lowerTokens(key string, tokens float64) {
+	bucket, ok := kl.bucket(key)
+	if !ok {
+		return
+	}
+
+	bucket.mu.Lock()
+	defer bucket.mu.Unlock()
+
+	bucket.refill()
+	bucket.tokens = min(bucket.tokens, max(tokens, 0))
+}
+
+// AddTokens credits the key's bucket with tokens up to its capacity, creating
+// it if needed. See TokenBucket.AddTokens.
+func (kl *KeyedLimiter) AddTokens(key string, tokens float64) float64 {
+	bucket := kl.getOrCreateBucket(key)
+
+	return bucket.AddTokens(tokens)
+}
+
+// SetTokens sets the key's bucket to tokens, clamped between zero and its
+// capacity. See TokenBucket.SetTokens.
+func (kl *KeyedLimiter) SetTokens(key string, tokens float64) float64 {
+	bucket := kl.getOrCreateBucket(key)
+
+	return bucket.SetTokens(tokens)
+}
 
+// Reset deletes the key's bucket, so its next call starts with a full one.
+// Per-key limits set with SetKeyLimit or SetLimitFor are kept. The error is
+// always nil and exists to match RedisLimiter.
+func (kl *KeyedLimiter) Reset(key string) error {
+	kl.mu.Lock()
+	defer kl.mu.Unlock()
+
+	kl.remove(key)
+	return nil
+}
+
+// remove deletes a bucket. Must be called with kl.mu held.
+func (kl *KeyedLimiter) remove(key string) {
+	shard := kl.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	kl.removeLocked(shard, key)
+}
+
+// removeLocked deletes a bucket from its shard. Must be called with kl.mu and
+// the shard's lock held.
+func (kl *KeyedLimiter) removeLocked(shard *bucketShard, key string) {
+	delete(shard.buckets, key)
+
+	if elem, ok := kl.lruElems[key]; ok {
+		kl.lru.Remove(elem)
+		delete(kl.lruElems, key)
+	}
+}
+
+// SetKeyLimit gives key its own capacity and refill rate in place of the
+// limiter's defaults, e.g. for a higher customer tier. If the key already has
+// a bucket it is resized, so the new limits apply from its next call.
+func (kl *KeyedLimiter) SetKeyLimit(key string, capacity float64, refillRate float64) {
+	kl.mu.Lock()
+	defer kl.mu.Unlock()
+
+	if kl.keyLimits == nil {
+		kl.keyLimits = make(map[string]keyLimit)
+	}
+	kl.keyLimits[key] = keyLimit{capacity: capacity, refillRate: refillRate}
+
+	if bucket, ok := kl.bucket(key); ok {
+		bucket.SetLimits(capacity, refillRate)
+	}
+}
+
+// SetLimitFor gives key its own capacity and refill rate for duration, as
+// measured by the limiter's Clock, after which the key reverts to the limit it
+// had before. The bucket keeps the same fraction of its capacity across both
+// changes, so a boost doesn't hand out a burst and its revert doesn't leave the
+// key throttled.
+func (kl *KeyedLimiter) SetLimitFor(key string, capacity float64, refillRate float64, duration time.Duration) {
+	kl.mu.Lock()
+	defer kl.mu.Unlock()
+
+	if kl.keyLimits == nil {
+		kl.keyLimits = make(map[string]keyLimit)
+	}
+
+	limit := keyLimit{capacity: capacity, refillRate: refillRate, expires: kl.clock.Now().Add(duration)}
+	if prev, ok := kl.keyLimits[key]; ok {
+		// A new boost replaces a running one rather than stacking on it.
+		if prev.expires.IsZero() {
+			limit.base = &prev
+		} else {
+			limit.base = prev.base
+		}
+	}
+	kl.keyLimits[key] = limit
+
+	if bucket, ok := kl.bucket(key); ok {
+		bucket.scaleLimits(capacity, refillRate)
+	}
+
+	if next := kl.nextRevert.Load(); next == 0 || limit.expires.UnixNano() < next {
+		kl.nextRevert.Store(limit.expires.UnixNano())
+	}
+}
+
+// revertExpired reverts SetLimitFor overrides that have expired.
+func (kl *KeyedLimiter) revertExpired() {
+	kl.mu.Lock()
+	defer kl.mu.Unlock()
+
+	now := kl.clock.Now()
+	var next int64
+
+	for key, limit := range kl.keyLimits {
+		if limit.expires.IsZero() {
+			continue
+		}
+
+		if now.Before(limit.expires) {
+			if next == 0 || limit.expires.UnixNano() < next {
+				next = limit.expires.UnixNano()
+			}
+			continue
+		}
+
+		if limit.base != nil {
+			kl.keyLimits[key] = *limit.base
+		} else {
+			delete(kl.keyLimits, key)
+		}
+
+		if bucket, ok := kl.bucket(key); ok {
+			bucket.scaleLimits(kl.limitsFor(key))
+		}
+	}
+
+	kl.nextRevert.Store(next)
+}
+
+// limitsFor returns the key's capacity and refill rate, falling back to the
+// defaults. Must be called with kl.mu held.
+func (kl *KeyedLimiter) limitsFor(key string) (float64, float64) {
+	if limit, ok := kl.keyLimits[key]; ok {
+		return limit.capacity, limit.refillRate
+	}
+
+	return kl.capacity, kl.refillRate
+}
+
+// SetLimits changes the default capacity and refill rate for new and existing
+// buckets. Keys with their own limit from SetKeyLimit keep it.
+func (kl *KeyedLimiter) SetLimits(capacity float64, refillRate float64) {
+	kl.mu.Lock()
+	defer kl.mu.Unlock()
+
+	kl.capacity = capacity
+	kl.refillRate = refillRate
+	kl.applyLimits()
+}
+
+// Rate returns the default refill rate. With SetRate it makes the limiter a
+// RateController, so a governor can tune it.
+func (kl *KeyedLimiter) Rate() float64 {
+	kl.mu.RLock()
+	defer kl.mu.RUnlock()
+
+	return kl.refillRate
+}
+
+// SetRate changes the default refill rate, keeping the capacity. Keys with a
+// SetLimitFor override keep theirs.
+func (kl *KeyedLimiter) SetRate(refillRate float64) {
+	kl.mu.Lock()
+	defer kl.mu.Unlock()
+
+	kl.refillRate = refillRate
+	kl.applyLimits()
+}
+
+// applyLimits sets the default limits on every bucket without an override.
+// Must be called with kl.mu held.
+func (kl *KeyedLimiter) applyLimits() {
+	for i := range kl.shards {
+		shard := &kl.shards[i]
+		shard.mu.RLock()
+		for key, bucket := range shard.buckets {
+			if _, ok := kl.keyLimits[key]; !ok {
+				bucket.SetLimits(kl.capacity, kl.refillRate)
+			}
+		}
+		shard.mu.RUnlock()
+	}
+}
+
+// Close stops the background sweeper, or deregisters from a shared one. The
+// limiter keeps working afterwards, only without idle eviction. It is safe to
+// call more than once and always returns nil.
+func (kl *KeyedLimiter) Close() error {
+	kl.closeOnce.Do(func() {
+		if kl.stop != nil {
+			close(kl.stop)
+		}
+
+		if kl.sweeper != nil {
+			kl.sweeper.Deregister(kl)
+		}
+	})
+
+	return nil
+}
+
+func (kl *KeyedLimiter) sweep(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-kl.stop:
+			return
+		case <-ticker.C:
+			kl.EvictIdle()
+		}
+	}
 }
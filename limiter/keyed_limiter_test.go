@@ -3,11 +3,21 @@ package limiter
 import (
 	"context"
 	"fmt"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"go.uber.org/goleak"
 )
 
+// bucketOf returns key's bucket, or nil if it has none.
+func bucketOf(kl *KeyedLimiter, key string) *TokenBucket {
+	bucket, _ := kl.bucket(key)
+	return bucket
+}
+
 func TestKeyedLimiter_SeparateBuckets(t *testing.T) {
 	clock := &MockClock{current: time.Now()}
 	keyedLimiter := NewKeyedLimiter(5, 2, clock)
@@ -102,7 +112,690 @@ func TestKeyedLimiter_ConcurrentSameKey(t *testing.T) {
 	wg.Wait()
 	close(results)
 
-	if keyedLimiter.buckets["same-key"].tokens != 50 {
-		t.Errorf("expected same-key bucket to have 50 tokens, go %f", keyedLimiter.buckets["same-key"].tokens)
+	if bucketOf(keyedLimiter, "same-key").tokens != 50 {
+		t.Errorf("expected same-key bucket to have 50 tokens, go %f", bucketOf(keyedLimiter, "same-key").tokens)
+	}
+}
+
+func TestKeyedLimiter_NegativeAndZeroTokens(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	keyedLimiter := NewKeyedLimiter(5, 2, clock)
+
+	keyedLimiter.Allow("user-1", 5)
+
+	if keyedLimiter.Allow("user-1", -5) {
+		t.Error("expected negative request to be denied")
+	}
+
+	if !keyedLimiter.Allow("user-1", 0) {
+		t.Error("expected zero token request to be allowed")
+	}
+
+	if keyedLimiter.Allow("user-1", 1) {
+		t.Error("expected negative request not to add tokens")
+	}
+
+	if err := keyedLimiter.Wait(context.Background(), "user-1", -1); err != ErrNegativeTokens {
+		t.Errorf("expected ErrNegativeTokens, got %v", err)
+	}
+}
+
+func TestKeyedLimiter_Config(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	keyedLimiter := NewKeyedLimiter(5, 2, clock)
+
+	config := keyedLimiter.Config()
+
+	if config.Capacity != 5 || config.RefillRate != 2 {
+		t.Errorf("expected capacity 5 and refill rate 2, got %f and %f", config.Capacity, config.RefillRate)
+	}
+}
+
+func TestKeyedLimiter_Describe(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	keyedLimiter := NewKeyedLimiter(100, 1, clock)
+
+	keyedLimiter.Allow("user-1", 45)
+
+	expected := "Used 45 of 100 this window, resets in 45s."
+	if s := keyedLimiter.Describe("user-1"); s != expected {
+		t.Errorf("expected %q, got %q", expected, s)
+	}
+
+	keyedLimiter.Allow("user-1", 55)
+
+	if !keyedLimiter.Status("user-1").Throttled {
+		t.Error("expected status to be throttled on an empty bucket")
+	}
+
+	if keyedLimiter.Status("user-2").Used != 0 {
+		t.Error("expected an unknown key to report no usage")
+	}
+}
+
+func TestKeyedLimiter_EvictIdle(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	keyedLimiter := NewKeyedLimiter(5, 1, clock, WithIdleTTL(time.Minute))
+
+	keyedLimiter.Allow("user-1", 1)
+	keyedLimiter.Allow("user-2", 1)
+
+	clock.Advance(30 * time.Second)
+	keyedLimiter.Allow("user-2", 1)
+
+	clock.Advance(45 * time.Second)
+
+	if evicted := keyedLimiter.EvictIdle(); evicted != 1 {
+		t.Errorf("expected 1 bucket to be evicted, got %d", evicted)
+	}
+
+	if _, ok := keyedLimiter.bucket("user-1"); ok {
+		t.Error("expected user-1 to be evicted")
+	}
+
+	if _, ok := keyedLimiter.bucket("user-2"); !ok {
+		t.Error("expected user-2 to be kept")
+	}
+}
+
+func TestKeyedLimiter_EvictIdleKeepsPartialBuckets(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	keyedLimiter := NewKeyedLimiter(100, 0.1, clock, WithIdleTTL(time.Minute))
+
+	keyedLimiter.Allow("user-1", 100)
+	clock.Advance(2 * time.Minute)
+
+	if evicted := keyedLimiter.EvictIdle(); evicted != 0 {
+		t.Errorf("expected a refilling bucket not to be evicted, got %d", evicted)
+	}
+}
+
+func TestKeyedLimiter_EvictIdleRepeatedSweeps(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	keyedLimiter := NewKeyedLimiter(5, 1, clock, WithIdleTTL(time.Minute))
+
+	keyedLimiter.Allow("user-1", 1)
+
+	for range 4 {
+		clock.Advance(20 * time.Second)
+		keyedLimiter.EvictIdle()
+	}
+
+	if _, ok := keyedLimiter.bucket("user-1"); ok {
+		t.Error("expected sweeps not to reset a bucket's idle time")
+	}
+}
+
+func TestKeyedLimiter_BackgroundEviction(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	keyedLimiter := NewKeyedLimiterWithEviction(5, 1, clock, time.Minute, time.Millisecond)
+	defer keyedLimiter.Close()
+
+	keyedLimiter.Allow("user-1", 1)
+	clock.Advance(2 * time.Minute)
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		_, ok := keyedLimiter.bucket("user-1")
+
+		if !ok {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatal("expected the sweeper to evict the idle bucket")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestKeyedLimiter_CloseIsIdempotent(t *testing.T) {
+	keyedLimiter := NewKeyedLimiterWithEviction(5, 1, RealClock{}, time.Minute, time.Minute)
+
+	keyedLimiter.Close()
+	keyedLimiter.Close()
+
+	NewKeyedLimiter(5, 1, RealClock{}).Close()
+}
+
+func TestKeyedLimiter_CloseStopsSweeper(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	keyedLimiter := NewKeyedLimiterWithEviction(5, 1, RealClock{}, time.Minute, time.Millisecond)
+	if err := keyedLimiter.Close(); err != nil {
+		t.Fatalf("expected no error from Close, got %v", err)
+	}
+
+	if !keyedLimiter.Allow("user1", 1) {
+		t.Error("expected the limiter to keep working after Close")
+	}
+}
+
+func TestKeyedLimiter_Remaining(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	keyedLimiter := NewKeyedLimiter(5, 2, clock)
+
+	if remaining, _ := keyedLimiter.Remaining("user-1"); remaining != 5 {
+		t.Errorf("expected 5 tokens for an unknown key, got %f", remaining)
+	}
+
+	keyedLimiter.Allow("user-1", 4)
+	clock.Advance(250 * time.Millisecond)
+
+	if remaining, _ := keyedLimiter.Remaining("user-1"); remaining != 1.5 {
+		t.Errorf("expected 1.5 tokens after refill, got %f", remaining)
+	}
+}
+
+func TestKeyedLimiter_AllowWithRetry(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	keyedLimiter := NewKeyedLimiter(5, 2, clock)
+
+	keyedLimiter.Allow("user-1", 5)
+
+	if allowed, retry := keyedLimiter.AllowWithRetry("user-1", 2); allowed || retry != time.Second {
+		t.Errorf("expected deny with 1s retry, got %v, %v", allowed, retry)
+	}
+}
+
+func TestKeyedLimiter_AllowWithLevel(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	keyedLimiter := NewKeyedLimiter(5, 2, clock)
+
+	allowed, level := keyedLimiter.AllowWithLevel("user-1", 2)
+
+	if !allowed {
+		t.Error("expected allow to return true")
+	}
+
+	if tokens := bucketOf(keyedLimiter, "user-1").Tokens(); tokens != level {
+		t.Errorf("expected level %f to match Tokens, got %f", level, tokens)
+	}
+}
+
+type MockEvictionMetrics struct {
+	evicted []string
+}
+
+func (m *MockEvictionMetrics) OnEvict(key string) {
+	m.evicted = append(m.evicted, key)
+}
+
+func TestKeyedLimiter_MaxKeysEvictsLeastRecentlyUsed(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	metrics := &MockEvictionMetrics{}
+	keyedLimiter := NewKeyedLimiter(5, 1, clock, WithMaxKeys(2), WithEvictionMetrics(metrics))
+
+	keyedLimiter.Allow("user-1", 5)
+	keyedLimiter.Allow("user-2", 1)
+	keyedLimiter.Allow("user-1", 0)
+	keyedLimiter.Allow("user-3", 1)
+
+	if keyedLimiter.size() != 2 {
+		t.Errorf("expected 2 buckets, got %d", keyedLimiter.size())
+	}
+
+	if _, ok := keyedLimiter.bucket("user-2"); ok {
+		t.Error("expected the least recently used key to be evicted")
+	}
+
+	if len(metrics.evicted) != 1 || metrics.evicted[0] != "user-2" {
+		t.Errorf("expected OnEvict for user-2, got %v", metrics.evicted)
+	}
+
+	if keyedLimiter.Allow("user-1", 1) {
+		t.Error("expected the recently used key to keep its limit")
+	}
+}
+
+func TestKeyedLimiter_MaxKeysWithIdleEviction(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	keyedLimiter := NewKeyedLimiter(5, 1, clock, WithMaxKeys(2), WithIdleTTL(time.Minute))
+
+	keyedLimiter.Allow("user-1", 1)
+	clock.Advance(2 * time.Minute)
+	keyedLimiter.EvictIdle()
+
+	keyedLimiter.Allow("user-2", 1)
+	keyedLimiter.Allow("user-3", 1)
+
+	if keyedLimiter.lru.Len() != 2 || len(keyedLimiter.lruElems) != 2 {
+		t.Errorf("expected the LRU to track 2 keys, got %d", keyedLimiter.lru.Len())
+	}
+}
+
+func TestKeyedLimiter_AllowChecked(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	keyedLimiter := NewKeyedLimiter(5, 1, clock)
+
+	if _, err := keyedLimiter.AllowChecked("user-1", 6); err != ErrExceedsCapacity {
+		t.Errorf("expected ErrExceedsCapacity, got %v", err)
+	}
+
+	keyedLimiter.Allow("user-1", 5)
+	if allowed, err := keyedLimiter.AllowChecked("user-1", 1); allowed || err != nil {
+		t.Errorf("expected (false, nil) when throttled, got %v, %v", allowed, err)
+	}
+}
+
+func TestKeyedLimiter_SetKeyLimit(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	keyedLimiter := NewKeyedLimiter(10, 10, clock)
+	keyedLimiter.SetKeyLimit("enterprise", 1000, 1000)
+
+	if !keyedLimiter.Allow("enterprise", 500) {
+		t.Error("expected the override capacity to apply to a new bucket")
+	}
+
+	if keyedLimiter.Allow("free", 11) {
+		t.Error("expected other keys to keep the default capacity")
+	}
+
+	if status := keyedLimiter.Status("enterprise"); status.Limit != 1000 {
+		t.Errorf("expected the status limit to be 1000, got %f", status.Limit)
+	}
+
+	keyedLimiter.SetLimits(20, 20)
+	if remaining, _ := keyedLimiter.Remaining("enterprise"); remaining != 500 {
+		t.Errorf("expected changing the defaults not to touch overrides, got %f", remaining)
+	}
+}
+
+func TestKeyedLimiter_SetKeyLimitResizesExistingBucket(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	keyedLimiter := NewKeyedLimiter(10, 10, clock)
+
+	keyedLimiter.Allow("user-1", 2)
+	keyedLimiter.SetKeyLimit("user-1", 5, 1)
+
+	if remaining, _ := keyedLimiter.Remaining("user-1"); remaining != 5 {
+		t.Errorf("expected tokens to be clamped to the new capacity, got %f", remaining)
+	}
+
+	if remaining, _ := keyedLimiter.Remaining("user-2"); remaining != 10 {
+		t.Errorf("expected unknown keys to report the default capacity, got %f", remaining)
+	}
+}
+
+func TestKeyedLimiter_SetLimits(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	keyedLimiter := NewKeyedLimiter(10, 1, clock)
+
+	keyedLimiter.Allow("user-1", 1)
+	keyedLimiter.SetLimits(5, 2)
+
+	if config := keyedLimiter.Config(); config.Capacity != 5 || config.RefillRate != 2 {
+		t.Errorf("expected the config to reflect the new limits, got %+v", config)
+	}
+
+	if remaining, _ := keyedLimiter.Remaining("user-1"); remaining != 5 {
+		t.Errorf("expected existing buckets to be clamped, got %f", remaining)
+	}
+
+	if keyedLimiter.Allow("user-2", 6) {
+		t.Error("expected new buckets to use the new capacity")
+	}
+}
+
+func TestKeyedLimiter_AcquireProceedsNow(t *testing.T) {
+	kl := NewKeyedLimiter(5, 1, RealClock{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+
+	if err := kl.Acquire(ctx, "key", 5); err != nil {
+		t.Errorf("expected available tokens to be taken, got %v", err)
+	}
+}
+
+func TestKeyedLimiter_AcquireWaitsWithinDeadline(t *testing.T) {
+	kl := NewKeyedLimiter(1, 50, RealClock{})
+	kl.Allow("key", 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	start := time.Now()
+	if err := kl.Acquire(ctx, "key", 1); err != nil {
+		t.Fatalf("expected the wait to fit in the deadline, got %v", err)
+	}
+
+	if waited := time.Since(start); waited < 10*time.Millisecond {
+		t.Errorf("expected to wait for the refill, waited %v", waited)
+	}
+}
+
+func TestKeyedLimiter_AcquireFailsFast(t *testing.T) {
+	kl := NewKeyedLimiter(1, 0.1, RealClock{})
+	kl.Allow("key", 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	start := time.Now()
+	if err := kl.Acquire(ctx, "key", 1); err != ErrWouldExceedDeadline {
+		t.Fatalf("expected ErrWouldExceedDeadline, got %v", err)
+	}
+
+	if waited := time.Since(start); waited > 50*time.Millisecond {
+		t.Errorf("expected to fail without waiting, took %v", waited)
+	}
+
+	if err := kl.Acquire(ctx, "key", 2); err != ErrExceedsCapacity {
+		t.Errorf("expected ErrExceedsCapacity, got %v", err)
+	}
+}
+
+func TestKeyedLimiter_SetLimitForReverts(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	kl := NewKeyedLimiter(10, 0, clock)
+
+	kl.Allow("user-1", 5)
+	kl.SetLimitFor("user-1", 20, 0, time.Hour)
+
+	if status := kl.Status("user-1"); status.Limit != 20 || status.Used != 10 {
+		t.Fatalf("expected the boost to keep the bucket half full, got limit %v used %v", status.Limit, status.Used)
+	}
+
+	kl.Allow("user-1", 5)
+	clock.Advance(time.Hour)
+	kl.Allow("user-1", 0)
+
+	if status := kl.Status("user-1"); status.Limit != 10 || status.Used != 7.5 {
+		t.Errorf("expected the revert to keep a quarter of the tokens, got limit %v used %v", status.Limit, status.Used)
+	}
+}
+
+func TestKeyedLimiter_SetLimitForRestoresKeyLimit(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	kl := NewKeyedLimiter(10, 0, clock)
+
+	kl.SetKeyLimit("user-1", 50, 0)
+	kl.SetLimitFor("user-1", 100, 0, time.Minute)
+	kl.SetLimitFor("user-1", 200, 0, 2*time.Minute)
+
+	clock.Advance(time.Minute)
+	kl.Allow("user-1", 0)
+
+	if limit := kl.Status("user-1").Limit; limit != 200 {
+		t.Errorf("expected the latest boost to still apply, got %v", limit)
+	}
+
+	clock.Advance(time.Minute)
+	kl.Allow("user-1", 0)
+
+	if limit := kl.Status("user-1").Limit; limit != 50 {
+		t.Errorf("expected the key's own limit back, got %v", limit)
+	}
+}
+
+func TestKeyedLimiter_Reset(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	kl := NewKeyedLimiter(5, 0, clock)
+	kl.SetKeyLimit("user-1", 10, 0)
+
+	kl.Allow("user-1", 10)
+	kl.Allow("user-2", 5)
+
+	if err := kl.Reset("user-1"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !kl.Allow("user-1", 10) {
+		t.Error("expected the reset key to start full with its own limit")
+	}
+
+	if kl.Allow("user-2", 1) {
+		t.Error("expected other keys to be untouched")
+	}
+}
+
+func TestKeyedLimiter_AddAndSetTokensClamp(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	kl := NewKeyedLimiter(10, 0, clock)
+
+	kl.Allow("user-1", 8)
+
+	if got := kl.AddTokens("user-1", 5); got != 7 {
+		t.Errorf("expected 7 tokens after the credit, got %v", got)
+	}
+
+	if got := kl.AddTokens("user-1", 50); got != 10 {
+		t.Errorf("expected the credit to cap at capacity, got %v", got)
+	}
+
+	if got := kl.SetTokens("user-1", -3); got != 0 {
+		t.Errorf("expected SetTokens never to go negative, got %v", got)
+	}
+
+	if got := kl.SetTokens("user-1", 4); got != 4 {
+		t.Errorf("expected 4 tokens, got %v", got)
+	}
+}
+
+func TestKeyedLimiter_Snapshot(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	keyedLimiter := NewKeyedLimiter(5, 1, clock)
+
+	if state := keyedLimiter.Snapshot("user-1"); state.Tokens != 5 || state.Capacity != 5 {
+		t.Errorf("expected a full bucket for an unknown key, got %+v", state)
+	}
+
+	if _, ok := keyedLimiter.bucket("user-1"); ok {
+		t.Error("expected Snapshot not to create a bucket")
+	}
+
+	keyedLimiter.Allow("user-1", 3)
+	clock.Advance(time.Second)
+
+	if state := keyedLimiter.Snapshot("user-1"); state.Tokens != 3 {
+		t.Errorf("expected 3 tokens after refill, got %f", state.Tokens)
+	}
+}
+
+func TestKeyedLimiter_Preload(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	keyedLimiter := NewKeyedLimiter(5, 1, clock)
+	keyedLimiter.SetKeyLimit("vip", 50, 10)
+	keyedLimiter.Allow("user-1", 5)
+
+	keyedLimiter.Preload([]string{"user-1", "user-2", "vip"})
+
+	if keyedLimiter.size() != 3 {
+		t.Fatalf("expected 3 buckets, got %d", keyedLimiter.size())
+	}
+
+	if tokens := bucketOf(keyedLimiter, "user-1").Tokens(); tokens != 0 {
+		t.Errorf("expected preloading an existing key to leave it alone, got %f tokens", tokens)
+	}
+
+	if tokens := bucketOf(keyedLimiter, "vip").Tokens(); tokens != 50 {
+		t.Errorf("expected the preloaded key to use its override, got %f tokens", tokens)
+	}
+}
+
+func TestKeyedLimiter_PreloadRespectsMaxKeys(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	keyedLimiter := NewKeyedLimiter(5, 1, clock, WithMaxKeys(2))
+
+	keyedLimiter.Preload([]string{"a", "b", "c"})
+
+	if _, ok := keyedLimiter.bucket("a"); ok || keyedLimiter.size() != 2 {
+		t.Errorf("expected the earliest key to be evicted, got %d buckets", keyedLimiter.size())
+	}
+}
+
+func TestKeyedLimiter_ConcurrentKeysAcrossShards(t *testing.T) {
+	keyedLimiter := NewKeyedLimiter(1, 0, &MockClock{current: time.Now()})
+
+	var wg sync.WaitGroup
+	var allowed atomic.Int64
+	for g := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 1000 {
+				// Every goroutine contends for every key, from a different
+				// starting point.
+				if keyedLimiter.Allow("key-"+strconv.Itoa((i+g*125)%1000), 1) {
+					allowed.Add(1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if keyedLimiter.size() != 1000 || allowed.Load() != 1000 {
+		t.Errorf("expected 1000 buckets each allowing once, got %d buckets and %d allows", keyedLimiter.size(), allowed.Load())
+	}
+}
+
+func TestKeyedLimiter_ShardFor(t *testing.T) {
+	keyedLimiter := NewKeyedLimiter(5, 1, &MockClock{current: time.Now()}, withShards(8))
+
+	for i := range 100 {
+		key := "key-" + strconv.Itoa(i)
+		keyedLimiter.Allow(key, 1)
+
+		idx := keyedLimiter.ShardFor(key)
+		if idx < 0 || idx >= 8 {
+			t.Fatalf("expected a shard index below 8 for %s, got %d", key, idx)
+		}
+		if _, ok := keyedLimiter.shards[idx].buckets[key]; !ok {
+			t.Errorf("expected shard %d to hold the bucket for %s", idx, key)
+		}
+	}
+}
+
+func TestKeyedLimiter_ShardCountIgnoresNonPositive(t *testing.T) {
+	keyedLimiter := NewKeyedLimiter(5, 1, RealClock{}, withShards(0))
+
+	if len(keyedLimiter.shards) != defaultShards {
+		t.Errorf("expected %d shards, got %d", defaultShards, len(keyedLimiter.shards))
+	}
+}
+
+func TestKeyedLimiter_Check(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	keyedLimiter := NewKeyedLimiter(5, 2, clock)
+
+	res, err := keyedLimiter.Check("user-1", 4)
+	want := Result{Allowed: true, Remaining: 1, Limit: 5, ResetAfter: 2 * time.Second}
+	if err != nil || res != want {
+		t.Errorf("expected %+v, got %+v, %v", want, res, err)
+	}
+
+	res, _ = keyedLimiter.Check("user-1", 2)
+	want = Result{Remaining: 1, Limit: 5, ResetAfter: 2 * time.Second, RetryAfter: 500 * time.Millisecond}
+	if res != want {
+		t.Errorf("expected %+v, got %+v", want, res)
+	}
+
+	if _, err := keyedLimiter.Check("user-1", -1); err != ErrNegativeTokens {
+		t.Errorf("expected ErrNegativeTokens, got %v", err)
+	}
+}
+
+// benchmarkKeys is the key cardinality for the KeyedLimiter benchmarks.
+const benchmarkKeys = 100_000
+
+func benchmarkKeyNames() []string {
+	keys := make([]string, benchmarkKeys)
+	for i := range keys {
+		keys[i] = "key-" + strconv.Itoa(i)
+	}
+	return keys
+}
+
+// benchmarkGoroutines is how many goroutines the KeyedLimiter benchmarks run.
+const benchmarkGoroutines = 64
+
+// runConcurrently splits b.N calls to fn over benchmarkGoroutines goroutines,
+// passing each call its goroutine's index and its count within it.
+// b.RunParallel would run a multiple of GOMAXPROCS goroutines instead.
+func runConcurrently(b *testing.B, fn func(g, i int)) {
+	var wg sync.WaitGroup
+	for g := range benchmarkGoroutines {
+		n := b.N / benchmarkGoroutines
+		if g < b.N%benchmarkGoroutines {
+			n++
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range n {
+				fn(g, i)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+var benchmarkShards = []struct {
+	name string
+	opts []KeyedOption
+}{
+	{"single", []KeyedOption{withShards(1)}},
+	{"sharded", nil},
+}
+
+// BenchmarkKeyedLimiter_ColdKeys measures first calls on new keys, each of
+// which creates its bucket.
+func BenchmarkKeyedLimiter_ColdKeys(b *testing.B) {
+	for _, bc := range benchmarkShards {
+		b.Run(bc.name, func(b *testing.B) {
+			keyedLimiter := NewKeyedLimiter(1e9, 1e9, RealClock{}, bc.opts...)
+			var next atomic.Int64
+
+			runConcurrently(b, func(g, i int) {
+				keyedLimiter.Allow("key-"+strconv.FormatInt(next.Add(1), 10), 1)
+			})
+		})
+	}
+}
+
+// BenchmarkKeyedLimiter_Preloaded measures calls spread over preloaded keys.
+func BenchmarkKeyedLimiter_Preloaded(b *testing.B) {
+	keys := benchmarkKeyNames()
+
+	for _, bc := range benchmarkShards {
+		b.Run(bc.name, func(b *testing.B) {
+			keyedLimiter := NewKeyedLimiter(1e9, 1e9, RealClock{}, bc.opts...)
+			keyedLimiter.Preload(keys)
+
+			b.ResetTimer()
+			runConcurrently(b, func(g, i int) {
+				// Each goroutine walks the keys from its own offset, so they
+				// share no counter.
+				keyedLimiter.Allow(keys[(g*7919+i)%benchmarkKeys], 1)
+			})
+		})
+	}
+}
+
+func TestKeyedLimiter_AllowAtReplaysKeysOutOfOrder(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	keyedLimiter := NewKeyedLimiter(1, 1, clock)
+	base := clock.Now().Add(-time.Hour)
+
+	// Each key's times increase, but the batch interleaves them.
+	events := []struct {
+		key  string
+		at   time.Duration
+		want bool
+	}{
+		{"b", 10 * time.Second, true},
+		{"a", 0, true},
+		{"b", 10*time.Second + 500*time.Millisecond, false},
+		{"a", time.Second, true},
+		{"b", 11 * time.Second, true},
+		{"a", time.Second, false},
+	}
+
+	for i, e := range events {
+		if got := keyedLimiter.AllowAt(e.key, 1, base.Add(e.at)); got != e.want {
+			t.Errorf("event %d (%s at %v): expected %t, got %t", i, e.key, e.at, e.want, got)
+		}
 	}
 }
@@ -0,0 +1,35 @@
+package limiter
+
+import "strings"
+
+const clusterSlots = 16384
+
+// keySlot returns the Redis Cluster hash slot for key, honoring {hash tags}.
+func keySlot(key string) int {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+
+	return int(crc16(key)) % clusterSlots
+}
+
+// crc16 implements CRC16-CCITT (XModem), the checksum Redis Cluster uses for
+// key slots.
+func crc16(s string) uint16 {
+	var crc uint16
+
+	for i := 0; i < len(s); i++ {
+		crc ^= uint16(s[i]) << 8
+		for range 8 {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+
+	return crc
+}
@@ -0,0 +1,17 @@
+package limiter
+
+import "testing"
+
+func TestKeySlot_MatchesRedisCluster(t *testing.T) {
+	if slot := keySlot("123456789"); slot != 12739 {
+		t.Errorf("expected slot 12739, got %d", slot)
+	}
+
+	if keySlot("{user1000}.following") != keySlot("{user1000}.followers") {
+		t.Error("expected keys sharing a hash tag to share a slot")
+	}
+
+	if keySlot("foo{}{bar}") != int(crc16("foo{}{bar}"))%clusterSlots {
+		t.Error("expected an empty hash tag to hash the whole key")
+	}
+}
@@ -0,0 +1,145 @@
+package limiter
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// LeakyBucket models a queue of up to capacity tokens that drains at a
+// constant leakRate per second. Requests join the back of the queue and are
+// released as the tokens ahead of them drain, so a burst of accepted requests
+// goes out evenly spaced rather than all at once.
+//
+// Prefer it over TokenBucket when a downstream enforces strict pacing and
+// bursts are what get you throttled, such as a third-party API with a fixed
+// request rate. Prefer TokenBucket when bursts are fine and callers shouldn't
+// be delayed while capacity remains.
+type LeakyBucket struct {
+	mu       sync.Mutex
+	capacity float64
+	leakRate float64
+	// level is the number of queued tokens as of lastLeak.
+	level    float64
+	lastLeak time.Time
+	clock    Clock
+}
+
+func NewLeakyBucket(capacity float64, leakRate float64, clock Clock) *LeakyBucket {
+	return &LeakyBucket{
+		capacity: capacity,
+		leakRate: leakRate,
+		lastLeak: clock.Now(),
+		clock:    clock,
+	}
+}
+
+func (lb *LeakyBucket) leak() {
+	now := lb.clock.Now()
+	elapsed := now.Sub(lb.lastLeak).Seconds()
+
+	if elapsed > 0 {
+		lb.level = max(lb.level-elapsed*lb.leakRate, 0)
+		lb.lastLeak = now
+	}
+}
+
+// Allow queues the request if it fits and reports whether it did. It doesn't
+// pace: a true result means the request has a place in the queue, not that
+// its slot has drained. Use Wait to be released at the leak rate.
+func (lb *LeakyBucket) Allow(requested int) bool {
+	if requested < 0 {
+		return false
+	}
+
+	allowed, _ := lb.enqueue(requested)
+	return allowed
+}
+
+// Wait blocks until the request fits in the queue and then until the tokens
+// ahead of it have drained. If ctx is cancelled while queued, the request's
+// tokens are removed from the queue.
+func (lb *LeakyBucket) Wait(ctx context.Context, requested int) error {
+	if requested < 0 {
+		return ErrNegativeTokens
+	}
+
+	if float64(requested) > lb.capacity {
+		return ErrExceedsCapacity
+	}
+
+	for {
+		queued, waitDuration := lb.enqueue(requested)
+		if queued && waitDuration == 0 {
+			return nil
+		}
+
+		timer := time.NewTimer(waitDuration)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			if queued {
+				lb.dequeue(requested)
+			}
+			return ctx.Err()
+		case <-timer.C:
+		}
+
+		if queued {
+			return nil
+		}
+	}
+}
+
+// enqueue adds requested tokens to the queue if they fit, returning how long
+// until the tokens ahead of them drain. Otherwise it returns how long until
+// there is room.
+func (lb *LeakyBucket) enqueue(requested int) (bool, time.Duration) {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	if float64(requested) > lb.capacity {
+		return false, NeverAvailable
+	}
+
+	lb.leak()
+	ahead := lb.level
+
+	if ahead+float64(requested) > lb.capacity {
+		return false, lb.drainTime(ahead + float64(requested) - lb.capacity)
+	}
+
+	lb.level += float64(requested)
+
+	if ahead == 0 {
+		return true, 0
+	}
+	return true, lb.drainTime(ahead)
+}
+
+func (lb *LeakyBucket) dequeue(requested int) {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	lb.leak()
+	lb.level = max(lb.level-float64(requested), 0)
+}
+
+// drainTime returns how long tokens take to drain, or NeverAvailable if the
+// bucket doesn't leak.
+func (lb *LeakyBucket) drainTime(tokens float64) time.Duration {
+	if lb.leakRate <= 0 {
+		return NeverAvailable
+	}
+
+	return time.Duration(tokens / lb.leakRate * float64(time.Second))
+}
+
+// Queued returns the number of tokens waiting to drain.
+func (lb *LeakyBucket) Queued() float64 {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	lb.leak()
+	return lb.level
+}
@@ -0,0 +1,79 @@
+package limiter
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLeakyBucket_RejectsWhenFull(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	bucket := NewLeakyBucket(3, 1, clock)
+
+	for i := range 3 {
+		if !bucket.Allow(1) {
+			t.Errorf("expected request %d to be queued", i+1)
+		}
+	}
+
+	if bucket.Allow(1) {
+		t.Error("expected a full queue to reject")
+	}
+
+	clock.Advance(time.Second)
+
+	if !bucket.Allow(1) {
+		t.Error("expected room once a token has drained")
+	}
+
+	if got := bucket.Queued(); got != 3 {
+		t.Errorf("expected 3 queued tokens, got %v", got)
+	}
+}
+
+func TestLeakyBucket_WaitPacesReleases(t *testing.T) {
+	bucket := NewLeakyBucket(5, 50, RealClock{})
+
+	start := time.Now()
+	var releases []time.Duration
+	for range 4 {
+		if err := bucket.Wait(context.Background(), 1); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		releases = append(releases, time.Since(start))
+	}
+
+	if releases[0] > 10*time.Millisecond {
+		t.Errorf("expected the first request to go immediately, took %v", releases[0])
+	}
+
+	for i := 1; i < len(releases); i++ {
+		if gap := releases[i] - releases[i-1]; gap < 15*time.Millisecond {
+			t.Errorf("expected releases spaced by the leak rate, gap %d was %v", i, gap)
+		}
+	}
+}
+
+func TestLeakyBucket_WaitCancelledLeavesQueue(t *testing.T) {
+	bucket := NewLeakyBucket(5, 1, RealClock{})
+	bucket.Allow(2)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if err := bucket.Wait(ctx, 2); err != context.DeadlineExceeded {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+
+	if got := bucket.Queued(); got > 2 {
+		t.Errorf("expected the cancelled request to leave the queue, got %v queued", got)
+	}
+}
+
+func TestLeakyBucket_WaitExceedsCapacity(t *testing.T) {
+	bucket := NewLeakyBucket(2, 1, RealClock{})
+
+	if err := bucket.Wait(context.Background(), 3); err != ErrExceedsCapacity {
+		t.Errorf("expected ErrExceedsCapacity, got %v", err)
+	}
+}
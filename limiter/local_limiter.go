@@ -0,0 +1,157 @@
+package limiter
+
+import (
+	"context"
+	"time"
+)
+
+// LocalLimiter is an in-memory, single-node limiter that takes the same
+// Options as RedisLimiter, so code developed against it can switch to Redis in
+// production by changing only the constructor. Options that only concern
+// Redis, such as failure modes, circuit breakers and key TTLs, have nothing to
+// act on and are ignored; WithMetrics and its sampling options, WithTracer,
+// WithCostPipeline and WithShadowMode apply as they do to RedisLimiter, and
+// WithClock drives the in-memory buckets.
+type LocalLimiter struct {
+	keyed        *KeyedLimiter
+	metrics      Metrics
+	tracer       Tracer
+	costPipeline *CostPipeline
+	shadow       bool
+}
+
+func NewLocalLimiter(capacity float64, refillRate float64, opts ...Option) *LocalLimiter {
+	// Options configure a RedisLimiter; LocalLimiter takes the settings that
+	// apply without Redis from one that is never used.
+	cfg := &RedisLimiter{metrics: NoopMetrics{}, clock: RealClock{}, sampleRate: 1}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	return &LocalLimiter{
+		keyed:        NewKeyedLimiter(capacity, refillRate, cfg.clock),
+		metrics:      cfg.sampledMetrics(),
+		tracer:       cfg.tracer,
+		costPipeline: cfg.costPipeline,
+		shadow:       cfg.shadow,
+	}
+}
+
+func (l *LocalLimiter) Allow(key string, tokens int) bool {
+	allowed, _ := l.AllowWithRetry(key, tokens)
+	return allowed
+}
+
+// AllowWithRetry behaves like Allow and, on denial, also returns how long until
+// enough tokens will have refilled, or NeverAvailable.
+func (l *LocalLimiter) AllowWithRetry(key string, tokens int) (bool, time.Duration) {
+	res, _ := l.check(context.Background(), "AllowWithRetry", key, tokens)
+	return res.Allowed, res.RetryAfter
+}
+
+// Check behaves like RedisLimiter.Check. The error is ErrNegativeTokens for a
+// negative request.
+func (l *LocalLimiter) Check(key string, tokens int) (Result, error) {
+	return l.check(context.Background(), "Check", key, tokens)
+}
+
+// check costs and decides a request, inside a span if a tracer is set.
+func (l *LocalLimiter) check(ctx context.Context, operation string, key string, tokens int) (Result, error) {
+	if tokens < 0 {
+		return Result{RetryAfter: NeverAvailable}, ErrNegativeTokens
+	}
+
+	var span Span
+	if l.tracer != nil {
+		ctx, span = l.tracer.Start(ctx, operation, key)
+	}
+
+	tokens = l.cost(ctx, key, tokens)
+	res, err := l.keyed.Check(key, tokens)
+	if res.Allowed {
+		recordAllow(l.metrics, key, float64(tokens))
+	} else {
+		recordDeny(l.metrics, key, l.shadow)
+		if l.shadow {
+			res.Allowed = true
+			res.RetryAfter = 0
+		}
+	}
+
+	if span != nil {
+		span.End(res.Allowed, float64(tokens), 0, err)
+	}
+
+	return res, err
+}
+
+func (l *LocalLimiter) Wait(ctx context.Context, key string, tokens int) error {
+	var span Span
+	if l.tracer != nil {
+		ctx, span = l.tracer.Start(ctx, "Wait", key)
+	}
+
+	if tokens >= 0 {
+		tokens = l.cost(ctx, key, tokens)
+	}
+
+	var err error
+	if l.shadow {
+		err = l.shadowWait(key, tokens)
+	} else {
+		err = l.keyed.Wait(ctx, key, tokens)
+	}
+	if err == nil {
+		recordAllow(l.metrics, key, float64(tokens))
+	}
+
+	if span != nil {
+		span.End(err == nil, float64(tokens), 0, err)
+	}
+
+	return err
+}
+
+// shadowWait takes the tokens if they are available and otherwise reports a
+// shadow deny, returning at once instead of waiting. Requests Wait would
+// reject outright still fail.
+func (l *LocalLimiter) shadowWait(key string, tokens int) error {
+	if float64(tokens) > l.keyed.Config().Capacity {
+		return ErrExceedsCapacity
+	}
+
+	res, err := l.keyed.Check(key, tokens)
+	if err != nil || res.Allowed {
+		return err
+	}
+
+	recordDeny(l.metrics, key, true)
+	return nil
+}
+
+// Remaining returns the key's current token count without consuming anything.
+func (l *LocalLimiter) Remaining(key string) (float64, error) {
+	return l.keyed.Remaining(key)
+}
+
+// Reset deletes the key's bucket, so its next call starts with a full one.
+func (l *LocalLimiter) Reset(key string) error {
+	return l.keyed.Reset(key)
+}
+
+func (l *LocalLimiter) Config() LimiterConfig {
+	_, noop := l.metrics.(NoopMetrics)
+	config := l.keyed.Config()
+	config.Metrics = !noop
+	config.Shadow = l.shadow
+
+	return config
+}
+
+func (l *LocalLimiter) cost(ctx context.Context, key string, tokens int) int {
+	if l.costPipeline == nil {
+		return tokens
+	}
+
+	return l.costPipeline.Cost(ctx, key, tokens)
+}
@@ -0,0 +1,70 @@
+package limiter
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLocalLimiter_SharesRedisOptions(t *testing.T) {
+	metrics := &MockMetrics{}
+	pipeline := NewCostPipeline(5, func(ctx context.Context, key string, cost int) int {
+		return cost * 2
+	})
+
+	var l Limiter = NewLocalLimiter(5, 0,
+		WithMetrics(metrics),
+		WithCostPipeline(pipeline),
+		WithFailureMode(FailClosed),
+		WithCircuitBreaker(3, time.Second))
+
+	if !l.Allow("key", 2) {
+		t.Fatal("expected the first request to be allowed")
+	}
+
+	if l.Allow("key", 1) {
+		t.Error("expected the doubled cost to leave too few tokens")
+	}
+
+	if len(metrics.allows) != 1 || len(metrics.denies) != 1 || metrics.consumed["key"] != 4 {
+		t.Errorf("expected 1 allow of 4 tokens and 1 deny, got %v, %v, %v", metrics.allows, metrics.denies, metrics.consumed)
+	}
+}
+
+func TestLocalLimiter_CheckAndWait(t *testing.T) {
+	l := NewLocalLimiter(2, 20)
+
+	res, err := l.Check("key", 2)
+	if err != nil || !res.Allowed || res.Limit != 2 || res.Remaining != 0 {
+		t.Fatalf("expected an allow draining the bucket, got %+v, %v", res, err)
+	}
+
+	if err := l.Wait(context.Background(), "key", 1); err != nil {
+		t.Errorf("expected the wait to succeed, got %v", err)
+	}
+
+	if err := l.Wait(context.Background(), "key", -1); err != ErrNegativeTokens {
+		t.Errorf("expected ErrNegativeTokens, got %v", err)
+	}
+}
+
+func TestLocalLimiter_ShadowMode(t *testing.T) {
+	metrics := &shadowMetrics{}
+	l := NewLocalLimiter(1, 0, WithMetrics(metrics), WithShadowMode(true))
+
+	if !l.Allow("key", 1) || !l.Allow("key", 1) {
+		t.Fatal("expected both requests to be allowed in shadow mode")
+	}
+
+	if err := l.Wait(context.Background(), "key", 1); err != nil {
+		t.Errorf("expected Wait to return at once in shadow mode, got %v", err)
+	}
+
+	if err := l.Wait(context.Background(), "key", 2); err != ErrExceedsCapacity {
+		t.Errorf("expected ErrExceedsCapacity, got %v", err)
+	}
+
+	if len(metrics.shadowDenies) != 2 || len(metrics.denies) != 0 {
+		t.Errorf("expected two shadow denies and no real ones, got %v and %v", metrics.shadowDenies, metrics.denies)
+	}
+}
@@ -0,0 +1,184 @@
+// Package metricsprom implements limiter.Metrics with Prometheus collectors.
+// It lives outside the limiter package so the core doesn't depend on the
+// Prometheus client.
+package metricsprom
+
+import (
+	"hash/fnv"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/schoolboybru/distributed-rate-limiter/limiter"
+)
+
+// KeyMode controls how limiter keys are turned into the "key" label.
+//
+// Every distinct label value is a separate time series, so labelling by raw
+// key is only safe when the key space is small and fixed. Keys such as user
+// IDs or IPs will grow series without bound; use KeyBuckets or NoKeyLabel for
+// those.
+type KeyMode int
+
+const (
+	// FullKey labels each series with the limiter key as is.
+	FullKey KeyMode = iota
+	// KeyBuckets hashes keys into a fixed number of label values.
+	KeyBuckets
+	// NoKeyLabel drops the key label entirely.
+	NoKeyLabel
+)
+
+// Metrics records allows, denies, shadow denies, errors and consumed tokens as
+// counters and Redis latency as a histogram. Shadow denies, the requests a
+// limiter in shadow mode would have denied, are counted apart from real ones. The latency histogram is never labelled by
+// key. The circuit breaker's state is a gauge: 0 closed, 1 open, 2 half-open.
+type Metrics struct {
+	allows   *prometheus.CounterVec
+	denies   *prometheus.CounterVec
+	shadow   *prometheus.CounterVec
+	errors   *prometheus.CounterVec
+	consumed *prometheus.CounterVec
+	latency  prometheus.Histogram
+	circuit  prometheus.Gauge
+	mode     KeyMode
+	buckets  uint32
+}
+
+type config struct {
+	namespace string
+	mode      KeyMode
+	buckets   uint32
+}
+
+type Option func(*config)
+
+// WithNamespace prefixes every metric name. The default is "ratelimit".
+func WithNamespace(namespace string) Option {
+	return func(c *config) {
+		c.namespace = namespace
+	}
+}
+
+// WithoutKeyLabel aggregates all keys into a single series per metric.
+func WithoutKeyLabel() Option {
+	return func(c *config) {
+		c.mode = NoKeyLabel
+	}
+}
+
+// WithKeyBuckets hashes keys into n label values, bounding cardinality while
+// still showing whether load is concentrated on a few keys.
+func WithKeyBuckets(n int) Option {
+	return func(c *config) {
+		c.mode = KeyBuckets
+		c.buckets = uint32(max(n, 1))
+	}
+}
+
+// New creates the collectors and registers them with reg.
+func New(reg prometheus.Registerer, opts ...Option) (*Metrics, error) {
+	cfg := &config{
+		namespace: "ratelimit",
+		mode:      FullKey,
+	}
+
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	var labels []string
+	if cfg.mode != NoKeyLabel {
+		labels = []string{"key"}
+	}
+
+	m := &Metrics{
+		allows: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: cfg.namespace,
+			Name:      "allowed_total",
+			Help:      "Requests allowed by the rate limiter.",
+		}, labels),
+		denies: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: cfg.namespace,
+			Name:      "denied_total",
+			Help:      "Requests denied by the rate limiter.",
+		}, labels),
+		shadow: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: cfg.namespace,
+			Name:      "shadow_denied_total",
+			Help:      "Requests a rate limiter in shadow mode would have denied.",
+		}, labels),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: cfg.namespace,
+			Name:      "errors_total",
+			Help:      "Errors talking to the rate limiter backend.",
+		}, labels),
+		consumed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: cfg.namespace,
+			Name:      "consumed_tokens_total",
+			Help:      "Tokens consumed by allowed requests.",
+		}, labels),
+		latency: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: cfg.namespace,
+			Name:      "latency_seconds",
+			Help:      "Latency of rate limiter backend calls.",
+			Buckets:   prometheus.ExponentialBuckets(0.0001, 2, 14),
+		}),
+		circuit: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: cfg.namespace,
+			Name:      "circuit_state",
+			Help:      "Circuit breaker state: 0 closed, 1 open, 2 half-open.",
+		}),
+		mode:    cfg.mode,
+		buckets: cfg.buckets,
+	}
+
+	for _, c := range []prometheus.Collector{m.allows, m.denies, m.shadow, m.errors, m.consumed, m.latency, m.circuit} {
+		if err := reg.Register(c); err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
+
+func (m *Metrics) OnAllow(key string) {
+	m.allows.WithLabelValues(m.labels(key)...).Inc()
+}
+
+func (m *Metrics) OnDeny(key string) {
+	m.denies.WithLabelValues(m.labels(key)...).Inc()
+}
+
+func (m *Metrics) OnShadowDeny(key string) {
+	m.shadow.WithLabelValues(m.labels(key)...).Inc()
+}
+
+func (m *Metrics) OnError(key string, err error) {
+	m.errors.WithLabelValues(m.labels(key)...).Inc()
+}
+
+func (m *Metrics) OnConsume(key string, tokens int) {
+	m.consumed.WithLabelValues(m.labels(key)...).Add(float64(tokens))
+}
+
+func (m *Metrics) OnLatency(key string, d time.Duration) {
+	m.latency.Observe(d.Seconds())
+}
+
+func (m *Metrics) OnCircuitStateChange(from, to limiter.CircuitState) {
+	m.circuit.Set(float64(to))
+}
+
+func (m *Metrics) labels(key string) []string {
+	switch m.mode {
+	case NoKeyLabel:
+		return nil
+	case KeyBuckets:
+		h := fnv.New32a()
+		h.Write([]byte(key))
+		return []string{strconv.FormatUint(uint64(h.Sum32()%m.buckets), 10)}
+	default:
+		return []string{key}
+	}
+}
@@ -0,0 +1,115 @@
+package metricsprom
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/schoolboybru/distributed-rate-limiter/limiter"
+)
+
+var _ limiter.Metrics = (*Metrics)(nil)
+var _ limiter.CircuitMetrics = (*Metrics)(nil)
+var _ limiter.ConsumeMetrics = (*Metrics)(nil)
+var _ limiter.ShadowMetrics = (*Metrics)(nil)
+
+func TestMetrics_FullKey(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m, err := New(reg)
+	if err != nil {
+		t.Fatalf("expected registration to succeed, got %v", err)
+	}
+
+	m.OnAllow("user-1")
+	m.OnAllow("user-1")
+	m.OnDeny("user-2")
+	m.OnError("user-2", errors.New("boom"))
+	m.OnLatency("user-1", time.Millisecond)
+
+	if got := testutil.ToFloat64(m.allows.WithLabelValues("user-1")); got != 2 {
+		t.Errorf("expected 2 allows for user-1, got %f", got)
+	}
+
+	m.OnConsume("user-1", 3)
+	m.OnConsume("user-1", 4)
+
+	if got := testutil.ToFloat64(m.consumed.WithLabelValues("user-1")); got != 7 {
+		t.Errorf("expected 7 tokens consumed by user-1, got %f", got)
+	}
+
+	if got := testutil.ToFloat64(m.denies.WithLabelValues("user-2")); got != 1 {
+		t.Errorf("expected 1 deny for user-2, got %f", got)
+	}
+
+	if got := testutil.CollectAndCount(m.latency); got != 1 {
+		t.Errorf("expected a single latency series, got %d", got)
+	}
+}
+
+func TestMetrics_KeyBuckets(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m, _ := New(reg, WithKeyBuckets(4))
+
+	for i := range 1000 {
+		m.OnAllow("user-" + string(rune('a'+i%26)) + string(rune('a'+i/26)))
+	}
+
+	if got := testutil.CollectAndCount(m.allows); got > 4 {
+		t.Errorf("expected at most 4 series, got %d", got)
+	}
+}
+
+func TestMetrics_WithoutKeyLabel(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m, _ := New(reg, WithoutKeyLabel(), WithNamespace("api"))
+
+	m.OnDeny("user-1")
+	m.OnDeny("user-2")
+
+	if got := testutil.CollectAndCount(m.denies, "api_denied_total"); got != 1 {
+		t.Errorf("expected a single series, got %d", got)
+	}
+
+	if got := testutil.ToFloat64(m.denies); got != 2 {
+		t.Errorf("expected 2 denies, got %f", got)
+	}
+}
+
+func TestNew_DuplicateRegistration(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	New(reg)
+
+	if _, err := New(reg); err == nil {
+		t.Error("expected registering twice to fail")
+	}
+}
+
+func TestMetrics_CircuitState(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m, _ := New(reg)
+
+	m.OnCircuitStateChange(limiter.CircuitClosed, limiter.CircuitOpen)
+
+	if got := testutil.ToFloat64(m.circuit); got != 1 {
+		t.Errorf("expected the gauge to report open, got %f", got)
+	}
+}
+
+func TestMetrics_ShadowDeniesCountedApart(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m, _ := New(reg)
+
+	m.OnDeny("user-1")
+	m.OnShadowDeny("user-1")
+	m.OnShadowDeny("user-1")
+
+	if got := testutil.ToFloat64(m.denies.WithLabelValues("user-1")); got != 1 {
+		t.Errorf("expected 1 real deny, got %f", got)
+	}
+
+	if got := testutil.ToFloat64(m.shadow.WithLabelValues("user-1")); got != 2 {
+		t.Errorf("expected 2 shadow denies, got %f", got)
+	}
+}
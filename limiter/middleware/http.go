@@ -0,0 +1,148 @@
+package middleware
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/schoolboybru/distributed-rate-limiter/limiter"
+)
+
+// checkLimiter, retryLimiter, levelLimiter and configLimiter are the optional
+// methods the middleware uses for headers. KeyedLimiter and RedisLimiter
+// implement Check, which yields every header from one decision; otherwise the
+// middleware falls back to the others, leaving out headers it can't fill.
+type checkLimiter interface {
+	Check(key string, tokens int) (limiter.Result, error)
+}
+
+type retryLimiter interface {
+	AllowWithRetry(key string, tokens int) (bool, time.Duration)
+}
+
+type levelLimiter interface {
+	Remaining(key string) (float64, error)
+}
+
+type configLimiter interface {
+	Config() limiter.LimiterConfig
+}
+
+// DenyHandler writes the response for a throttled request. retryAfter is the
+// limiter's estimate of when the request would be allowed, or
+// limiter.NeverAvailable if it has none. Rate limit and Retry-After headers are
+// already set when it is called.
+type DenyHandler func(w http.ResponseWriter, r *http.Request, retryAfter time.Duration)
+
+type rateLimitConfig struct {
+	deny DenyHandler
+}
+
+type Option func(*rateLimitConfig)
+
+// WithDenyHandler replaces the default plain text 429 response.
+func WithDenyHandler(h DenyHandler) Option {
+	return func(c *rateLimitConfig) {
+		c.deny = h
+	}
+}
+
+// RateLimit returns middleware that takes one token per request from l under
+// the key derived by keyFunc. Denied requests get a 429 with a Retry-After
+// header, unless the limiter has no estimate, e.g. because it failed closed;
+// all responses carry X-RateLimit-Limit, X-RateLimit-Remaining and
+// X-RateLimit-Reset, the seconds until the bucket is full again.
+func RateLimit(l limiter.Limiter, keyFunc KeyFunc, opts ...Option) func(http.Handler) http.Handler {
+	cfg := &rateLimitConfig{
+		deny: denyText,
+	}
+
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := keyFunc(r)
+
+			var allowed bool
+			var err error
+			retryAfter := limiter.NeverAvailable
+			if cl, ok := l.(checkLimiter); ok {
+				var res limiter.Result
+				res, err = cl.Check(key, 1)
+				allowed, retryAfter = res.Allowed, res.RetryAfter
+				setResultHeaders(w.Header(), res, err)
+			} else {
+				if rl, ok := l.(retryLimiter); ok {
+					allowed, retryAfter = rl.AllowWithRetry(key, 1)
+				} else {
+					allowed = l.Allow(key, 1)
+				}
+				setLimitHeaders(w.Header(), l, key)
+			}
+
+			if !allowed {
+				// A deny with no wait, e.g. FailClosed during an outage,
+				// doesn't mean the client can retry at once.
+				if retryAfter <= 0 || err != nil {
+					retryAfter = limiter.NeverAvailable
+				}
+				if retryAfter != limiter.NeverAvailable {
+					w.Header().Set("Retry-After", ceilSeconds(retryAfter))
+				}
+				cfg.deny(w, r, retryAfter)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func denyText(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+}
+
+func setLimitHeaders(h http.Header, l limiter.Limiter, key string) {
+	cl, ok := l.(configLimiter)
+	if !ok {
+		return
+	}
+	config := cl.Config()
+	h.Set("X-RateLimit-Limit", strconv.FormatFloat(config.Capacity, 'f', -1, 64))
+
+	ll, ok := l.(levelLimiter)
+	if !ok {
+		return
+	}
+	remaining, err := ll.Remaining(key)
+	if err != nil {
+		return
+	}
+	h.Set("X-RateLimit-Remaining", strconv.FormatFloat(math.Floor(remaining), 'f', -1, 64))
+
+	if config.RefillRate > 0 {
+		reset := time.Duration((config.Capacity - remaining) / config.RefillRate * float64(time.Second))
+		h.Set("X-RateLimit-Reset", ceilSeconds(reset))
+	}
+}
+
+// setResultHeaders sets the rate limit headers from a Check. If Check
+// returned an error the bucket's state is unknown, so only the limit is set.
+func setResultHeaders(h http.Header, res limiter.Result, err error) {
+	h.Set("X-RateLimit-Limit", strconv.FormatFloat(res.Limit, 'f', -1, 64))
+	if err != nil {
+		return
+	}
+
+	h.Set("X-RateLimit-Remaining", strconv.FormatFloat(math.Floor(res.Remaining), 'f', -1, 64))
+	if res.ResetAfter != limiter.NeverAvailable {
+		h.Set("X-RateLimit-Reset", ceilSeconds(res.ResetAfter))
+	}
+}
+
+func ceilSeconds(d time.Duration) string {
+	return strconv.FormatInt(int64(math.Ceil(d.Seconds())), 10)
+}
@@ -0,0 +1,133 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/schoolboybru/distributed-rate-limiter/limiter"
+)
+
+func okHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+}
+
+func TestRateLimit_SetsHeadersAndThrottles(t *testing.T) {
+	keyed := limiter.NewKeyedLimiter(2, 0.5, limiter.RealClock{})
+	handler := RateLimit(keyed, HeaderKey("X-API-Key"))(okHandler())
+
+	serve := func(apiKey string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-API-Key", apiKey)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := serve("key-1")
+	if rec.Code != http.StatusOK || rec.Body.String() != "ok" {
+		t.Fatalf("expected the request to pass through, got %d", rec.Code)
+	}
+
+	headers := map[string]string{
+		"X-RateLimit-Limit":     "2",
+		"X-RateLimit-Remaining": "1",
+		"X-RateLimit-Reset":     "2",
+	}
+	for name, want := range headers {
+		if got := rec.Header().Get(name); got != want {
+			t.Errorf("expected %s %s, got %q", name, want, got)
+		}
+	}
+
+	serve("key-1")
+	rec = serve("key-1")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429, got %d", rec.Code)
+	}
+
+	if rec.Header().Get("Retry-After") != "2" {
+		t.Errorf("expected Retry-After 2, got %q", rec.Header().Get("Retry-After"))
+	}
+
+	if rec.Header().Get("X-RateLimit-Remaining") != "0" {
+		t.Errorf("expected no tokens remaining, got %q", rec.Header().Get("X-RateLimit-Remaining"))
+	}
+
+	if serve("key-2").Code != http.StatusOK {
+		t.Error("expected a different key to have its own limit")
+	}
+}
+
+type allowOnly struct{ allowed bool }
+
+func (a allowOnly) Allow(key string, tokens int) bool                      { return a.allowed }
+func (a allowOnly) Wait(ctx context.Context, key string, tokens int) error { return nil }
+
+func TestRateLimit_PlainLimiter(t *testing.T) {
+	handler := RateLimit(allowOnly{}, PathKey)(okHandler())
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("expected 429, got %d", rec.Code)
+	}
+
+	if len(rec.Header().Values("Retry-After")) != 0 || len(rec.Header().Values("X-RateLimit-Limit")) != 0 {
+		t.Error("expected no rate limit headers from a limiter that can't report them")
+	}
+}
+
+func TestKeyFuncs(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/users/1", nil)
+	req.RemoteAddr = "203.0.113.7:52100"
+	req.Header.Set("Authorization", "token")
+
+	if key := RemoteIPKey(req); key != "ip:203.0.113.7" {
+		t.Errorf("expected ip:203.0.113.7, got %s", key)
+	}
+
+	if key := HeaderKey("Authorization")(req); key != "header:token" {
+		t.Errorf("expected header:token, got %s", key)
+	}
+
+	if key := PathKey(req); key != "path:/users/1" {
+		t.Errorf("expected path:/users/1, got %s", key)
+	}
+}
+
+// failedClosed denies every Check the way a RedisLimiter failing closed does,
+// with no retry estimate.
+type failedClosed struct{ err error }
+
+func (f failedClosed) Allow(key string, tokens int) bool                      { return false }
+func (f failedClosed) Wait(ctx context.Context, key string, tokens int) error { return f.err }
+func (f failedClosed) Check(key string, tokens int) (limiter.Result, error) {
+	return limiter.Result{Limit: 10}, f.err
+}
+
+func TestRateLimit_OmitsRetryAfterWithoutEstimate(t *testing.T) {
+	for name, err := range map[string]error{"error": limiter.ErrCircuitOpen, "no error": nil} {
+		t.Run(name, func(t *testing.T) {
+			handler := RateLimit(failedClosed{err: err}, PathKey, WithDenyHandler(ProblemDetails("")))(okHandler())
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if rec.Code != http.StatusTooManyRequests {
+				t.Fatalf("expected 429, got %d", rec.Code)
+			}
+			if values := rec.Header().Values("Retry-After"); len(values) != 0 {
+				t.Errorf("expected no Retry-After without an estimate, got %v", values)
+			}
+			if strings.Contains(rec.Body.String(), "retry_after") {
+				t.Errorf("expected no retry_after in the problem, got %s", rec.Body.String())
+			}
+		})
+	}
+}
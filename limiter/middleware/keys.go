@@ -0,0 +1,97 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net"
+	"net/http"
+)
+
+// KeyFunc derives the rate limit key for a request.
+type KeyFunc func(r *http.Request) string
+
+// BodyHashKey keys requests by a hash of their body, so repeated identical
+// payloads share a limit. At most maxBytes of the body are buffered and hashed;
+// r.Body is restored so handlers still read the full body.
+func BodyHashKey(maxBytes int64) KeyFunc {
+	return func(r *http.Request) string {
+		if r.Body == nil {
+			return "body:"
+		}
+
+		buf, err := io.ReadAll(io.LimitReader(r.Body, maxBytes))
+		r.Body = readCloser{io.MultiReader(bytes.NewReader(buf), r.Body), r.Body}
+		if err != nil {
+			return "body:"
+		}
+
+		sum := sha256.Sum256(buf)
+		return "body:" + hex.EncodeToString(sum[:])
+	}
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// RemoteIPKey keys requests by client IP, taken from RemoteAddr. Behind a
+// proxy, derive the key from the proxy's forwarded header instead.
+func RemoteIPKey(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return "ip:" + r.RemoteAddr
+	}
+
+	return "ip:" + host
+}
+
+// HeaderKey keys requests by the value of a header, such as an API key.
+func HeaderKey(name string) KeyFunc {
+	return func(r *http.Request) string {
+		return "header:" + r.Header.Get(name)
+	}
+}
+
+// PathKey keys requests by URL path, limiting each endpoint separately.
+func PathKey(r *http.Request) string {
+	return "path:" + r.URL.Path
+}
+
+// RegionResolver maps a client IP to a region code, typically backed by a
+// GeoIP database. It receives nil if RemoteAddr isn't a valid IP.
+type RegionResolver func(ip net.IP) string
+
+// RegionKey keys requests by the client's region alone, so all clients in a
+// region share one aggregate limit.
+func RegionKey(resolve RegionResolver) KeyFunc {
+	return func(r *http.Request) string {
+		return "region:" + resolve(remoteIP(r))
+	}
+}
+
+// RegionIPKey keys requests by region and client IP, giving each client its
+// own limit while letting region-specific limits be set on the key. If
+// RemoteAddr isn't a valid IP the raw address stands in for it, so such
+// clients don't all share one key.
+func RegionIPKey(resolve RegionResolver) KeyFunc {
+	return func(r *http.Request) string {
+		ip := remoteIP(r)
+		if ip == nil {
+			return "region:" + resolve(nil) + ":" + r.RemoteAddr
+		}
+
+		return "region:" + resolve(ip) + ":" + ip.String()
+	}
+}
+
+func remoteIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	return net.ParseIP(host)
+}
@@ -0,0 +1,123 @@
+package middleware
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/schoolboybru/distributed-rate-limiter/limiter"
+)
+
+func limitedHandler(l limiter.Limiter, keyFunc KeyFunc, bodies *[]string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !l.Allow(keyFunc(r), 1) {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+
+		body, _ := io.ReadAll(r.Body)
+		*bodies = append(*bodies, string(body))
+	})
+}
+
+func TestBodyHashKey_ThrottlesIdenticalBodies(t *testing.T) {
+	keyed := limiter.NewKeyedLimiter(2, 0, limiter.RealClock{})
+	var bodies []string
+	handler := limitedHandler(keyed, BodyHashKey(1024), &bodies)
+
+	codes := make([]int, 0, 3)
+	for range 3 {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/ingest", strings.NewReader(`{"id":1}`)))
+		codes = append(codes, rec.Code)
+	}
+
+	if codes[0] != http.StatusOK || codes[1] != http.StatusOK || codes[2] != http.StatusTooManyRequests {
+		t.Errorf("expected the third identical body to be throttled, got %v", codes)
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/ingest", strings.NewReader(`{"id":2}`)))
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected a different body not to be throttled, got %d", rec.Code)
+	}
+
+	if bodies[0] != `{"id":1}` || bodies[2] != `{"id":2}` {
+		t.Errorf("expected handlers to read the full body, got %v", bodies)
+	}
+}
+
+func TestBodyHashKey_BoundsBufferedBody(t *testing.T) {
+	keyFunc := BodyHashKey(4)
+	body := "aaaa-first"
+
+	r := httptest.NewRequest(http.MethodPost, "/ingest", strings.NewReader(body))
+	key := keyFunc(r)
+
+	if key != keyFunc(httptest.NewRequest(http.MethodPost, "/ingest", strings.NewReader("aaaa-second"))) {
+		t.Error("expected only the first maxBytes to be hashed")
+	}
+
+	restored, _ := io.ReadAll(r.Body)
+	if string(restored) != body {
+		t.Errorf("expected the full body to be restored, got %q", restored)
+	}
+}
+
+func stubRegions(ip net.IP) string {
+	if ip.Equal(net.ParseIP("203.0.113.7")) || ip.Equal(net.ParseIP("203.0.113.8")) {
+		return "xx"
+	}
+
+	return "yy"
+}
+
+func TestRegionKeys(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "203.0.113.7:52100"
+
+	if key := RegionKey(stubRegions)(req); key != "region:xx" {
+		t.Errorf("expected region:xx, got %s", key)
+	}
+
+	if key := RegionIPKey(stubRegions)(req); key != "region:xx:203.0.113.7" {
+		t.Errorf("expected region:xx:203.0.113.7, got %s", key)
+	}
+}
+
+func TestRegionIPKey_FallsBackToRemoteAddr(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "@unix-socket"
+
+	if key := RegionIPKey(stubRegions)(req); key != "region:yy:@unix-socket" {
+		t.Errorf("expected region:yy:@unix-socket, got %s", key)
+	}
+}
+
+func TestRegionKey_CapsRegionTraffic(t *testing.T) {
+	keyed := limiter.NewKeyedLimiter(2, 0, limiter.RealClock{})
+	handler := RateLimit(keyed, RegionKey(stubRegions))(okHandler())
+
+	serve := func(addr string) int {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = addr
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	serve("203.0.113.7:1")
+	serve("203.0.113.8:1")
+
+	if serve("203.0.113.8:2") != http.StatusTooManyRequests {
+		t.Error("expected the region's shared limit to be exhausted")
+	}
+
+	if serve("198.51.100.1:1") != http.StatusOK {
+		t.Error("expected another region to have its own limit")
+	}
+}
@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/schoolboybru/distributed-rate-limiter/limiter"
+)
+
+// Problem is an RFC 9457 Problem Details body for a throttled request.
+// RetryAfter is an extension member holding whole seconds, and is omitted when
+// the limiter has no estimate.
+type Problem struct {
+	Type       string `json:"type"`
+	Title      string `json:"title"`
+	Status     int    `json:"status"`
+	Detail     string `json:"detail"`
+	RetryAfter *int64 `json:"retry_after,omitempty"`
+}
+
+// ProblemDetails returns a DenyHandler that responds with an
+// application/problem+json body. An empty typeURI uses "about:blank", which
+// RFC 9457 defines as a problem described by its status code alone.
+func ProblemDetails(typeURI string) DenyHandler {
+	if typeURI == "" {
+		typeURI = "about:blank"
+	}
+
+	return func(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+		problem := Problem{
+			Type:   typeURI,
+			Title:  http.StatusText(http.StatusTooManyRequests),
+			Status: http.StatusTooManyRequests,
+			Detail: "rate limit exceeded",
+		}
+
+		if retryAfter != limiter.NeverAvailable {
+			seconds := int64(math.Ceil(retryAfter.Seconds()))
+			problem.RetryAfter = &seconds
+			problem.Detail = "rate limit exceeded, retry after " + ceilSeconds(retryAfter) + "s"
+		}
+
+		w.Header().Set("Content-Type", "application/problem+json")
+		w.WriteHeader(http.StatusTooManyRequests)
+		json.NewEncoder(w).Encode(problem)
+	}
+}
@@ -0,0 +1,68 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/schoolboybru/distributed-rate-limiter/limiter"
+)
+
+func TestProblemDetails_DenyBody(t *testing.T) {
+	keyed := limiter.NewKeyedLimiter(1, 0.5, limiter.RealClock{})
+	handler := RateLimit(keyed, PathKey, WithDenyHandler(ProblemDetails("https://example.com/probs/rate-limit")))(okHandler())
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429, got %d", rec.Code)
+	}
+
+	if ct := rec.Header().Get("Content-Type"); ct != "application/problem+json" {
+		t.Errorf("expected application/problem+json, got %q", ct)
+	}
+
+	var body map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("expected a JSON body: %v", err)
+	}
+
+	want := map[string]any{
+		"type":        "https://example.com/probs/rate-limit",
+		"title":       "Too Many Requests",
+		"status":      float64(429),
+		"retry_after": float64(2),
+	}
+	for name, v := range want {
+		if body[name] != v {
+			t.Errorf("expected %s %v, got %v", name, v, body[name])
+		}
+	}
+
+	if detail, _ := body["detail"].(string); detail == "" {
+		t.Error("expected a detail member")
+	}
+}
+
+func TestProblemDetails_NoEstimate(t *testing.T) {
+	handler := RateLimit(allowOnly{}, PathKey, WithDenyHandler(ProblemDetails("")))(okHandler())
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	var body map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("expected a JSON body: %v", err)
+	}
+
+	if body["type"] != "about:blank" {
+		t.Errorf("expected about:blank, got %v", body["type"])
+	}
+
+	if _, ok := body["retry_after"]; ok {
+		t.Error("expected no retry_after without an estimate")
+	}
+}
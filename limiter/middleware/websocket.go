@@ -0,0 +1,112 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+
+	"github.com/schoolboybru/distributed-rate-limiter/limiter"
+)
+
+var ErrFrameLimitExceeded = errors.New("frame rate limit exceeded")
+
+// FrameConn is the subset of a WebSocket connection the frame limiter needs.
+// *websocket.Conn from gorilla/websocket satisfies it directly; other libraries
+// need a small adapter.
+type FrameConn interface {
+	ReadMessage() (messageType int, p []byte, err error)
+	WriteMessage(messageType int, data []byte) error
+	Close() error
+}
+
+type FrameHandler func(messageType int, p []byte) error
+
+type ConnKeyFunc func(conn FrameConn) string
+
+type frameConfig struct {
+	maxViolations   int
+	wait            bool
+	throttleType    int
+	throttleMessage []byte
+}
+
+type FrameOption func(*frameConfig)
+
+// WithMaxViolations closes the connection after n consecutive throttled
+// frames. The default is 10.
+func WithMaxViolations(n int) FrameOption {
+	return func(c *frameConfig) {
+		c.maxViolations = n
+	}
+}
+
+// WithFrameWait blocks on Wait for each frame instead of dropping throttled
+// frames, applying backpressure to the reader.
+func WithFrameWait() FrameOption {
+	return func(c *frameConfig) {
+		c.wait = true
+	}
+}
+
+// WithThrottleMessage sends data to the client whenever a frame is dropped.
+func WithThrottleMessage(messageType int, data []byte) FrameOption {
+	return func(c *frameConfig) {
+		c.throttleType = messageType
+		c.throttleMessage = data
+	}
+}
+
+// ServeFrames reads frames from conn until it errors, passing each frame that
+// the limiter admits to handle. Throttled frames are dropped, and once
+// maxViolations consecutive frames are throttled the connection is closed and
+// ErrFrameLimitExceeded is returned. The connection is also closed when ctx is
+// done or a throttle message can't be written.
+func ServeFrames(ctx context.Context, conn FrameConn, l limiter.Limiter, keyFunc ConnKeyFunc, handle FrameHandler, opts ...FrameOption) error {
+	cfg := &frameConfig{
+		maxViolations: 10,
+	}
+
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	key := keyFunc(conn)
+	violations := 0
+
+	for {
+		if err := ctx.Err(); err != nil {
+			conn.Close()
+			return err
+		}
+
+		messageType, p, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+
+		if cfg.wait {
+			if err := l.Wait(ctx, key, 1); err != nil {
+				conn.Close()
+				return err
+			}
+		} else if !l.Allow(key, 1) {
+			violations++
+			if violations >= cfg.maxViolations {
+				conn.Close()
+				return ErrFrameLimitExceeded
+			}
+
+			if cfg.throttleMessage != nil {
+				if err := conn.WriteMessage(cfg.throttleType, cfg.throttleMessage); err != nil {
+					conn.Close()
+					return err
+				}
+			}
+			continue
+		}
+
+		violations = 0
+		if err := handle(messageType, p); err != nil {
+			return err
+		}
+	}
+}
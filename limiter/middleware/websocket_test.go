@@ -0,0 +1,167 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/schoolboybru/distributed-rate-limiter/limiter"
+)
+
+type MockConn struct {
+	frames   [][]byte
+	writes   [][]byte
+	writeErr error
+	closed   bool
+}
+
+func (c *MockConn) ReadMessage() (int, []byte, error) {
+	if len(c.frames) == 0 {
+		return 0, nil, io.EOF
+	}
+
+	frame := c.frames[0]
+	c.frames = c.frames[1:]
+	return 1, frame, nil
+}
+
+func (c *MockConn) WriteMessage(messageType int, data []byte) error {
+	if c.writeErr != nil {
+		return c.writeErr
+	}
+
+	c.writes = append(c.writes, data)
+	return nil
+}
+
+func (c *MockConn) Close() error {
+	c.closed = true
+	return nil
+}
+
+func connKey(conn FrameConn) string {
+	return "conn-1"
+}
+
+func newMockConn(n int) *MockConn {
+	conn := &MockConn{}
+	for range n {
+		conn.frames = append(conn.frames, []byte("frame"))
+	}
+	return conn
+}
+
+func TestServeFrames_ClosesAfterPersistentThrottling(t *testing.T) {
+	conn := newMockConn(10)
+	keyed := limiter.NewKeyedLimiter(3, 0, limiter.RealClock{})
+	handled := 0
+
+	err := ServeFrames(context.Background(), conn, keyed, connKey, func(int, []byte) error {
+		handled++
+		return nil
+	}, WithMaxViolations(2), WithThrottleMessage(1, []byte("slow down")))
+
+	if err != ErrFrameLimitExceeded {
+		t.Errorf("expected ErrFrameLimitExceeded, got %v", err)
+	}
+
+	if handled != 3 {
+		t.Errorf("expected 3 frames handled, got %d", handled)
+	}
+
+	if len(conn.writes) != 1 {
+		t.Errorf("expected 1 throttle message, got %d", len(conn.writes))
+	}
+
+	if !conn.closed {
+		t.Error("expected connection to be closed")
+	}
+}
+
+func TestServeFrames_HandlesFramesWithinLimit(t *testing.T) {
+	conn := newMockConn(3)
+	keyed := limiter.NewKeyedLimiter(5, 0, limiter.RealClock{})
+	handled := 0
+
+	err := ServeFrames(context.Background(), conn, keyed, connKey, func(int, []byte) error {
+		handled++
+		return nil
+	})
+
+	if err != io.EOF {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+
+	if handled != 3 {
+		t.Errorf("expected 3 frames handled, got %d", handled)
+	}
+
+	if conn.closed {
+		t.Error("expected connection to stay open")
+	}
+}
+
+func TestServeFrames_WaitClosesOnDeadline(t *testing.T) {
+	conn := newMockConn(5)
+	keyed := limiter.NewKeyedLimiter(2, 0.001, limiter.RealClock{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	err := ServeFrames(ctx, conn, keyed, connKey, func(int, []byte) error {
+		return nil
+	}, WithFrameWait())
+
+	if err != context.DeadlineExceeded {
+		t.Errorf("expected DeadlineExceeded, got %v", err)
+	}
+
+	if !conn.closed {
+		t.Error("expected connection to be closed")
+	}
+}
+
+func TestServeFrames_ClosesWhenContextDoneWithoutWait(t *testing.T) {
+	conn := newMockConn(3)
+	keyed := limiter.NewKeyedLimiter(5, 0, limiter.RealClock{})
+	handled := 0
+
+	ctx, cancel := context.WithCancel(context.Background())
+	err := ServeFrames(ctx, conn, keyed, connKey, func(int, []byte) error {
+		handled++
+		cancel()
+		return nil
+	})
+
+	if err != context.Canceled {
+		t.Errorf("expected Canceled, got %v", err)
+	}
+
+	if handled != 1 {
+		t.Errorf("expected 1 frame handled before cancellation, got %d", handled)
+	}
+
+	if !conn.closed {
+		t.Error("expected connection to be closed")
+	}
+}
+
+func TestServeFrames_ClosesWhenThrottleMessageFails(t *testing.T) {
+	conn := newMockConn(3)
+	conn.writeErr = errors.New("broken pipe")
+	keyed := limiter.NewKeyedLimiter(1, 0, limiter.RealClock{})
+
+	err := ServeFrames(context.Background(), conn, keyed, connKey, func(int, []byte) error {
+		return nil
+	}, WithThrottleMessage(1, []byte("slow down")))
+
+	if err != conn.writeErr {
+		t.Errorf("expected the write error, got %v", err)
+	}
+
+	if !conn.closed {
+		t.Error("expected connection to be closed")
+	}
+}
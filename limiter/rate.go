@@ -0,0 +1,43 @@
+package limiter
+
+import "time"
+
+// Rate is a limit expressed as a count per period, such as 100 requests per
+// minute, for constructors that would otherwise take a refill rate in tokens
+// per second.
+type Rate struct {
+	Limit  int
+	Period time.Duration
+}
+
+func PerSecond(limit int) Rate {
+	return Rate{Limit: limit, Period: time.Second}
+}
+
+func PerMinute(limit int) Rate {
+	return Rate{Limit: limit, Period: time.Minute}
+}
+
+func PerHour(limit int) Rate {
+	return Rate{Limit: limit, Period: time.Hour}
+}
+
+// TokensPerSecond returns the rate as a refill rate. A zero or negative period
+// refills nothing.
+func (r Rate) TokensPerSecond() float64 {
+	if r.Period <= 0 {
+		return 0
+	}
+
+	return float64(r.Limit) / r.Period.Seconds()
+}
+
+// capacity returns the bucket capacity for burst, which defaults to the
+// rate's limit when zero or negative.
+func (r Rate) capacity(burst int) float64 {
+	if burst <= 0 {
+		return float64(r.Limit)
+	}
+
+	return float64(burst)
+}
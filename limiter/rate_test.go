@@ -0,0 +1,63 @@
+package limiter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRate_TokensPerSecond(t *testing.T) {
+	tests := []struct {
+		rate Rate
+		want float64
+	}{
+		{PerSecond(5), 5},
+		{PerMinute(120), 2},
+		{PerHour(3600), 1},
+		{Rate{Limit: 10, Period: 0}, 0},
+	}
+
+	for _, tt := range tests {
+		if got := tt.rate.TokensPerSecond(); got != tt.want {
+			t.Errorf("expected %v to refill %v tokens/s, got %v", tt.rate, tt.want, got)
+		}
+	}
+}
+
+func TestNewTokenBucketPerMinute(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	bucket := NewTokenBucketPerMinute(60, 10, clock)
+
+	if !bucket.Allow(10) {
+		t.Fatal("expected the full burst to be allowed")
+	}
+	if bucket.Allow(1) {
+		t.Fatal("expected deny once the burst is spent")
+	}
+
+	clock.Advance(time.Second)
+	if !bucket.Allow(1) {
+		t.Error("expected one token after a second at 60/min")
+	}
+}
+
+func TestNewKeyedLimiterRate_BurstDefaultsToLimit(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	keyedLimiter := NewKeyedLimiterRate(PerHour(100), 0, clock)
+
+	if !keyedLimiter.Allow("user1", 100) {
+		t.Error("expected a zero burst to default to the limit")
+	}
+	if keyedLimiter.Allow("user1", 1) {
+		t.Error("expected deny once the limit is spent")
+	}
+}
+
+func TestNewRedisLimiterRate(t *testing.T) {
+	_, client := setupMiniRedis(t)
+	limiter := NewRedisLimiterRate(client, PerMinute(30), 5, "ratelimit:")
+
+	capacity, refillRate := limiter.limits()
+	if capacity != 5 || refillRate != 0.5 {
+		t.Errorf("expected capacity 5 and refill 0.5/s, got %v and %v", capacity, refillRate)
+	}
+}
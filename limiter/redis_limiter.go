@@ -4,15 +4,39 @@ import (
 	"context"
 	_ "embed"
 	"errors"
+	"log/slog"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/redis/go-redis/v9"
 )
 
+// tokenBucketScript takes "now" from Redis TIME and nothing time-related from
+// the client, so instances with skewed clocks refill shared buckets alike.
+//
 //go:embed scripts/token_bucket.lua
 var tokenBucketScript string
 
+// tokenBucketLibrary registers the token bucket script as a Redis Function.
+// Bump the library version whenever the script changes.
+const (
+	tokenBucketLibraryName = "ratelimiter_v8"
+	tokenBucketFunction    = "ratelimiter_v8_token_bucket"
+)
+
+var tokenBucketLibrary = "#!lua name=" + tokenBucketLibraryName + "\n" +
+	"redis.register_function('" + tokenBucketFunction + "', function(KEYS, ARGV)\n" +
+	tokenBucketScript +
+	"\nend)\n"
+
 var ErrCircuitOpen = errors.New("circuit breaker is open")
+var ErrWaitAttemptsExceeded = errors.New("wait attempts exceeded")
+var ErrKeyTokenMismatch = errors.New("keys and tokens must have the same length")
+var ErrLimiterClosed = errors.New("limiter is closed")
 
 type FailureMode int
 
@@ -22,16 +46,88 @@ const (
 	FailDegrade
 )
 
+// ErrorClass determines how a Redis error is handled. Transient errors count
+// towards the circuit breaker and fail over per the failure mode. Fatal errors
+// fail over without counting towards the breaker, since they point at the
+// request rather than Redis health. Ignored errors do neither and the request
+// is denied.
+type ErrorClass int
+
+const (
+	Transient ErrorClass = iota
+	Fatal
+	Ignore
+)
+
+// Class groups requests that should fail over the same way when Redis is
+// unavailable, e.g. paid and free tier traffic. See AllowClassed.
+type Class string
+
 type RedisLimiter struct {
-	client         *redis.Client
-	script         *redis.Script
-	capacity       float64
-	refillRate     float64
-	keyPrefix      string
-	metrics        Metrics
-	failureMode    FailureMode
-	localLimiter   *KeyedLimiter
-	circuitBreaker *CircuitBreaker
+	client       redis.Cmdable
+	script       *redis.Script
+	capacity     float64
+	refillRate   float64
+	keyPrefix    string
+	keyNamespace func(key string) string
+	metrics      Metrics
+	// hasMetrics records whether WithMetrics was given, since wrapping for
+	// sampling hides NoopMetrics.
+	hasMetrics       bool
+	logger           Logger
+	failureMode      FailureMode
+	classModes       map[Class]FailureMode
+	breakerHook      BreakerHook
+	tracer           Tracer
+	localLimiter     *KeyedLimiter
+	clock            Clock
+	circuitBreaker   *CircuitBreaker
+	sharedBreaker    bool
+	syntheticProbe   bool
+	shadow           bool
+	sampleRate       float64
+	denyDetail       bool
+	maxWaitAttempts  int
+	classifyError    func(error) ErrorClass
+	costPipeline     *CostPipeline
+	useFunctions     bool
+	degradeScale     float64
+	decisionLog      *slog.Logger
+	decisionRate     float64
+	logAllDenies     bool
+	decisionSample   func() float64
+	waitJitter       float64
+	waitPollInterval time.Duration
+	minWaitPoll      time.Duration
+	maxWaitPoll      time.Duration
+	keyTTL           time.Duration
+	jitterSample     func() float64
+	// limitsMu guards capacity, refillRate and limitsChanged, which is closed
+	// and replaced by SetLimits to wake waiters.
+	limitsMu      sync.RWMutex
+	limitsChanged chan struct{}
+	// done is closed by Close.
+	done      chan struct{}
+	closeOnce sync.Once
+	// Decision counts by source, reported by FailoverStats.
+	redisServed  atomic.Int64
+	failedOpen   atomic.Int64
+	failedClosed atomic.Int64
+	degraded     atomic.Int64
+	// Decision counts by outcome, reported by PublishExpvar.
+	allows atomic.Int64
+	denies atomic.Int64
+	errors atomic.Int64
+}
+
+// FailoverStats counts a limiter's decisions by what made them since it was
+// created: Redis, or one of the failure modes. Denials for errors classified
+// as Ignore count as FailedClosed.
+type FailoverStats struct {
+	RedisServed  int64
+	FailedOpen   int64
+	FailedClosed int64
+	Degraded     int64
 }
 
 type Option func(*RedisLimiter)
@@ -42,112 +138,1326 @@ func WithMetrics(m Metrics) Option {
 	}
 }
 
+// WithLogger sends circuit breaker transitions, Redis errors and failure mode
+// decisions to l. By default nothing is logged.
+func WithLogger(l Logger) Option {
+	return func(r *RedisLimiter) {
+		r.logger = l
+	}
+}
+
+// WithMetricsSampling forwards only the given fraction of allow and latency
+// metrics. Denies and errors are always recorded. See SampledMetrics.
+func WithMetricsSampling(rate float64) Option {
+	return func(r *RedisLimiter) {
+		r.sampleRate = rate
+	}
+}
+
+// WithDenyDetailMetrics records every deny with its key while allows are
+// sampled at allowRate and aggregated without one. See NewDenyDetailMetrics.
+func WithDenyDetailMetrics(allowRate float64) Option {
+	return func(r *RedisLimiter) {
+		r.sampleRate = allowRate
+		r.denyDetail = true
+	}
+}
+
+// WithMaxWaitAttempts caps how many times Wait checks Redis before giving up
+// with ErrWaitAttemptsExceeded, regardless of the context deadline.
+func WithMaxWaitAttempts(n int) Option {
+	return func(r *RedisLimiter) {
+		r.maxWaitAttempts = n
+	}
+}
+
+func WithErrorClassifier(classify func(error) ErrorClass) Option {
+	return func(r *RedisLimiter) {
+		r.classifyError = classify
+	}
+}
+
+// WithCostPipeline computes each request's cost with p before it is checked
+// against the bucket.
+func WithCostPipeline(p *CostPipeline) Option {
+	return func(r *RedisLimiter) {
+		r.costPipeline = p
+	}
+}
+
+// WithRedisFunctions loads the token bucket as a Redis Function library at
+// construction and calls it with FCALL. If the server doesn't support
+// functions (Redis < 7) the limiter falls back to EVALSHA.
+func WithRedisFunctions() Option {
+	return func(r *RedisLimiter) {
+		r.useFunctions = true
+	}
+}
+
+// WithDegradeScale provisions the FailDegrade local limiter at factor times
+// the configured capacity and rate. Since each node degrades independently,
+// 1/N for an N node fleet approximates the global limit during an outage.
+func WithDegradeScale(factor float64) Option {
+	return func(r *RedisLimiter) {
+		r.degradeScale = factor
+	}
+}
+
 func WithFailureMode(mode FailureMode) Option {
 	return func(r *RedisLimiter) {
 		r.failureMode = mode
 	}
 }
 
-func WithCircuitBreaker(threshold int, timeout time.Duration) Option {
+// WithClassFailureMode sets the failure mode AllowClassed uses for class when
+// Redis is unavailable. Classes without one use the limiter's failure mode.
+func WithClassFailureMode(class Class, mode FailureMode) Option {
+	return func(r *RedisLimiter) {
+		if r.classModes == nil {
+			r.classModes = make(map[Class]FailureMode)
+		}
+		r.classModes[class] = mode
+	}
+}
+
+// BreakerHook is called for each request affected by a circuit breaker that
+// isn't closed, with the breaker's state and whether the request failed over
+// instead of reaching Redis. ctx is the request's context, so the hook can
+// annotate the active span.
+type BreakerHook func(ctx context.Context, key string, state CircuitState, failedOver bool)
+
+func WithBreakerHook(h BreakerHook) Option {
+	return func(r *RedisLimiter) {
+		r.breakerHook = h
+	}
+}
+
+// WithDecisionLog writes a sampled record of each decision to handler: the
+// key, cost, outcome, remaining tokens, why it was decided that way and how
+// long it took. Only sampleRate of decisions are logged; see
+// WithDecisionLogAllDenies to keep every deny.
+func WithDecisionLog(handler slog.Handler, sampleRate float64) Option {
+	return func(r *RedisLimiter) {
+		r.decisionLog = slog.New(handler)
+		r.decisionRate = sampleRate
+	}
+}
+
+// WithDecisionLogAllDenies logs every deny regardless of the decision log's
+// sample rate.
+func WithDecisionLogAllDenies() Option {
 	return func(r *RedisLimiter) {
-		r.circuitBreaker = NewCircuitBreaker(threshold, timeout, RealClock{})
+		r.logAllDenies = true
 	}
 }
 
-func NewRedisLimiter(client *redis.Client, capacity float64, refillRate float64, keyPrefix string, opts ...Option) *RedisLimiter {
+func WithCircuitBreaker(threshold int, timeout time.Duration, opts ...BreakerOption) Option {
+	return func(r *RedisLimiter) {
+		r.circuitBreaker = NewCircuitBreaker(threshold, timeout, RealClock{}, opts...)
+		r.sharedBreaker = false
+	}
+}
+
+// WithSharedCircuitBreaker uses cb, which other limiters on the same Redis may
+// also use, so they all fail fast together when it goes down. The breaker's
+// threshold then counts failures across every limiter sharing it. A shared
+// breaker is left as configured: the limiter's CircuitMetrics, Logger and
+// WithSyntheticProbe aren't attached to it, so transitions should be observed
+// with WithStateChangeHook when cb is created.
+func WithSharedCircuitBreaker(cb *CircuitBreaker) Option {
+	return func(r *RedisLimiter) {
+		r.circuitBreaker = cb
+		r.sharedBreaker = true
+	}
+}
+
+// WithKeyNamespaceFunc computes each bucket's full Redis key from the request
+// key with f, in place of the fixed key prefix, so one limiter can serve keys
+// under per-tenant namespaces such as "tenant123:ratelimit:". f must be
+// deterministic; each call still touches the single key it returns.
+func WithKeyNamespaceFunc(f func(key string) string) Option {
+	return func(r *RedisLimiter) {
+		r.keyNamespace = f
+	}
+}
+
+// WithKeyTTL keeps each bucket key in Redis for at least d after its last
+// write. Keys always live until their bucket would have refilled to full,
+// after which a missing key and a full bucket behave the same, so d only
+// matters when it is longer than the refill-to-full time; a shorter TTL would
+// reset limits early. With a zero refill rate keys never refill and only
+// expire if d is set, which resets them.
+func WithKeyTTL(d time.Duration) Option {
+	return func(r *RedisLimiter) {
+		r.keyTTL = d
+	}
+}
+
+// WithWaitJitter randomizes each sleep in Wait by up to ±fraction, so many
+// goroutines waiting on the same key don't all retry at once. A sleep is still
+// never longer than the time left before the context deadline.
+func WithWaitJitter(fraction float64) Option {
+	return func(r *RedisLimiter) {
+		r.waitJitter = fraction
+	}
+}
+
+// WithWaitPollInterval sets how long Wait sleeps between checks when it has
+// no retry duration to go on, such as while failing over without a local
+// limiter. It defaults to 20ms.
+func WithWaitPollInterval(d time.Duration) Option {
+	return func(r *RedisLimiter) {
+		r.waitPollInterval = d
+	}
+}
+
+// WithWaitPollBounds clamps each sleep in Wait, computed or not, to between
+// min and max, e.g. a min to stop low retry durations from hammering Redis or
+// a max to recheck promptly after limits change elsewhere. A zero bound is
+// ignored. The context deadline still cuts a sleep short.
+func WithWaitPollBounds(min, max time.Duration) Option {
+	return func(r *RedisLimiter) {
+		r.minWaitPoll = min
+		r.maxWaitPoll = max
+	}
+}
+
+// WithSyntheticProbe keeps real requests failing over while the circuit
+// breaker is half-open. Recovery is decided solely by calls to Probe.
+func WithSyntheticProbe() Option {
+	return func(r *RedisLimiter) {
+		r.syntheticProbe = true
+	}
+}
+
+// WithClock reads time for latencies, waits, clock drift and the limiter's own
+// circuit breaker and FailDegrade buckets from c instead of the system clock,
+// e.g. a MockClock in tests. Buckets in Redis still refill by Redis server
+// time, and Wait still sleeps in real time.
+func WithClock(c Clock) Option {
+	return func(r *RedisLimiter) {
+		r.clock = c
+	}
+}
+
+// WithShadowMode runs the limiter as a dry run: every decision is made and
+// counts against the buckets as usual, but requests that would be denied are
+// allowed. Those requests are reported to ShadowMetrics.OnShadowDeny, if the
+// Metrics implements it, or to OnDeny otherwise, which makes it possible to
+// size a new limit from real traffic before enforcing it.
+func WithShadowMode(enabled bool) Option {
+	return func(r *RedisLimiter) {
+		r.shadow = enabled
+	}
+}
+
+// NewRedisLimiter returns a limiter backed by client, which may be any go-redis
+// client: *redis.Client, *redis.ClusterClient, a Sentinel failover client or
+// a redis.UniversalClient. The token bucket script touches a single key, so
+// EVALSHA is routed to the node owning keyPrefix+key; wrap part of the key in
+// a {hash tag} to co-locate related buckets on one slot.
+func NewRedisLimiter(client redis.Cmdable, capacity float64, refillRate float64, keyPrefix string, opts ...Option) *RedisLimiter {
 	r := &RedisLimiter{
-		client:      client,
-		script:      redis.NewScript(tokenBucketScript),
-		capacity:    capacity,
-		refillRate:  refillRate,
-		keyPrefix:   keyPrefix,
-		metrics:     NoopMetrics{},
-		failureMode: FailOpen,
+		client:           client,
+		script:           redis.NewScript(tokenBucketScript),
+		capacity:         capacity,
+		refillRate:       refillRate,
+		keyPrefix:        keyPrefix,
+		metrics:          NoopMetrics{},
+		logger:           NoopLogger{},
+		clock:            RealClock{},
+		failureMode:      FailOpen,
+		sampleRate:       1,
+		degradeScale:     1,
+		limitsChanged:    make(chan struct{}),
+		done:             make(chan struct{}),
+		decisionSample:   rand.Float64,
+		jitterSample:     rand.Float64,
+		waitPollInterval: defaultWaitPollInterval,
+		classifyError: func(error) ErrorClass {
+			return Transient
+		},
 	}
 
 	for _, opt := range opts {
 		opt(r)
 	}
 
-	if r.failureMode == FailDegrade {
-		r.localLimiter = NewKeyedLimiter(capacity, refillRate, RealClock{})
+	if r.useFunctions {
+		err := r.client.FunctionLoadReplace(context.Background(), tokenBucketLibrary).Err()
+		r.useFunctions = err == nil
+	}
+
+	ownBreaker := r.circuitBreaker != nil && !r.sharedBreaker
+	if ownBreaker {
+		r.circuitBreaker.clock = r.clock
+	}
+
+	if m, ok := r.metrics.(CircuitMetrics); ok && ownBreaker {
+		r.circuitBreaker.addStateChangeHook(m.OnCircuitStateChange)
+	}
+
+	if _, noop := r.logger.(NoopLogger); !noop && ownBreaker {
+		r.circuitBreaker.addStateChangeHook(func(from, to CircuitState) {
+			r.logger.Warnf("ratelimit: circuit breaker %s -> %s", from, to)
+		})
+	}
+
+	_, noop := r.metrics.(NoopMetrics)
+	r.hasMetrics = !noop
+	r.metrics = r.sampledMetrics()
+
+	if ownBreaker && r.syntheticProbe {
+		r.circuitBreaker.probeOnly = true
+	}
+
+	degrades := r.failureMode == FailDegrade
+	for _, mode := range r.classModes {
+		degrades = degrades || mode == FailDegrade
+	}
+
+	if degrades {
+		r.localLimiter = NewKeyedLimiter(capacity*r.degradeScale, refillRate*r.degradeScale, r.clock)
 	}
 
 	return r
 }
 
+// NewRedisLimiterRate returns a RedisLimiter whose buckets refill at rate and
+// hold up to burst tokens, or rate.Limit tokens if burst is zero.
+func NewRedisLimiterRate(client redis.Cmdable, rate Rate, burst int, keyPrefix string, opts ...Option) *RedisLimiter {
+	return NewRedisLimiter(client, rate.capacity(burst), rate.TokensPerSecond(), keyPrefix, opts...)
+}
+
 func (r *RedisLimiter) Allow(key string, tokens int) bool {
-	if r.circuitBreaker != nil && !r.circuitBreaker.Allow() {
-		r.metrics.OnError(key, ErrCircuitOpen)
-		return r.handleFailure(key, tokens)
+	if tokens < 0 {
+		return false
+	}
+
+	return r.check(context.Background(), "Allow", key, float64(tokens), false, r.failureMode, false).allowed
+}
+
+// sampledMetrics wraps the configured metrics as set by WithMetricsSampling or
+// WithDenyDetailMetrics.
+func (r *RedisLimiter) sampledMetrics() Metrics {
+	if r.denyDetail {
+		return NewDenyDetailMetrics(r.metrics, r.sampleRate)
+	} else if r.sampleRate < 1 {
+		return NewSampledMetrics(r.metrics, r.sampleRate)
+	}
+
+	return r.metrics
+}
+
+// AllowE behaves like Allow and also returns the Redis or circuit breaker
+// error behind a failover, or ErrNegativeTokens. The failure mode still
+// decides the result, so with FailOpen or FailDegrade an error may come with
+// an allowed request.
+func (r *RedisLimiter) AllowE(key string, tokens int) (bool, error) {
+	if tokens < 0 {
+		return false, ErrNegativeTokens
+	}
+
+	d := r.check(context.Background(), "AllowE", key, float64(tokens), false, r.failureMode, false)
+
+	return d.allowed, d.err
+}
+
+// AllowCtx behaves like Allow but binds the Redis call to ctx, so a cancelled
+// or expired caller doesn't make a round trip and the call can't outlive the
+// caller's deadline. The error is the Redis, circuit breaker or context error
+// behind the decision; with FailOpen or FailDegrade the request may still be
+// allowed alongside it. A context error never trips the circuit breaker or
+// falls back to the failure mode, and always denies.
+func (r *RedisLimiter) AllowCtx(ctx context.Context, key string, tokens int) (bool, error) {
+	if tokens < 0 {
+		return false, ErrNegativeTokens
 	}
 
-	start := time.Now()
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	d := r.check(ctx, "AllowCtx", key, float64(tokens), false, r.failureMode, true)
+
+	return d.allowed, d.err
+}
 
-	result, err := r.script.Run(context.Background(), r.client, []string{r.keyPrefix + key}, tokens, r.capacity, r.refillRate).Result()
+// AllowPartial takes as many whole tokens as the key's bucket has, up to
+// requested, and returns how many it took, for work that can proceed in part,
+// such as a download metered in bytes. A grant of 0 means the key is fully
+// throttled, and nothing is ever granted beyond the capacity. The count is
+// used as given rather than passed through the cost pipeline. If Redis can't
+// be used, FailOpen grants up to the capacity, FailClosed grants nothing and
+// FailDegrade grants from the local bucket. In shadow mode a grant of 0 is
+// reported as a shadow deny and the request is granted up to the capacity.
+func (r *RedisLimiter) AllowPartial(key string, requested int) int {
+	if requested <= 0 || r.closed() {
+		return 0
+	}
+
+	if !r.breakerAllows(context.Background(), key) {
+		r.metrics.OnError(key, ErrCircuitOpen)
+		r.errors.Add(1)
+		return r.partialFailover(key, requested)
+	}
 
-	r.metrics.OnLatency(key, time.Since(start))
+	start := r.clock.Now()
+	result, err := r.runTokenBucket(context.Background(), key, float64(requested), "partial")
+	r.metrics.OnLatency(key, r.clock.Now().Sub(start))
 
 	if err != nil {
-		if r.circuitBreaker != nil {
-			r.circuitBreaker.RecordFailure()
-		}
+		r.logger.Errorf("ratelimit: redis error for key %q: %v", key, err)
+		class := r.classifyError(err)
+		r.recordBreakerError(class)
 		r.metrics.OnError(key, err)
-		return r.handleFailure(key, tokens)
+		r.errors.Add(1)
+		if class == Ignore {
+			r.failedClosed.Add(1)
+			return r.grant(key, requested, 0)
+		}
+		return r.partialFailover(key, requested)
 	}
 
 	if r.circuitBreaker != nil {
 		r.circuitBreaker.RecordSuccess()
 	}
+	r.redisServed.Add(1)
 
-	resSlice := result.([]interface{})
-	allowed := resSlice[0].(int64) == 1
+	return r.grant(key, requested, int(result.([]interface{})[0].(int64)))
+}
+
+// partialFailover grants a partial request by the failure mode.
+func (r *RedisLimiter) partialFailover(key string, requested int) int {
+	switch r.failureMode {
+	case FailClosed:
+		r.failedClosed.Add(1)
+		return r.grant(key, requested, 0)
+	case FailDegrade:
+		r.degraded.Add(1)
+		return r.grant(key, requested, r.localLimiter.AllowPartial(key, requested))
+	default:
+		r.failedOpen.Add(1)
+		capacity, _ := r.limits()
+		return r.grant(key, requested, min(requested, int(capacity)))
+	}
+}
+
+// grant records a partial request's outcome and returns what the caller gets.
+func (r *RedisLimiter) grant(key string, requested int, granted int) int {
+	if granted > 0 {
+		recordAllow(r.metrics, key, float64(granted))
+		r.allows.Add(1)
+		return granted
+	}
+
+	recordDeny(r.metrics, key, r.shadow)
+	if !r.shadow {
+		r.denies.Add(1)
+		return 0
+	}
+
+	r.allows.Add(1)
+	capacity, _ := r.limits()
+	return min(requested, int(capacity))
+}
+
+// AllowFloat behaves like Allow for a fractional cost, such as 0.5 for a cheap
+// read, which Redis applies to the shared bucket as is. The cost is treated as
+// the request's final weight, so it skips the cost pipeline.
+func (r *RedisLimiter) AllowFloat(key string, tokens float64) bool {
+	if invalidCost(tokens) {
+		return false
+	}
+
+	return r.check(context.Background(), "AllowFloat", key, tokens, true, r.failureMode, false).allowed
+}
+
+// AllowClassed behaves like Allow but, if Redis is unavailable, fails over
+// using the mode configured for class with WithClassFailureMode.
+func (r *RedisLimiter) AllowClassed(key string, tokens int, class Class) bool {
+	if tokens < 0 {
+		return false
+	}
+
+	mode, ok := r.classModes[class]
+	if !ok {
+		mode = r.failureMode
+	}
+
+	return r.check(context.Background(), "AllowClassed", key, float64(tokens), false, mode, false).allowed
+}
+
+// check costs and decides a single request, inside a span if a tracer is set.
+// A weighted request already carries its final, possibly fractional, cost and
+// skips the cost pipeline.
+func (r *RedisLimiter) check(ctx context.Context, operation string, key string, tokens float64, weighted bool, mode FailureMode, bound bool) decision {
+	if r.tracer == nil {
+		return r.decide(ctx, key, r.cost(ctx, key, tokens, weighted), mode, bound)
+	}
+
+	ctx, span := r.tracer.Start(ctx, operation, key)
+	tokens = r.cost(ctx, key, tokens, weighted)
+	d := r.decide(ctx, key, tokens, mode, bound)
+	span.End(d.allowed, tokens, d.latency, d.err)
+
+	return d
+}
+
+// decision is the outcome of a single token bucket check.
+type decision struct {
+	allowed bool
+	// failedOver is set when the failure mode decided instead of Redis.
+	failedOver bool
+	retryAfter time.Duration
+	// remaining, resetAfter and limit describe the bucket after a Redis
+	// decision.
+	remaining  float64
+	resetAfter time.Duration
+	limit      float64
+	reason     string
+	// latency is the Redis round trip, if Redis was called.
+	latency time.Duration
+	// err is the Redis or circuit breaker error that forced a failover.
+	err error
+}
+
+// Decision reasons recorded by the decision log.
+const (
+	reasonBucket       = "bucket"
+	reasonCircuitOpen  = "circuit_open"
+	reasonRedisError   = "redis_error"
+	reasonIgnoredError = "ignored_error"
+	reasonCancelled    = "cancelled"
+	reasonClosed       = "closed"
+)
+
+// decide runs the token bucket for key, falling back to mode if Redis can't be
+// used. ctx is handed to the breaker hook; the Redis call itself is only bound
+// to it if bound is set.
+func (r *RedisLimiter) decide(ctx context.Context, key string, tokens float64, mode FailureMode, bound bool) decision {
+	if r.closed() {
+		return decision{reason: reasonClosed, retryAfter: NeverAvailable, err: ErrLimiterClosed}
+	}
+
+	if r.decisionLog == nil {
+		return r.enforce(r.evaluate(ctx, key, tokens, mode, bound))
+	}
+
+	start := r.clock.Now()
+	d := r.evaluate(ctx, key, tokens, mode, bound)
+	r.logDecision(ctx, key, tokens, d, r.clock.Now().Sub(start))
+
+	return r.enforce(d)
+}
+
+// enforce returns d as the caller sees it, and counts it: in shadow mode, a
+// request denied by its bucket or the failure mode is allowed. Cancelled
+// requests are still denied, since the caller is gone.
+func (r *RedisLimiter) enforce(d decision) decision {
+	if r.shadow && !d.allowed && d.reason != reasonCancelled && d.reason != reasonClosed {
+		d.allowed = true
+		d.retryAfter = 0
+	}
 
-	if allowed {
-		r.metrics.OnAllow(key)
+	if d.allowed {
+		r.allows.Add(1)
 	} else {
+		r.denies.Add(1)
+	}
+	if d.err != nil {
+		r.errors.Add(1)
+	}
+
+	return d
+}
+
+func (r *RedisLimiter) evaluate(ctx context.Context, key string, tokens float64, mode FailureMode, bound bool) decision {
+	if !r.breakerAllows(ctx, key) {
+		return r.circuitOpen(key, tokens, mode)
+	}
+
+	redisCtx := context.Background()
+	if bound {
+		redisCtx = ctx
+	}
+
+	start := r.clock.Now()
+
+	result, err := r.runTokenBucket(redisCtx, key, tokens, "consume")
+	latency := r.clock.Now().Sub(start)
+
+	// The caller giving up says nothing about Redis's health.
+	if err != nil && bound && ctx.Err() != nil {
+		if r.circuitBreaker != nil {
+			r.circuitBreaker.releaseTrial()
+		}
+		r.metrics.OnLatency(key, latency)
 		r.metrics.OnDeny(key)
+		return decision{reason: reasonCancelled, latency: latency, err: ctx.Err()}
+	}
+
+	return r.conclude(key, tokens, mode, result, err, latency, true)
+}
+
+// breakerAllows reports whether the circuit breaker lets requests for keys
+// through to Redis, calling the breaker hook for each key if it isn't closed.
+func (r *RedisLimiter) breakerAllows(ctx context.Context, keys ...string) bool {
+	if r.circuitBreaker == nil {
+		return true
+	}
+
+	allowed := r.circuitBreaker.Allow()
+	if r.breakerHook != nil {
+		if state := r.circuitBreaker.State(); state != CircuitClosed {
+			for _, key := range keys {
+				r.breakerHook(ctx, key, state, !allowed)
+			}
+		}
 	}
 
 	return allowed
+}
+
+func (r *RedisLimiter) circuitOpen(key string, tokens float64, mode FailureMode) decision {
+	r.metrics.OnError(key, ErrCircuitOpen)
+	d := r.handleFailure(key, tokens, mode)
+	d.reason = reasonCircuitOpen
+	d.err = ErrCircuitOpen
+	r.logger.Debugf("ratelimit: circuit open, failed over for key %q: allowed=%t", key, d.allowed)
+	return d
+}
+
+// recordBreakerError reports a Redis error of class to the circuit breaker:
+// transient errors count as failures, while any other error only frees the
+// half-open trial slot the request may hold.
+func (r *RedisLimiter) recordBreakerError(class ErrorClass) {
+	if r.circuitBreaker == nil {
+		return
+	}
+
+	if class == Transient {
+		r.circuitBreaker.RecordFailure()
+	} else {
+		r.circuitBreaker.releaseTrial()
+	}
+}
+
+// conclude turns the token bucket script's result or error into a decision,
+// recording metrics and, if breaker is set, the breaker outcome. AllowMulti
+// records one breaker outcome per pipeline instead.
+func (r *RedisLimiter) conclude(key string, tokens float64, mode FailureMode, result interface{}, err error, latency time.Duration, breaker bool) decision {
+	r.metrics.OnLatency(key, latency)
+
+	if err != nil {
+		r.logger.Errorf("ratelimit: redis error for key %q: %v", key, err)
+		class := r.classifyError(err)
+		if breaker {
+			r.recordBreakerError(class)
+		}
+		r.metrics.OnError(key, err)
+		if class == Ignore {
+			r.failedClosed.Add(1)
+			recordDeny(r.metrics, key, r.shadow)
+			return decision{failedOver: true, reason: reasonIgnoredError, latency: latency, err: err}
+		}
+		d := r.handleFailure(key, tokens, mode)
+		d.reason = reasonRedisError
+		d.latency = latency
+		d.err = err
+		r.logger.Debugf("ratelimit: redis error, failed over for key %q: allowed=%t", key, d.allowed)
+		return d
+	}
+
+	if breaker && r.circuitBreaker != nil {
+		r.circuitBreaker.RecordSuccess()
+	}
+	r.redisServed.Add(1)
+
+	resSlice := result.([]interface{})
+	remaining, _ := strconv.ParseFloat(resSlice[1].(string), 64)
+	limit, _ := strconv.ParseFloat(resSlice[4].(string), 64)
+	d := decision{
+		allowed:    resSlice[0].(int64) == 1,
+		retryAfter: parseRetryAfter(resSlice[2].(string)),
+		remaining:  remaining,
+		resetAfter: parseRetryAfter(resSlice[3].(string)),
+		limit:      limit,
+		reason:     reasonBucket,
+		latency:    latency,
+	}
+
+	if d.allowed {
+		recordAllow(r.metrics, key, tokens)
+	} else {
+		recordDeny(r.metrics, key, r.shadow)
+	}
 
+	return d
+}
+
+// AllowMulti checks several independent limits, such as per-user, per-IP and
+// per-tenant, in one pipelined round trip, returning each key's result in
+// order. There are no all-or-nothing semantics: keys that are allowed consume
+// tokens even if others are denied. If Redis fails, each key falls back to the
+// failure mode and the first error is returned alongside the results.
+func (r *RedisLimiter) AllowMulti(keys []string, tokens []int) ([]bool, error) {
+	if len(keys) != len(tokens) {
+		return nil, ErrKeyTokenMismatch
+	}
+
+	ctx := context.Background()
+	results := make([]bool, len(keys))
+	if r.closed() {
+		return results, ErrLimiterClosed
+	}
+
+	var batchKeys []string
+	var batchTokens []float64
+	var batchIndex []int
+	for i, key := range keys {
+		if tokens[i] < 0 {
+			continue
+		}
+		batchKeys = append(batchKeys, key)
+		batchTokens = append(batchTokens, r.cost(ctx, key, float64(tokens[i]), false))
+		batchIndex = append(batchIndex, i)
+	}
+
+	if len(batchKeys) == 0 {
+		return results, nil
+	}
+
+	if !r.breakerAllows(ctx, batchKeys...) {
+		for j, key := range batchKeys {
+			results[batchIndex[j]] = r.enforce(r.circuitOpen(key, batchTokens[j], r.failureMode)).allowed
+		}
+		return results, ErrCircuitOpen
+	}
+
+	start := r.clock.Now()
+	cmds := r.runTokenBucketPipeline(ctx, batchKeys, batchTokens)
+	latency := r.clock.Now().Sub(start)
+
+	if err := r.pipelineOutcome(cmds); err != nil {
+		r.recordBreakerError(r.classifyError(err))
+	} else if r.circuitBreaker != nil {
+		r.circuitBreaker.RecordSuccess()
+	}
+
+	var firstErr error
+	for j, cmd := range cmds {
+		result, err := cmd.Result()
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		results[batchIndex[j]] = r.enforce(r.conclude(batchKeys[j], batchTokens[j], r.failureMode, result, err, latency, false)).allowed
+	}
+
+	return results, firstErr
+}
+
+// pipelineOutcome reduces a pipeline to the one outcome the breaker records
+// for its round trip: a transient error if any command hit one, success if
+// any command succeeded, and otherwise the first error.
+func (r *RedisLimiter) pipelineOutcome(cmds []*redis.Cmd) error {
+	var firstErr error
+	succeeded := false
+	for _, cmd := range cmds {
+		err := cmd.Err()
+		switch {
+		case err == nil:
+			succeeded = true
+		case r.classifyError(err) == Transient:
+			return err
+		case firstErr == nil:
+			firstErr = err
+		}
+	}
+
+	if succeeded {
+		return nil
+	}
+	return firstErr
+}
+
+// Check behaves like AllowE and returns the whole outcome from the one script
+// call, e.g. to set rate limit headers without further round trips. If the
+// failure mode decided, only Allowed, Limit and, under FailDegrade,
+// RetryAfter are known, and the error says why.
+func (r *RedisLimiter) Check(key string, tokens int) (Result, error) {
+	if tokens < 0 {
+		return Result{RetryAfter: NeverAvailable}, ErrNegativeTokens
+	}
+
+	d := r.check(context.Background(), "Check", key, float64(tokens), false, r.failureMode, false)
+	res := Result{
+		Allowed:    d.allowed,
+		Remaining:  d.remaining,
+		Limit:      d.limit,
+		ResetAfter: d.resetAfter,
+		RetryAfter: d.retryAfter,
+	}
+	if d.failedOver {
+		res.Limit, _ = r.limits()
+	}
+
+	return res, d.err
+}
+
+// AllowWithRetry behaves like Allow and, on denial, also returns how long until
+// enough tokens will have refilled, computed by Redis against the shared
+// bucket. A request that can never succeed returns NeverAvailable. Decisions
+// made by the failure mode only carry a retry duration under FailDegrade.
+func (r *RedisLimiter) AllowWithRetry(key string, tokens int) (bool, time.Duration) {
+	if tokens < 0 {
+		return false, NeverAvailable
+	}
+
+	d := r.check(context.Background(), "AllowWithRetry", key, float64(tokens), false, r.failureMode, false)
+	return d.allowed, d.retryAfter
+}
+
+// Remaining returns the key's current token count, refilled to now, without
+// consuming anything.
+func (r *RedisLimiter) Remaining(key string) (float64, error) {
+	result, err := r.runTokenBucket(context.Background(), key, 0, "peek")
+	if err != nil {
+		r.metrics.OnError(key, err)
+		return 0, err
+	}
+
+	return parseTokens(result)
+}
+
+// AddTokens credits the key's bucket with tokens, atomically in Redis, without
+// exceeding its capacity. A negative amount debits it, down to zero. It
+// returns the bucket's new token count.
+func (r *RedisLimiter) AddTokens(key string, tokens float64) (float64, error) {
+	return r.adjustTokens(key, tokens, "add")
+}
+
+// SetTokens sets the key's bucket to tokens, clamped between zero and its
+// capacity, and returns the new token count.
+func (r *RedisLimiter) SetTokens(key string, tokens float64) (float64, error) {
+	return r.adjustTokens(key, tokens, "set")
+}
+
+func (r *RedisLimiter) adjustTokens(key string, tokens float64, mode string) (float64, error) {
+	result, err := r.runTokenBucket(context.Background(), key, tokens, mode)
+	if err != nil {
+		r.metrics.OnError(key, err)
+		return 0, err
+	}
+
+	return parseTokens(result)
+}
+
+// Reset deletes the key's bucket from Redis, so its next call starts with a
+// full one.
+func (r *RedisLimiter) Reset(key string) error {
+	return r.client.Del(context.Background(), r.redisKey(key)).Err()
 }
 
 func (r *RedisLimiter) Wait(ctx context.Context, key string, tokens int) error {
-	if float64(tokens) > r.capacity {
-		return ErrExceedsCapacity
+	_, err := r.WaitDetailed(ctx, key, tokens)
+	return err
+}
+
+// AllowOrWait takes tokens for key now if Redis allows it, and otherwise waits
+// up to maxWait for them. See the package-level AllowOrWait.
+func (r *RedisLimiter) AllowOrWait(ctx context.Context, key string, tokens int, maxWait time.Duration) (bool, time.Duration, error) {
+	return AllowOrWait(ctx, r, key, tokens, maxWait)
+}
+
+// WaitDetailed behaves like Wait and also reports how the wait was satisfied.
+func (r *RedisLimiter) WaitDetailed(ctx context.Context, key string, tokens int) (WaitResult, error) {
+	return r.waitTraced(ctx, key, float64(tokens), false)
+}
+
+// WaitFloat behaves like Wait for a fractional cost. Like AllowFloat, the cost
+// is used as given rather than passed through the cost pipeline.
+func (r *RedisLimiter) WaitFloat(ctx context.Context, key string, tokens float64) error {
+	_, err := r.waitTraced(ctx, key, tokens, true)
+	return err
+}
+
+// WaitClamp behaves like Wait, but a cost larger than the capacity is clamped
+// to it, waiting for a full bucket and taking all of it instead of failing
+// with ErrExceedsCapacity. It returns the tokens taken, after the cost
+// pipeline.
+func (r *RedisLimiter) WaitClamp(ctx context.Context, key string, tokens int) (float64, error) {
+	if tokens < 0 {
+		return 0, ErrNegativeTokens
+	}
+
+	capacity, _ := r.limits()
+	cost := min(r.cost(ctx, key, float64(tokens), false), capacity)
+
+	if _, err := r.waitTraced(ctx, key, cost, true); err != nil {
+		return 0, err
+	}
+
+	return cost, nil
+}
+
+// waitTraced runs wait inside a span if a tracer is set.
+func (r *RedisLimiter) waitTraced(ctx context.Context, key string, tokens float64, weighted bool) (WaitResult, error) {
+	if r.tracer == nil {
+		result, _, err := r.wait(ctx, key, tokens, weighted)
+		return result, err
+	}
+
+	ctx, span := r.tracer.Start(ctx, "Wait", key)
+	result, d, err := r.wait(ctx, key, tokens, weighted)
+
+	spanErr := err
+	if spanErr == nil {
+		spanErr = d.err
 	}
+	span.End(err == nil, tokens, d.latency, spanErr)
+
+	return result, err
+}
+
+// wait implements WaitDetailed, also returning the last decision made.
+func (r *RedisLimiter) wait(ctx context.Context, key string, tokens float64, weighted bool) (WaitResult, decision, error) {
+	var result WaitResult
+	var d decision
+	start := r.clock.Now()
+
+	if invalidCost(tokens) {
+		return result, d, ErrNegativeTokens
+	}
+
+	tokens = r.cost(ctx, key, tokens, weighted)
 
-	for {
-		if r.Allow(key, tokens) {
-			return nil
+	sawFailover := false
+	for attempts := 1; ; attempts++ {
+		if r.closed() {
+			return result, d, ErrLimiterClosed
 		}
 
+		r.limitsMu.RLock()
+		capacity, changed := r.capacity, r.limitsChanged
+		r.limitsMu.RUnlock()
+
+		if tokens > capacity {
+			return result, d, ErrExceedsCapacity
+		}
+
+		d = r.decide(ctx, key, tokens, r.failureMode, false)
+		if d.allowed {
+			result.Iterations = attempts
+			result.Waited = r.clock.Now().Sub(start)
+			switch {
+			case d.failedOver:
+				result.Path = WaitAfterDegrade
+			case sawFailover:
+				result.Path = WaitAfterRecovery
+			case attempts > 1:
+				result.Path = WaitAfterRefill
+			default:
+				result.Path = WaitFastPath
+			}
+			return result, d, nil
+		}
+		sawFailover = sawFailover || d.failedOver
+
+		if r.maxWaitAttempts > 0 && attempts >= r.maxWaitAttempts {
+			return result, d, ErrWaitAttemptsExceeded
+		}
+
+		timer := time.NewTimer(r.waitSleep(ctx, d.retryAfter))
 		select {
 		case <-ctx.Done():
-			return ctx.Err()
-		case <-time.After(20 * time.Millisecond):
+			timer.Stop()
+			return result, d, ctx.Err()
+		case <-changed:
+			timer.Stop()
+		case <-r.done:
+			timer.Stop()
+		case <-timer.C:
 		}
 	}
 }
 
-func (r *RedisLimiter) handleFailure(key string, tokens int) bool {
-	switch r.failureMode {
-	case FailOpen:
-		r.metrics.OnAllow(key)
+// Close stops the limiter: every later call is denied with ErrLimiterClosed,
+// blocked Waits return it, and the FailDegrade local limiter, if any, is
+// closed. The Redis client belongs to the caller and is left open. It is safe
+// to call more than once and always returns nil.
+func (r *RedisLimiter) Close() error {
+	r.closeOnce.Do(func() {
+		close(r.done)
+
+		if r.localLimiter != nil {
+			r.localLimiter.Close()
+		}
+	})
+
+	return nil
+}
+
+func (r *RedisLimiter) closed() bool {
+	select {
+	case <-r.done:
 		return true
-	case FailClosed:
-		r.metrics.OnDeny(key)
+	default:
 		return false
+	}
+}
+
+func (r *RedisLimiter) Config() LimiterConfig {
+	capacity, refillRate := r.limits()
+
+	return LimiterConfig{
+		Capacity:       capacity,
+		RefillRate:     refillRate,
+		KeyPrefix:      r.keyPrefix,
+		FailureMode:    r.failureMode,
+		CircuitBreaker: r.circuitBreaker != nil,
+		Metrics:        r.hasMetrics,
+		Shadow:         r.shadow,
+	}
+}
+
+// DetectClockDrift compares the local clock against Redis TIME and returns how
+// far Redis is ahead (positive) or behind (negative), correcting for half the
+// round trip. It is diagnostic only and changes no limiter behavior.
+func (r *RedisLimiter) DetectClockDrift(ctx context.Context) (time.Duration, error) {
+	start := r.clock.Now()
+
+	redisNow, err := r.client.Time(ctx).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	rtt := r.clock.Now().Sub(start)
+	drift := redisNow.Sub(start.Add(rtt / 2))
+
+	if m, ok := r.metrics.(DriftMetrics); ok {
+		m.OnClockDrift(drift)
+	}
+
+	return drift, nil
+}
+
+// ShardFor returns the Redis Cluster hash slot the key's bucket is stored in.
+func (r *RedisLimiter) ShardFor(key string) int {
+	return keySlot(r.redisKey(key))
+}
+
+// Probe pings Redis if the circuit breaker is ready to test recovery and
+// records the outcome on the breaker. It is a no-op while the breaker is closed
+// or still waiting out its timeout.
+func (r *RedisLimiter) Probe(ctx context.Context) error {
+	if r.circuitBreaker == nil || !r.circuitBreaker.ProbeDue() {
+		return nil
+	}
+
+	if err := r.client.Ping(ctx).Err(); err != nil {
+		r.circuitBreaker.RecordFailure()
+		r.metrics.OnError(r.keyPrefix, err)
+		return err
+	}
+
+	r.circuitBreaker.RecordSuccess()
+	return nil
+}
+
+// ExportBreakerState returns the circuit breaker state, or a closed snapshot
+// if no breaker is configured.
+func (r *RedisLimiter) ExportBreakerState() BreakerSnapshot {
+	if r.circuitBreaker == nil {
+		return BreakerSnapshot{State: CircuitClosed}
+	}
+
+	return r.circuitBreaker.Snapshot()
+}
+
+// ImportBreakerState restores a snapshot taken with ExportBreakerState so a
+// restarted instance doesn't probe a Redis that is known to be down.
+func (r *RedisLimiter) ImportBreakerState(s BreakerSnapshot) {
+	if r.circuitBreaker == nil {
+		return
+	}
+
+	r.circuitBreaker.Restore(s)
+}
+
+// SetLimits changes the capacity and refill rate passed to subsequent script
+// calls, and resizes the FailDegrade local limiter to match. Since every
+// instance passes its own limits, all instances sharing a bucket should be
+// changed together. Goroutines blocked in Wait recompute their delay.
+func (r *RedisLimiter) SetLimits(capacity float64, refillRate float64) {
+	r.limitsMu.Lock()
+	defer r.limitsMu.Unlock()
+
+	r.capacity = capacity
+	r.refillRate = refillRate
+	r.limitsUpdated()
+}
+
+// Rate returns the refill rate. With SetRate it makes the limiter a
+// RateController, so a governor can tune it.
+func (r *RedisLimiter) Rate() float64 {
+	_, refillRate := r.limits()
+	return refillRate
+}
+
+// SetRate changes the refill rate, keeping the capacity.
+func (r *RedisLimiter) SetRate(refillRate float64) {
+	r.limitsMu.Lock()
+	defer r.limitsMu.Unlock()
+
+	r.refillRate = refillRate
+	r.limitsUpdated()
+}
+
+// limitsUpdated passes new limits on to the local fallback and wakes waiters.
+// Must be called with r.limitsMu held.
+func (r *RedisLimiter) limitsUpdated() {
+	if r.localLimiter != nil {
+		r.localLimiter.SetLimits(r.capacity*r.degradeScale, r.refillRate*r.degradeScale)
+	}
+
+	close(r.limitsChanged)
+	r.limitsChanged = make(chan struct{})
+}
+
+func (r *RedisLimiter) limits() (float64, float64) {
+	r.limitsMu.RLock()
+	defer r.limitsMu.RUnlock()
+
+	return r.capacity, r.refillRate
+}
+
+// runTokenBucketPipeline runs the consume script for each key in a single
+// pipeline. If the script isn't cached on the server it is loaded and the keys
+// that missed it are retried once.
+func (r *RedisLimiter) runTokenBucketPipeline(ctx context.Context, keys []string, tokens []float64) []*redis.Cmd {
+	capacity, refillRate := r.limits()
+	ttl := r.keyTTL.Milliseconds()
+
+	run := func(indexes []int) []*redis.Cmd {
+		pipe := r.client.Pipeline()
+		cmds := make([]*redis.Cmd, len(indexes))
+		for j, i := range indexes {
+			redisKeys := []string{r.redisKey(keys[i])}
+			if r.useFunctions {
+				cmds[j] = pipe.FCall(ctx, tokenBucketFunction, redisKeys, tokens[i], capacity, refillRate, "consume", ttl)
+			} else {
+				cmds[j] = pipe.EvalSha(ctx, r.script.Hash(), redisKeys, tokens[i], capacity, refillRate, "consume", ttl)
+			}
+		}
+		if _, err := pipe.Exec(ctx); err != nil {
+			// A connection failure can leave commands without an error of
+			// their own.
+			for _, cmd := range cmds {
+				if cmd.Err() == nil {
+					cmd.SetErr(err)
+				}
+			}
+		}
+		return cmds
+	}
+
+	all := make([]int, len(keys))
+	for i := range all {
+		all[i] = i
+	}
+	cmds := run(all)
+
+	// Keys whose script ran have been charged, so only the ones that missed
+	// the script are retried.
+	var missing []int
+	for i, cmd := range cmds {
+		if r.missingScript(cmd.Err()) {
+			missing = append(missing, i)
+		}
+	}
+	if len(missing) == 0 {
+		return cmds
+	}
+
+	r.reloadScript(ctx)
+	for j, cmd := range run(missing) {
+		cmds[missing[j]] = cmd
+	}
+
+	return cmds
+}
+
+// missingScript reports whether err means Redis has lost the token bucket
+// script or function library, e.g. after a restart, a FUNCTION FLUSH or a
+// failover to a replica that never loaded it.
+func (r *RedisLimiter) missingScript(err error) bool {
+	if r.useFunctions {
+		return err != nil && strings.Contains(err.Error(), "Function not found")
+	}
+
+	return redis.HasErrorPrefix(err, "NOSCRIPT")
+}
+
+// reloadScript loads the token bucket script or function library again.
+func (r *RedisLimiter) reloadScript(ctx context.Context) error {
+	if r.useFunctions {
+		return r.client.FunctionLoadReplace(ctx, tokenBucketLibrary).Err()
+	}
+
+	return r.script.Load(ctx, r.client).Err()
+}
+
+func (r *RedisLimiter) runTokenBucket(ctx context.Context, key string, tokens float64, mode string) (interface{}, error) {
+	keys := []string{r.redisKey(key)}
+	capacity, refillRate := r.limits()
+
+	if r.useFunctions {
+		result, err := r.client.FCall(ctx, tokenBucketFunction, keys, tokens, capacity, refillRate, mode, r.keyTTL.Milliseconds()).Result()
+		if r.missingScript(err) && r.reloadScript(ctx) == nil {
+			result, err = r.client.FCall(ctx, tokenBucketFunction, keys, tokens, capacity, refillRate, mode, r.keyTTL.Milliseconds()).Result()
+		}
+		return result, err
+	}
+
+	return r.script.Run(ctx, r.client, keys, tokens, capacity, refillRate, mode, r.keyTTL.Milliseconds()).Result()
+}
+
+// redisKey returns the Redis key holding key's bucket.
+func (r *RedisLimiter) redisKey(key string) string {
+	if r.keyNamespace != nil {
+		return r.keyNamespace(key)
+	}
+
+	return r.keyPrefix + key
+}
+
+// parseTokens reads the token count the token bucket script returns as a
+// string to keep its fractional part.
+func parseTokens(result interface{}) (float64, error) {
+	resSlice := result.([]interface{})
+	return strconv.ParseFloat(resSlice[1].(string), 64)
+}
+
+// defaultWaitPollInterval is how long Wait sleeps between checks when there's
+// no retry duration to go on, e.g. when failing over without a local limiter.
+const defaultWaitPollInterval = 20 * time.Millisecond
+
+// waitSleep returns how long Wait should sleep before checking again: the
+// bucket's retry duration if known, but never past the context deadline.
+func (r *RedisLimiter) waitSleep(ctx context.Context, retryAfter time.Duration) time.Duration {
+	sleep := r.waitPollInterval
+	if retryAfter > 0 && retryAfter != NeverAvailable {
+		sleep = retryAfter
+	}
+
+	sleep = jittered(sleep, r.waitJitter, r.jitterSample)
+
+	if r.minWaitPoll > 0 {
+		sleep = max(sleep, r.minWaitPoll)
+	}
+	if r.maxWaitPoll > 0 {
+		sleep = min(sleep, r.maxWaitPoll)
+	}
+
+	// The deadline and the sleep are both in real time, whatever the clock.
+	if deadline, ok := ctx.Deadline(); ok {
+		sleep = min(sleep, time.Until(deadline))
+	}
+
+	return sleep
+}
+
+// jittered randomizes d by up to ±fraction using sample, a source of uniform
+// values in [0, 1).
+func jittered(d time.Duration, fraction float64, sample func() float64) time.Duration {
+	if fraction <= 0 {
+		return d
+	}
+
+	return time.Duration(float64(d) * (1 + fraction*(2*sample()-1)))
+}
+
+// parseRetryAfter reads the retry-after seconds the token bucket script
+// returns, where a negative value means the request can never succeed.
+func parseRetryAfter(s string) time.Duration {
+	seconds, err := strconv.ParseFloat(s, 64)
+	if err != nil || seconds < 0 {
+		return NeverAvailable
+	}
+
+	return time.Duration(seconds * float64(time.Second))
+}
+
+func (r *RedisLimiter) logDecision(ctx context.Context, key string, tokens float64, d decision, latency time.Duration) {
+	logDeny := !d.allowed && r.logAllDenies
+	if !logDeny && r.decisionRate < 1 && r.decisionSample() >= r.decisionRate {
+		return
+	}
+
+	attrs := []slog.Attr{
+		slog.String("key", key),
+		slog.Float64("cost", tokens),
+		slog.Bool("allowed", d.allowed),
+		slog.String("reason", d.reason),
+		slog.Duration("latency", latency),
+	}
+	if !d.failedOver {
+		attrs = append(attrs, slog.Float64("remaining", d.remaining))
+	}
+
+	r.decisionLog.LogAttrs(ctx, slog.LevelInfo, "rate limit decision", attrs...)
+}
+
+func (r *RedisLimiter) cost(ctx context.Context, key string, tokens float64, weighted bool) float64 {
+	if r.costPipeline == nil || weighted {
+		return tokens
+	}
+
+	return float64(r.costPipeline.Cost(ctx, key, int(tokens)))
+}
+
+// CircuitState returns the circuit breaker's state, or CircuitClosed if the
+// limiter has none.
+func (r *RedisLimiter) CircuitState() CircuitState {
+	if r.circuitBreaker == nil {
+		return CircuitClosed
+	}
+
+	return r.circuitBreaker.State()
+}
+
+// FailoverStats returns the limiter's decision counts by source.
+func (r *RedisLimiter) FailoverStats() FailoverStats {
+	return FailoverStats{
+		RedisServed:  r.redisServed.Load(),
+		FailedOpen:   r.failedOpen.Load(),
+		FailedClosed: r.failedClosed.Load(),
+		Degraded:     r.degraded.Load(),
+	}
+}
+
+func (r *RedisLimiter) handleFailure(key string, tokens float64, mode FailureMode) decision {
+	switch mode {
+	case FailOpen:
+		r.failedOpen.Add(1)
+		recordAllow(r.metrics, key, tokens)
+		return decision{allowed: true, failedOver: true}
+	case FailClosed:
+		r.failedClosed.Add(1)
+		recordDeny(r.metrics, key, r.shadow)
+		return decision{failedOver: true}
 	case FailDegrade:
-		allowed := r.localLimiter.Allow(key, tokens)
+		r.degraded.Add(1)
+		allowed, retryAfter := r.localLimiter.getOrCreateBucket(key).allowWithRetry(tokens)
 		if allowed {
-			r.metrics.OnAllow(key)
+			recordAllow(r.metrics, key, tokens)
 		} else {
-			r.metrics.OnDeny(key)
+			recordDeny(r.metrics, key, r.shadow)
 		}
-		return allowed
+		return decision{allowed: allowed, failedOver: true, retryAfter: retryAfter}
 	default:
-		return true
+		r.failedOpen.Add(1)
+		return decision{allowed: true, failedOver: true}
 	}
 }
@@ -2,13 +2,22 @@ package limiter
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"math/rand/v2"
 	"slices"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/alicebob/miniredis/v2"
 	"github.com/redis/go-redis/v9"
+	"go.uber.org/goleak"
 )
 
 type MockMetrics struct {
@@ -17,6 +26,16 @@ type MockMetrics struct {
 	denies    []string
 	errors    []string
 	latencies []time.Duration
+	consumed  map[string]int
+}
+
+func (m *MockMetrics) OnConsume(key string, tokens int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.consumed == nil {
+		m.consumed = make(map[string]int)
+	}
+	m.consumed[key] += tokens
 }
 
 func (m *MockMetrics) OnAllow(key string) {
@@ -43,6 +62,18 @@ func (m *MockMetrics) OnLatency(key string, d time.Duration) {
 	m.latencies = append(m.latencies, d)
 }
 
+// shadowMetrics is a MockMetrics that tells shadow denies apart.
+type shadowMetrics struct {
+	MockMetrics
+	shadowDenies []string
+}
+
+func (m *shadowMetrics) OnShadowDeny(key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.shadowDenies = append(m.shadowDenies, key)
+}
+
 func setupTestRedis(t *testing.T) *redis.Client {
 	client := redis.NewClient(&redis.Options{
 		Addr: "localhost:6379",
@@ -55,6 +86,31 @@ func setupTestRedis(t *testing.T) *redis.Client {
 	return client
 }
 
+func setupMiniRedis(t *testing.T) (*miniredis.Miniredis, *redis.Client) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{
+		Addr:       mr.Addr(),
+		MaxRetries: -1,
+	})
+
+	return mr, client
+}
+
+func redisMajorVersion(client *redis.Client) int {
+	info, err := client.Info(context.Background(), "server").Result()
+	if err != nil {
+		return 0
+	}
+
+	_, version, found := strings.Cut(info, "redis_version:")
+	if !found {
+		return 0
+	}
+
+	major, _ := strconv.Atoi(strings.Split(version, ".")[0])
+	return major
+}
+
 func cleanupKey(t *testing.T, client *redis.Client, key string) {
 	client.Del(context.Background(), key)
 }
@@ -274,3 +330,1844 @@ func TestCircuitBreaker_IntegrationFailsFast(t *testing.T) {
 		t.Errorf("expected at least 4 errors, got %d", len(metrics.errors))
 	}
 }
+
+func TestImportBreakerState_FailsFast(t *testing.T) {
+	client := redis.NewClient(&redis.Options{
+		Addr: "localhost:9999",
+	})
+	old := NewRedisLimiter(client, 5, 1, "ratelimit:",
+		WithCircuitBreaker(3, 30*time.Second),
+		WithFailureMode(FailClosed),
+	)
+
+	old.Allow("Restart", 1)
+	old.Allow("Restart", 1)
+	old.Allow("Restart", 1)
+
+	snapshot := old.ExportBreakerState()
+	if snapshot.State != CircuitOpen {
+		t.Fatalf("expected exported state to be CircuitOpen, got %d", snapshot.State)
+	}
+
+	metrics := &MockMetrics{}
+	restarted := NewRedisLimiter(client, 5, 1, "ratelimit:",
+		WithCircuitBreaker(3, 30*time.Second),
+		WithFailureMode(FailClosed),
+		WithMetrics(metrics),
+	)
+	restarted.ImportBreakerState(snapshot)
+
+	if restarted.Allow("Restart", 1) {
+		t.Error("expected allow to be false with an imported open breaker")
+	}
+
+	if len(metrics.latencies) != 0 {
+		t.Errorf("expected no redis calls, got %d", len(metrics.latencies))
+	}
+}
+
+func TestProbe_ClosesBreakerWithoutUserTraffic(t *testing.T) {
+	mr, client := setupMiniRedis(t)
+	metrics := &MockMetrics{}
+	limiter := NewRedisLimiter(client, 5, 1, "ratelimit:",
+		WithCircuitBreaker(3, 10*time.Millisecond),
+		WithFailureMode(FailClosed),
+		WithSyntheticProbe(),
+		WithMetrics(metrics),
+	)
+
+	mr.SetError("LOADING Redis is loading the dataset in memory")
+	limiter.Allow("Probe", 1)
+	limiter.Allow("Probe", 1)
+	limiter.Allow("Probe", 1)
+
+	time.Sleep(20 * time.Millisecond)
+	mr.SetError("")
+	calls := len(metrics.latencies)
+
+	if limiter.Allow("Probe", 1) {
+		t.Error("expected user traffic to keep failing over while half-open")
+	}
+
+	if len(metrics.latencies) != calls {
+		t.Error("expected user traffic not to reach redis while half-open")
+	}
+
+	if err := limiter.Probe(context.Background()); err != nil {
+		t.Fatalf("expected probe to succeed, got %v", err)
+	}
+
+	if limiter.circuitBreaker.State() != CircuitClosed {
+		t.Errorf("expected probe to close the breaker, got %d", limiter.circuitBreaker.State())
+	}
+
+	if !limiter.Allow("Probe", 1) {
+		t.Error("expected allow to be true once the breaker is closed")
+	}
+}
+
+func TestAllow_NegativeAndZeroTokensRedis(t *testing.T) {
+	_, client := setupMiniRedis(t)
+	limiter := NewRedisLimiter(client, 5, 0, "ratelimit:")
+
+	limiter.Allow("Tokens", 5)
+
+	if limiter.Allow("Tokens", -5) {
+		t.Error("expected negative request to be denied")
+	}
+
+	if !limiter.Allow("Tokens", 0) {
+		t.Error("expected zero token request to be allowed")
+	}
+
+	if limiter.Allow("Tokens", 1) {
+		t.Error("expected negative request not to add tokens")
+	}
+
+	if err := limiter.Wait(context.Background(), "Tokens", -1); err != ErrNegativeTokens {
+		t.Errorf("expected ErrNegativeTokens, got %v", err)
+	}
+}
+
+func TestTokenBucketScript_RejectsNegativeTokens(t *testing.T) {
+	_, client := setupMiniRedis(t)
+	script := redis.NewScript(tokenBucketScript)
+
+	result, err := script.Run(context.Background(), client, []string{"ratelimit:Script"}, -5, 5, 0, "consume").Result()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	resSlice := result.([]interface{})
+	if resSlice[0].(int64) != 0 {
+		t.Error("expected script to deny a negative request")
+	}
+
+	if resSlice[1].(string) != "5" {
+		t.Errorf("expected 5 tokens, got %s", resSlice[1].(string))
+	}
+}
+
+func TestMetricsSampling_AlwaysRecordsDenies(t *testing.T) {
+	_, client := setupMiniRedis(t)
+	metrics := &MockMetrics{}
+	limiter := NewRedisLimiter(client, 5, 0, "ratelimit:",
+		WithMetricsSampling(0),
+		WithMetrics(metrics),
+	)
+
+	limiter.Allow("Sampled", 5)
+	limiter.Allow("Sampled", 1)
+
+	if len(metrics.allows) != 0 || len(metrics.latencies) != 0 {
+		t.Error("expected allows and latencies to be sampled out")
+	}
+
+	if !slices.Contains(metrics.denies, "Sampled") {
+		t.Error("expected metrics.denies to contain the key")
+	}
+}
+
+func TestDenyDetailMetrics_RedisLimiter(t *testing.T) {
+	_, client := setupMiniRedis(t)
+	metrics := &MockMetrics{}
+	limiter := NewRedisLimiter(client, 5, 0, "ratelimit:",
+		WithDenyDetailMetrics(1),
+		WithMetrics(metrics),
+	)
+
+	limiter.Allow("Detailed", 5)
+	limiter.Allow("Detailed", 1)
+
+	if !slices.Equal(metrics.allows, []string{AggregateKey}) {
+		t.Errorf("expected one aggregated allow, got %v", metrics.allows)
+	}
+
+	if !slices.Equal(metrics.denies, []string{"Detailed"}) {
+		t.Errorf("expected the deny to keep its key, got %v", metrics.denies)
+	}
+}
+
+func TestWait_MaxWaitAttempts(t *testing.T) {
+	_, client := setupMiniRedis(t)
+	metrics := &MockMetrics{}
+	limiter := NewRedisLimiter(client, 5, 0, "ratelimit:",
+		WithMaxWaitAttempts(3),
+		WithMetrics(metrics),
+	)
+
+	limiter.Allow("Stuck", 5)
+
+	err := limiter.Wait(context.Background(), "Stuck", 1)
+
+	if err != ErrWaitAttemptsExceeded {
+		t.Errorf("expected ErrWaitAttemptsExceeded, got %v", err)
+	}
+
+	if len(metrics.latencies) != 4 {
+		t.Errorf("expected 4 redis calls, got %d", len(metrics.latencies))
+	}
+}
+
+func TestShardFor_MatchesPrefixedKey(t *testing.T) {
+	client := redis.NewClient(&redis.Options{
+		Addr: "localhost:9999",
+	})
+	limiter := NewRedisLimiter(client, 5, 1, "{tenant}:")
+
+	if limiter.ShardFor("user-1") != keySlot("tenant") {
+		t.Error("expected the prefix hash tag to determine the shard")
+	}
+
+	if limiter.ShardFor("user-1") != limiter.ShardFor("user-2") {
+		t.Error("expected keys under one hash-tagged prefix to share a shard")
+	}
+}
+
+func TestConfig_ReflectsOptions(t *testing.T) {
+	client := redis.NewClient(&redis.Options{
+		Addr: "localhost:9999",
+	})
+	limiter := NewRedisLimiter(client, 5, 1, "ratelimit:",
+		WithFailureMode(FailClosed),
+		WithCircuitBreaker(3, 30*time.Second),
+		WithMetrics(&MockMetrics{}),
+	)
+
+	expected := LimiterConfig{
+		Capacity:       5,
+		RefillRate:     1,
+		KeyPrefix:      "ratelimit:",
+		FailureMode:    FailClosed,
+		CircuitBreaker: true,
+		Metrics:        true,
+	}
+
+	if config := limiter.Config(); config != expected {
+		t.Errorf("expected config %+v, got %+v", expected, config)
+	}
+
+	if NewRedisLimiter(client, 5, 1, "ratelimit:").Config().Metrics {
+		t.Error("expected metrics to be unset by default")
+	}
+}
+
+func TestConfig_MetricsUnsetWhenSampled(t *testing.T) {
+	client := redis.NewClient(&redis.Options{
+		Addr: "localhost:9999",
+	})
+
+	sampled := NewRedisLimiter(client, 5, 1, "ratelimit:", WithMetricsSampling(0.5))
+	if sampled.Config().Metrics {
+		t.Error("expected sampling alone not to report metrics as set")
+	}
+
+	detailed := NewRedisLimiter(client, 5, 1, "ratelimit:", WithDenyDetailMetrics(0.1))
+	if detailed.Config().Metrics {
+		t.Error("expected deny detail alone not to report metrics as set")
+	}
+
+	withMetrics := NewRedisLimiter(client, 5, 1, "ratelimit:", WithMetrics(&MockMetrics{}), WithMetricsSampling(0.5))
+	if !withMetrics.Config().Metrics {
+		t.Error("expected sampled metrics to be reported as set")
+	}
+}
+
+func TestErrorClassifier_OnlyTransientTripsBreaker(t *testing.T) {
+	classify := func(err error) ErrorClass {
+		switch {
+		case strings.HasPrefix(err.Error(), "LOADING"):
+			return Transient
+		case strings.HasPrefix(err.Error(), "WRONGTYPE"):
+			return Fatal
+		default:
+			return Ignore
+		}
+	}
+
+	cases := []struct {
+		name    string
+		err     string
+		allowed bool
+		state   CircuitState
+	}{
+		{"transient", "LOADING Redis is loading the dataset in memory", true, CircuitOpen},
+		{"fatal", "WRONGTYPE Operation against a key holding the wrong kind of value", true, CircuitClosed},
+		{"ignore", "MOVED 3999 127.0.0.1:6381", false, CircuitClosed},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			mr, client := setupMiniRedis(t)
+			limiter := NewRedisLimiter(client, 5, 1, "ratelimit:",
+				WithCircuitBreaker(3, 30*time.Second),
+				WithErrorClassifier(classify),
+			)
+
+			mr.SetError(tc.err)
+			var allowed bool
+			for range 3 {
+				allowed = limiter.Allow("Classified", 1)
+			}
+
+			if allowed != tc.allowed {
+				t.Errorf("expected allow to be %v, got %v", tc.allowed, allowed)
+			}
+
+			if limiter.circuitBreaker.State() != tc.state {
+				t.Errorf("expected state %d, got %d", tc.state, limiter.circuitBreaker.State())
+			}
+		})
+	}
+}
+
+func TestCostPipeline_AppliedBeforeAllow(t *testing.T) {
+	_, client := setupMiniRedis(t)
+	pipeline := NewCostPipeline(5, func(ctx context.Context, key string, cost int) int {
+		return cost * 2
+	})
+	limiter := NewRedisLimiter(client, 5, 0, "ratelimit:", WithCostPipeline(pipeline))
+
+	limiter.Allow("Cost", 2)
+
+	if limiter.Allow("Cost", 1) {
+		t.Error("expected doubled costs to exhaust the bucket")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if err := limiter.Wait(ctx, "Cost", 10); err != context.DeadlineExceeded {
+		t.Errorf("expected the pipeline to clamp the cost to capacity and wait, got %v", err)
+	}
+}
+
+func TestRedisLimiter_AllowFloat(t *testing.T) {
+	_, client := setupMiniRedis(t)
+	pipeline := NewCostPipeline(5, func(ctx context.Context, key string, cost int) int {
+		return cost * 2
+	})
+	limiter := NewRedisLimiter(client, 3, 0, "ratelimit:", WithCostPipeline(pipeline))
+
+	if !limiter.AllowFloat("Float", 2.5) {
+		t.Fatal("expected a fractional cost to be allowed")
+	}
+
+	if remaining, _ := limiter.Remaining("Float"); remaining != 0.5 {
+		t.Errorf("expected 0.5 tokens left without the pipeline doubling the cost, got %f", remaining)
+	}
+
+	if !limiter.AllowFloat("Float", 0.5) || limiter.AllowFloat("Float", 0.5) {
+		t.Error("expected the last half token to be allowed once")
+	}
+
+	if err := limiter.WaitFloat(context.Background(), "Float", 3.5); err != ErrExceedsCapacity {
+		t.Errorf("expected ErrExceedsCapacity, got %v", err)
+	}
+}
+
+func TestRedisFunctions_FCall(t *testing.T) {
+	client := setupTestRedis(t)
+	key := "test:functions"
+	defer cleanupKey(t, client, "ratelimit:"+key)
+
+	if redisMajorVersion(client) < 7 {
+		t.Skip("Redis 7+ not available, skipping functions test")
+	}
+
+	limiter := NewRedisLimiter(client, 5, 1, "ratelimit:", WithRedisFunctions())
+
+	if !limiter.useFunctions {
+		t.Fatal("expected the function library to load")
+	}
+
+	for i := range 5 {
+		if !limiter.Allow(key, 1) {
+			t.Errorf("request %d should be allowed", i+1)
+		}
+	}
+
+	if limiter.Allow(key, 1) {
+		t.Error("request 6 should be denied")
+	}
+}
+
+func TestRedisFunctions_FallsBackToScript(t *testing.T) {
+	_, client := setupMiniRedis(t)
+	limiter := NewRedisLimiter(client, 5, 0, "ratelimit:", WithRedisFunctions())
+
+	if limiter.useFunctions {
+		t.Fatal("expected functions to be disabled on a server without FUNCTION support")
+	}
+
+	limiter.Allow("Fallback", 5)
+	if limiter.Allow("Fallback", 1) {
+		t.Error("expected the script path to enforce the limit")
+	}
+}
+
+// functionStore fakes a server's function library on top of miniredis, which
+// has no FCALL: calls fail with "Function not found" until the library is
+// loaded, then allow with a canned reply.
+type functionStore struct {
+	loaded bool
+	loads  int
+}
+
+func (f *functionStore) DialHook(next redis.DialHook) redis.DialHook { return next }
+
+func (f *functionStore) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		if !f.handle(cmd) {
+			return next(ctx, cmd)
+		}
+		return cmd.Err()
+	}
+}
+
+func (f *functionStore) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		for _, cmd := range cmds {
+			f.handle(cmd)
+		}
+		return nil
+	}
+}
+
+// handle answers FUNCTION LOAD and FCALL, reporting whether cmd was one.
+func (f *functionStore) handle(cmd redis.Cmder) bool {
+	switch cmd.Name() {
+	case "function":
+		f.loaded = true
+		f.loads++
+		cmd.(*redis.StringCmd).SetVal(tokenBucketLibraryName)
+	case "fcall":
+		if !f.loaded {
+			cmd.SetErr(errors.New("ERR Function not found"))
+			break
+		}
+		cmd.(*redis.Cmd).SetVal([]interface{}{int64(1), "4", "0", "1", "5"})
+	default:
+		return false
+	}
+	return true
+}
+
+func TestRedisFunctions_ReloadsLostLibrary(t *testing.T) {
+	_, client := setupMiniRedis(t)
+	functions := &functionStore{}
+	client.AddHook(functions)
+
+	limiter := NewRedisLimiter(client, 5, 1, "ratelimit:", WithRedisFunctions(), WithCircuitBreaker(1, time.Minute))
+	if !limiter.useFunctions {
+		t.Fatal("expected the function library to load")
+	}
+
+	// As after a restart or a failover to a replica without the library.
+	functions.loaded = false
+	if allowed, err := limiter.AllowE("user1", 1); !allowed || err != nil {
+		t.Fatalf("expected the library to be reloaded and the call retried, got %t, %v", allowed, err)
+	}
+
+	functions.loaded = false
+	results, err := limiter.AllowMulti([]string{"user1", "user2"}, []int{1, 1})
+	if err != nil || !results[0] || !results[1] {
+		t.Fatalf("expected the pipeline to reload the library and retry, got %v, %v", results, err)
+	}
+
+	if functions.loads != 3 {
+		t.Errorf("expected the library to be loaded at construction and once per loss, got %d loads", functions.loads)
+	}
+	if limiter.CircuitState() != CircuitClosed {
+		t.Errorf("expected a reloaded library not to trip the breaker, got %v", limiter.CircuitState())
+	}
+}
+
+func TestRedisFunctions_SurvivesFunctionFlush(t *testing.T) {
+	client := setupTestRedis(t)
+	key := "test:functions:flush"
+	defer cleanupKey(t, client, "ratelimit:"+key)
+
+	if redisMajorVersion(client) < 7 {
+		t.Skip("Redis 7+ not available, skipping functions test")
+	}
+
+	limiter := NewRedisLimiter(client, 5, 1, "ratelimit:", WithRedisFunctions())
+	if err := client.FunctionFlush(context.Background()).Err(); err != nil {
+		t.Fatalf("function flush failed: %v", err)
+	}
+
+	if allowed, err := limiter.AllowE(key, 1); !allowed || err != nil {
+		t.Errorf("expected the flushed library to be reloaded, got %t, %v", allowed, err)
+	}
+}
+
+type MockDriftMetrics struct {
+	NoopMetrics
+	drifts []time.Duration
+}
+
+func (m *MockDriftMetrics) OnClockDrift(d time.Duration) {
+	m.drifts = append(m.drifts, d)
+}
+
+func TestDetectClockDrift_NearZero(t *testing.T) {
+	client := setupTestRedis(t)
+	limiter := NewRedisLimiter(client, 5, 1, "ratelimit:")
+
+	drift, err := limiter.DetectClockDrift(context.Background())
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if drift.Abs() > 100*time.Millisecond {
+		t.Errorf("expected near-zero drift against local redis, got %v", drift)
+	}
+}
+
+func TestDetectClockDrift_ReportsSkew(t *testing.T) {
+	mr, client := setupMiniRedis(t)
+	mr.SetTime(time.Now().Add(5 * time.Second))
+	metrics := &MockDriftMetrics{}
+	limiter := NewRedisLimiter(client, 5, 1, "ratelimit:", WithMetrics(metrics))
+
+	drift, err := limiter.DetectClockDrift(context.Background())
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if (drift - 5*time.Second).Abs() > 100*time.Millisecond {
+		t.Errorf("expected drift of about 5s, got %v", drift)
+	}
+
+	if len(metrics.drifts) != 1 {
+		t.Errorf("expected drift to be reported to metrics, got %d", len(metrics.drifts))
+	}
+}
+
+func TestRemaining_DoesNotConsume(t *testing.T) {
+	mr, client := setupMiniRedis(t)
+	now := time.Now()
+	mr.SetTime(now)
+	limiter := NewRedisLimiter(client, 5, 2, "ratelimit:")
+
+	if remaining, err := limiter.Remaining("Remaining"); remaining != 5 || err != nil {
+		t.Errorf("expected 5 tokens for an unknown key, got %f, %v", remaining, err)
+	}
+
+	limiter.Allow("Remaining", 4)
+	mr.SetTime(now.Add(250 * time.Millisecond))
+
+	for range 2 {
+		if remaining, err := limiter.Remaining("Remaining"); remaining != 1.5 || err != nil {
+			t.Errorf("expected 1.5 tokens after refill, got %f, %v", remaining, err)
+		}
+	}
+}
+
+func TestWaitDetailed_Paths(t *testing.T) {
+	mr, client := setupMiniRedis(t)
+	limiter := NewRedisLimiter(client, 5, 50, "ratelimit:", WithFailureMode(FailClosed))
+
+	result, err := limiter.WaitDetailed(context.Background(), "Detailed", 5)
+	if err != nil || result.Path != WaitFastPath || result.Iterations != 1 {
+		t.Errorf("expected fast path, got %+v, %v", result, err)
+	}
+
+	result, err = limiter.WaitDetailed(context.Background(), "Detailed", 1)
+	if err != nil || result.Path != WaitAfterRefill || result.Iterations < 2 {
+		t.Errorf("expected to succeed after a refill, got %+v, %v", result, err)
+	}
+
+	mr.SetError("LOADING Redis is loading the dataset in memory")
+	time.AfterFunc(30*time.Millisecond, func() { mr.SetError("") })
+
+	result, err = limiter.WaitDetailed(context.Background(), "Detailed", 1)
+	if err != nil || result.Path != WaitAfterRecovery {
+		t.Errorf("expected to succeed after recovery, got %+v, %v", result, err)
+	}
+
+	degraded := NewRedisLimiter(client, 5, 50, "ratelimit:", WithFailureMode(FailDegrade))
+	mr.SetError("LOADING Redis is loading the dataset in memory")
+
+	result, err = degraded.WaitDetailed(context.Background(), "Detailed", 1)
+	if err != nil || result.Path != WaitAfterDegrade {
+		t.Errorf("expected to succeed via degrade, got %+v, %v", result, err)
+	}
+}
+
+func TestWait_SleepsForRetryAfter(t *testing.T) {
+	_, client := setupMiniRedis(t)
+	limiter := NewRedisLimiter(client, 1, 5, "ratelimit:")
+
+	limiter.Allow("Computed", 1)
+
+	result, err := limiter.WaitDetailed(context.Background(), "Computed", 1)
+	if err != nil {
+		t.Fatalf("expected wait to succeed, got %v", err)
+	}
+
+	if result.Iterations > 3 {
+		t.Errorf("expected a computed sleep instead of polling every %v, got %d iterations", defaultWaitPollInterval, result.Iterations)
+	}
+
+	if result.Waited < 150*time.Millisecond {
+		t.Errorf("expected to wait roughly 200ms for a refill, got %v", result.Waited)
+	}
+}
+
+func TestWait_SleepRespectsDeadline(t *testing.T) {
+	_, client := setupMiniRedis(t)
+	limiter := NewRedisLimiter(client, 5, 1, "ratelimit:")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if sleep := limiter.waitSleep(ctx, time.Minute); sleep > 50*time.Millisecond {
+		t.Errorf("expected the sleep to be capped by the deadline, got %v", sleep)
+	}
+
+	if sleep := limiter.waitSleep(context.Background(), NeverAvailable); sleep != defaultWaitPollInterval {
+		t.Errorf("expected an unknown retry to fall back to polling, got %v", sleep)
+	}
+}
+
+func TestWait_PollIntervalAndBounds(t *testing.T) {
+	_, client := setupMiniRedis(t)
+	limiter := NewRedisLimiter(client, 5, 1, "ratelimit:",
+		WithWaitPollInterval(5*time.Millisecond),
+		WithWaitPollBounds(10*time.Millisecond, 100*time.Millisecond))
+
+	cases := []struct {
+		retryAfter time.Duration
+		want       time.Duration
+	}{
+		{NeverAvailable, 10 * time.Millisecond},
+		{time.Millisecond, 10 * time.Millisecond},
+		{50 * time.Millisecond, 50 * time.Millisecond},
+		{time.Second, 100 * time.Millisecond},
+	}
+	for _, tc := range cases {
+		if sleep := limiter.waitSleep(context.Background(), tc.retryAfter); sleep != tc.want {
+			t.Errorf("retry %v: expected to sleep %v, got %v", tc.retryAfter, tc.want, sleep)
+		}
+	}
+
+	polling := NewRedisLimiter(client, 5, 1, "ratelimit:", WithWaitPollInterval(5*time.Millisecond))
+	if sleep := polling.waitSleep(context.Background(), NeverAvailable); sleep != 5*time.Millisecond {
+		t.Errorf("expected to poll every 5ms, got %v", sleep)
+	}
+}
+
+func TestWait_JitterSpreadsSleeps(t *testing.T) {
+	_, client := setupMiniRedis(t)
+	limiter := NewRedisLimiter(client, 5, 1, "ratelimit:", WithWaitJitter(0.5))
+
+	samples := []float64{0, 0.5, 0.75}
+	want := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 250 * time.Millisecond}
+	for i, sample := range samples {
+		limiter.jitterSample = func() float64 { return sample }
+
+		sleep := limiter.waitSleep(context.Background(), 200*time.Millisecond)
+		if sleep.Round(time.Millisecond) != want[i] {
+			t.Errorf("expected sample %v to sleep %v, got %v", sample, want[i], sleep)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 210*time.Millisecond)
+	defer cancel()
+
+	if sleep := limiter.waitSleep(ctx, 200*time.Millisecond); sleep > 210*time.Millisecond {
+		t.Errorf("expected jitter never to sleep past the deadline, got %v", sleep)
+	}
+}
+
+func TestSetLimits_Redis(t *testing.T) {
+	mr, client := setupMiniRedis(t)
+	mr.SetTime(time.Now())
+	limiter := NewRedisLimiter(client, 5, 0, "ratelimit:", WithFailureMode(FailDegrade))
+
+	limiter.SetLimits(10, 1)
+
+	if config := limiter.Config(); config.Capacity != 10 || config.RefillRate != 1 {
+		t.Errorf("expected the config to reflect the new limits, got %+v", config)
+	}
+
+	if !limiter.Allow("Resized", 10) {
+		t.Error("expected the new capacity to be passed to the script")
+	}
+
+	if config := limiter.localLimiter.Config(); config.Capacity != 10 {
+		t.Errorf("expected the degrade limiter to be resized, got %+v", config)
+	}
+}
+
+func TestSetLimits_RedisWakesWaiters(t *testing.T) {
+	_, client := setupMiniRedis(t)
+	limiter := NewRedisLimiter(client, 1, 0.1, "ratelimit:")
+	limiter.Allow("Woken", 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	time.AfterFunc(20*time.Millisecond, func() { limiter.SetLimits(1, 1000) })
+
+	result, err := limiter.WaitDetailed(ctx, "Woken", 1)
+	if err != nil {
+		t.Fatalf("expected the waiter to recompute against the new rate, got %v", err)
+	}
+
+	if result.Waited > time.Second {
+		t.Errorf("expected the wait to end soon after the rate change, got %v", result.Waited)
+	}
+}
+
+type recordHandler struct {
+	mu      sync.Mutex
+	records []slog.Record
+}
+
+func (h *recordHandler) Enabled(context.Context, slog.Level) bool { return true }
+func (h *recordHandler) WithAttrs([]slog.Attr) slog.Handler       { return h }
+func (h *recordHandler) WithGroup(string) slog.Handler            { return h }
+
+func (h *recordHandler) Handle(_ context.Context, r slog.Record) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.records = append(h.records, r)
+	return nil
+}
+
+func (h *recordHandler) attrs(i int) map[string]slog.Value {
+	attrs := make(map[string]slog.Value)
+	h.records[i].Attrs(func(a slog.Attr) bool {
+		attrs[a.Key] = a.Value
+		return true
+	})
+	return attrs
+}
+
+func TestDecisionLog_SamplesDecisions(t *testing.T) {
+	_, client := setupMiniRedis(t)
+	handler := &recordHandler{}
+	limiter := NewRedisLimiter(client, 1e6, 0, "ratelimit:", WithDecisionLog(handler, 0.2))
+	limiter.decisionSample = rand.New(rand.NewPCG(1, 2)).Float64
+
+	for range 1000 {
+		limiter.Allow("Logged", 1)
+	}
+
+	if len(handler.records) < 150 || len(handler.records) > 250 {
+		t.Errorf("expected roughly 200 sampled records, got %d", len(handler.records))
+	}
+
+	attrs := handler.attrs(0)
+	if attrs["key"].String() != "Logged" || attrs["cost"].Float64() != 1 || !attrs["allowed"].Bool() ||
+		attrs["reason"].String() != "bucket" {
+		t.Errorf("unexpected decision record %v", attrs)
+	}
+
+	if _, ok := attrs["remaining"]; !ok {
+		t.Error("expected the record to include the remaining tokens")
+	}
+}
+
+func TestDecisionLog_AllDenies(t *testing.T) {
+	mr, client := setupMiniRedis(t)
+	handler := &recordHandler{}
+	limiter := NewRedisLimiter(client, 5, 0, "ratelimit:",
+		WithFailureMode(FailClosed),
+		WithDecisionLog(handler, 0),
+		WithDecisionLogAllDenies(),
+	)
+
+	limiter.Allow("Logged", 5)
+	limiter.Allow("Logged", 1)
+
+	mr.SetError("LOADING Redis is loading the dataset in memory")
+	limiter.Allow("Logged", 1)
+
+	if len(handler.records) != 2 {
+		t.Fatalf("expected only the 2 denies to be logged, got %d", len(handler.records))
+	}
+
+	if attrs := handler.attrs(0); attrs["allowed"].Bool() || attrs["remaining"].Float64() != 0 {
+		t.Errorf("expected a deny with no tokens remaining, got %v", attrs)
+	}
+
+	if attrs := handler.attrs(1); attrs["reason"].String() != "redis_error" {
+		t.Errorf("expected the failover to be explained, got %v", attrs)
+	}
+}
+
+func TestRedisLimiter_UniversalClient(t *testing.T) {
+	mr, _ := setupMiniRedis(t)
+	var client redis.UniversalClient = redis.NewUniversalClient(&redis.UniversalOptions{
+		Addrs: []string{mr.Addr()},
+	})
+	defer client.Close()
+
+	limiter := NewRedisLimiter(client, 5, 0, "ratelimit:")
+
+	if !limiter.Allow("Universal", 5) || limiter.Allow("Universal", 1) {
+		t.Error("expected the limiter to enforce capacity through a UniversalClient")
+	}
+}
+
+func TestRedisLimiter_ClusterClient(t *testing.T) {
+	mr, _ := setupMiniRedis(t)
+	client := redis.NewClusterClient(&redis.ClusterOptions{
+		Addrs:      []string{mr.Addr()},
+		MaxRetries: -1,
+	})
+	defer client.Close()
+
+	limiter := NewRedisLimiter(client, 5, 0, "ratelimit:", WithFailureMode(FailClosed))
+
+	if !limiter.Allow("{tenant-1}:user-1", 5) {
+		t.Fatal("expected EVALSHA to be routed through the cluster client")
+	}
+
+	if limiter.Allow("{tenant-1}:user-1", 1) {
+		t.Error("expected the cluster-routed bucket to be exhausted")
+	}
+
+	if !mr.Exists("ratelimit:{tenant-1}:user-1") {
+		t.Error("expected the bucket to be stored under the hash-tagged key")
+	}
+
+	if limiter.ShardFor("{tenant-1}:user-1") != limiter.ShardFor("{tenant-1}:user-2") {
+		t.Error("expected keys sharing a hash tag to share a slot")
+	}
+}
+
+func TestFailDegrade_Scale(t *testing.T) {
+	client := redis.NewClient(&redis.Options{
+		Addr:       "localhost:9999",
+		MaxRetries: -1,
+	})
+	limiter := NewRedisLimiter(client, 10, 0, "ratelimit:",
+		WithFailureMode(FailDegrade),
+		WithDegradeScale(0.2),
+	)
+
+	if !limiter.Allow("Scaled", 2) {
+		t.Error("expected allow within the scaled local capacity")
+	}
+
+	if limiter.Allow("Scaled", 1) {
+		t.Error("expected the degraded limiter to enforce the scaled capacity")
+	}
+}
+
+func TestAllowClassed_PerClassFailureMode(t *testing.T) {
+	mr, client := setupMiniRedis(t)
+	limiter := NewRedisLimiter(client, 10, 0, "ratelimit:",
+		WithFailureMode(FailClosed),
+		WithClassFailureMode("paid", FailOpen),
+		WithClassFailureMode("trial", FailDegrade),
+	)
+
+	mr.SetError("LOADING Redis is loading the dataset in memory")
+
+	if !limiter.AllowClassed("Classed", 1, "paid") {
+		t.Error("expected paid traffic to fail open")
+	}
+
+	if limiter.AllowClassed("Classed", 1, "free") {
+		t.Error("expected an unconfigured class to use the limiter's fail closed mode")
+	}
+
+	if !limiter.AllowClassed("Classed", 10, "trial") || limiter.AllowClassed("Classed", 1, "trial") {
+		t.Error("expected trial traffic to be limited locally")
+	}
+
+	mr.SetError("")
+
+	if !limiter.AllowClassed("Classed", 1, "free") {
+		t.Error("expected class failure modes not to apply while redis is up")
+	}
+}
+
+func TestAllowWithRetry_Redis(t *testing.T) {
+	mr, client := setupMiniRedis(t)
+	mr.SetTime(time.Now())
+	limiter := NewRedisLimiter(client, 5, 2, "ratelimit:")
+
+	if allowed, retry := limiter.AllowWithRetry("Retry", 5); !allowed || retry != 0 {
+		t.Errorf("expected allow with no retry, got %v, %v", allowed, retry)
+	}
+
+	if allowed, retry := limiter.AllowWithRetry("Retry", 3); allowed || retry != 1500*time.Millisecond {
+		t.Errorf("expected deny with 1.5s retry, got %v, %v", allowed, retry)
+	}
+
+	if allowed, retry := limiter.AllowWithRetry("Retry", 6); allowed || retry != NeverAvailable {
+		t.Errorf("expected deny with NeverAvailable above capacity, got %v, %v", allowed, retry)
+	}
+}
+
+// roundTripCounter is a go-redis hook counting single commands and pipelines.
+type roundTripCounter struct {
+	commands  int
+	pipelines int
+}
+
+func (c *roundTripCounter) DialHook(next redis.DialHook) redis.DialHook { return next }
+
+func (c *roundTripCounter) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		c.commands++
+		return next(ctx, cmd)
+	}
+}
+
+func (c *roundTripCounter) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		c.pipelines++
+		return next(ctx, cmds)
+	}
+}
+
+func TestAllowMulti_PerKeyResultsInOrder(t *testing.T) {
+	_, client := setupMiniRedis(t)
+	limiter := NewRedisLimiter(client, 5, 0, "ratelimit:")
+
+	limiter.Allow("ip", 5)
+	trips := &roundTripCounter{}
+	client.AddHook(trips)
+
+	allowed, err := limiter.AllowMulti([]string{"user", "ip", "tenant", "user"}, []int{3, 1, -1, 3})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := []bool{true, false, false, false}
+	for i := range want {
+		if allowed[i] != want[i] {
+			t.Errorf("key %d: expected %v, got %v", i, want[i], allowed[i])
+		}
+	}
+
+	if trips.commands != 0 || trips.pipelines != 1 {
+		t.Errorf("expected one pipelined round trip, got %d commands and %d pipelines", trips.commands, trips.pipelines)
+	}
+
+	if !limiter.Allow("user", 2) {
+		t.Error("expected denied keys in the batch not to consume tokens")
+	}
+}
+
+func TestAllowMulti_LoadsScript(t *testing.T) {
+	_, client := setupMiniRedis(t)
+	limiter := NewRedisLimiter(client, 5, 0, "ratelimit:")
+	client.ScriptFlush(context.Background())
+
+	allowed, err := limiter.AllowMulti([]string{"a", "b"}, []int{1, 6})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !allowed[0] || allowed[1] {
+		t.Errorf("expected [true false], got %v", allowed)
+	}
+}
+
+func TestAllowMulti_FailureMode(t *testing.T) {
+	client := redis.NewClient(&redis.Options{Addr: "localhost:9999", MaxRetries: -1})
+	limiter := NewRedisLimiter(client, 5, 1, "ratelimit:", WithFailureMode(FailOpen))
+
+	allowed, err := limiter.AllowMulti([]string{"a", "b"}, []int{1, 1})
+	if err == nil {
+		t.Error("expected the Redis error to be returned")
+	}
+
+	if !allowed[0] || !allowed[1] {
+		t.Errorf("expected fail open for every key, got %v", allowed)
+	}
+}
+
+func TestAllowMulti_OneBreakerOutcomePerRoundTrip(t *testing.T) {
+	mr, client := setupMiniRedis(t)
+	clock := &MockClock{current: time.Now()}
+	limiter := NewRedisLimiter(client, 5, 1, "ratelimit:",
+		WithCircuitBreaker(3, time.Second, WithSuccessThreshold(3)),
+		WithClock(clock),
+		WithErrorClassifier(func(err error) ErrorClass { return Transient }),
+	)
+	keys := []string{"a", "b", "c"}
+	tokens := []int{1, 1, 1}
+
+	mr.SetError("LOADING Redis is loading the dataset in memory")
+	limiter.AllowMulti(keys, tokens)
+	if state := limiter.CircuitState(); state != CircuitClosed {
+		t.Fatalf("expected one failed pipeline to count once, got %v", state)
+	}
+
+	limiter.AllowMulti(keys, tokens)
+	limiter.AllowMulti(keys, tokens)
+	if state := limiter.CircuitState(); state != CircuitOpen {
+		t.Fatalf("expected three failed pipelines to trip the breaker, got %v", state)
+	}
+
+	mr.SetError("")
+	clock.Advance(2 * time.Second)
+	limiter.AllowMulti(keys, tokens)
+	if state := limiter.CircuitState(); state != CircuitHalfOpen {
+		t.Errorf("expected one successful pipeline to count as one trial success, got %v", state)
+	}
+}
+
+// scriptError is a Redis error reply, as redis.HasErrorPrefix expects.
+type scriptError string
+
+func (e scriptError) Error() string { return string(e) }
+
+func (scriptError) RedisError() {}
+
+// scriptLoss answers the first EVALSHA for key with NOSCRIPT without sending
+// it, as a replica that never loaded the script would.
+type scriptLoss struct {
+	key  string
+	lost bool
+}
+
+func (s *scriptLoss) DialHook(next redis.DialHook) redis.DialHook { return next }
+
+func (s *scriptLoss) ProcessHook(next redis.ProcessHook) redis.ProcessHook { return next }
+
+func (s *scriptLoss) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		var sent []redis.Cmder
+		for _, cmd := range cmds {
+			args := cmd.Args()
+			if !s.lost && cmd.Name() == "evalsha" && len(args) > 3 && args[3] == s.key {
+				s.lost = true
+				cmd.SetErr(scriptError("NOSCRIPT No matching script. Please use EVAL."))
+				continue
+			}
+			sent = append(sent, cmd)
+		}
+		return next(ctx, sent)
+	}
+}
+
+func TestAllowMulti_RetriesOnlyKeysMissingScript(t *testing.T) {
+	_, client := setupMiniRedis(t)
+	limiter := NewRedisLimiter(client, 5, 0, "ratelimit:")
+	limiter.Allow("warm", 1)
+	client.AddHook(&scriptLoss{key: "ratelimit:b"})
+
+	allowed, err := limiter.AllowMulti([]string{"a", "b"}, []int{2, 2})
+	if err != nil || !allowed[0] || !allowed[1] {
+		t.Fatalf("expected both keys to be allowed, got %v, %v", allowed, err)
+	}
+
+	for _, key := range []string{"a", "b"} {
+		if remaining, _ := limiter.Remaining(key); remaining != 3 {
+			t.Errorf("expected %s to be charged once, got %f remaining", key, remaining)
+		}
+	}
+}
+
+func TestAllowMulti_LengthMismatch(t *testing.T) {
+	_, client := setupMiniRedis(t)
+	limiter := NewRedisLimiter(client, 5, 1, "ratelimit:")
+
+	if _, err := limiter.AllowMulti([]string{"a", "b"}, []int{1}); err != ErrKeyTokenMismatch {
+		t.Errorf("expected ErrKeyTokenMismatch, got %v", err)
+	}
+}
+
+func TestAllowCtx_CancelledSkipsRedis(t *testing.T) {
+	_, client := setupMiniRedis(t)
+	limiter := NewRedisLimiter(client, 5, 1, "ratelimit:")
+	trips := &roundTripCounter{}
+	client.AddHook(trips)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	allowed, err := limiter.AllowCtx(ctx, "key", 1)
+	if allowed || err != context.Canceled {
+		t.Errorf("expected denial with context.Canceled, got %v, %v", allowed, err)
+	}
+
+	if trips.commands != 0 {
+		t.Errorf("expected no Redis round trip, got %d commands", trips.commands)
+	}
+}
+
+// blockingHook holds every command until its context is done.
+type blockingHook struct{}
+
+func (blockingHook) DialHook(next redis.DialHook) redis.DialHook { return next }
+
+func (blockingHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+}
+
+func (blockingHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return next
+}
+
+func TestAllowCtx_DeadlineDoesNotTripBreaker(t *testing.T) {
+	_, client := setupMiniRedis(t)
+	client.AddHook(blockingHook{})
+	limiter := NewRedisLimiter(client, 5, 1, "ratelimit:",
+		WithFailureMode(FailOpen),
+		WithCircuitBreaker(1, time.Minute))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	allowed, err := limiter.AllowCtx(ctx, "key", 1)
+	if allowed || err != context.DeadlineExceeded {
+		t.Errorf("expected denial with context.DeadlineExceeded, got %v, %v", allowed, err)
+	}
+
+	if state := limiter.circuitBreaker.Snapshot().State; state != CircuitClosed {
+		t.Errorf("expected the breaker to stay closed, got %v", state)
+	}
+}
+
+func TestAllowCtx_ReturnsRedisError(t *testing.T) {
+	client := redis.NewClient(&redis.Options{Addr: "localhost:9999", MaxRetries: -1})
+	limiter := NewRedisLimiter(client, 5, 1, "ratelimit:", WithFailureMode(FailOpen))
+
+	allowed, err := limiter.AllowCtx(context.Background(), "key", 1)
+	if !allowed {
+		t.Error("expected the failure mode to allow the request")
+	}
+
+	if err == nil {
+		t.Error("expected the Redis error to be returned")
+	}
+}
+
+func TestAllowCtx_Decides(t *testing.T) {
+	_, client := setupMiniRedis(t)
+	limiter := NewRedisLimiter(client, 1, 0, "ratelimit:")
+
+	if allowed, err := limiter.AllowCtx(context.Background(), "key", 1); !allowed || err != nil {
+		t.Errorf("expected first request to be allowed, got %v, %v", allowed, err)
+	}
+
+	if allowed, err := limiter.AllowCtx(context.Background(), "key", 1); allowed || err != nil {
+		t.Errorf("expected second request to be denied without error, got %v, %v", allowed, err)
+	}
+}
+
+func TestAllowE_ReportsErrorAlongsideFailureMode(t *testing.T) {
+	mr, client := setupMiniRedis(t)
+	limiter := NewRedisLimiter(client, 1, 0, "ratelimit:", WithFailureMode(FailClosed))
+
+	if allowed, err := limiter.AllowE("key", 1); !allowed || err != nil {
+		t.Errorf("expected allowed without error, got %v, %v", allowed, err)
+	}
+
+	if allowed, err := limiter.AllowE("key", 1); allowed || err != nil {
+		t.Errorf("expected a plain denial, got %v, %v", allowed, err)
+	}
+
+	mr.SetError("LOADING Redis is loading the dataset in memory")
+
+	if allowed, err := limiter.AllowE("key", 1); allowed || err == nil {
+		t.Errorf("expected FailClosed to deny with the Redis error, got %v, %v", allowed, err)
+	}
+
+	if _, err := limiter.AllowE("key", -1); err != ErrNegativeTokens {
+		t.Errorf("expected ErrNegativeTokens, got %v", err)
+	}
+}
+
+func TestAllowE_CircuitOpen(t *testing.T) {
+	client := redis.NewClient(&redis.Options{Addr: "localhost:9999", MaxRetries: -1})
+	limiter := NewRedisLimiter(client, 5, 1, "ratelimit:",
+		WithFailureMode(FailOpen),
+		WithCircuitBreaker(1, time.Minute))
+
+	limiter.AllowE("key", 1)
+
+	allowed, err := limiter.AllowE("key", 1)
+	if !allowed || err != ErrCircuitOpen {
+		t.Errorf("expected fail open with ErrCircuitOpen, got %v, %v", allowed, err)
+	}
+}
+
+func TestFailoverStats_CountsOutage(t *testing.T) {
+	mr, client := setupMiniRedis(t)
+	limiter := NewRedisLimiter(client, 5, 1, "ratelimit:",
+		WithFailureMode(FailDegrade),
+		WithClassFailureMode("critical", FailOpen),
+		WithClassFailureMode("bulk", FailClosed))
+
+	limiter.Allow("key", 1)
+	limiter.Allow("key", 1)
+
+	mr.SetError("LOADING Redis is loading the dataset in memory")
+
+	limiter.Allow("key", 1)
+	limiter.Allow("key", 1)
+	limiter.Allow("key", 1)
+	limiter.AllowClassed("key", 1, "critical")
+	limiter.AllowClassed("key", 1, "bulk")
+	limiter.AllowClassed("key", 1, "bulk")
+
+	want := FailoverStats{RedisServed: 2, FailedOpen: 1, FailedClosed: 2, Degraded: 3}
+	if got := limiter.FailoverStats(); got != want {
+		t.Errorf("expected %+v, got %+v", want, got)
+	}
+}
+
+type circuitMetrics struct {
+	MockMetrics
+	transitions []string
+}
+
+func (m *circuitMetrics) OnCircuitStateChange(from, to CircuitState) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.transitions = append(m.transitions, from.String()+"->"+to.String())
+}
+
+func TestCircuitState_ReportsTransitions(t *testing.T) {
+	mr, client := setupMiniRedis(t)
+	metrics := &circuitMetrics{}
+	limiter := NewRedisLimiter(client, 5, 1, "ratelimit:",
+		WithMetrics(metrics),
+		WithCircuitBreaker(1, 10*time.Millisecond))
+
+	if limiter.CircuitState() != CircuitClosed {
+		t.Fatalf("expected a closed breaker, got %v", limiter.CircuitState())
+	}
+
+	mr.SetError("LOADING Redis is loading the dataset in memory")
+	limiter.Allow("key", 1)
+
+	if limiter.CircuitState() != CircuitOpen {
+		t.Fatalf("expected an open breaker, got %v", limiter.CircuitState())
+	}
+
+	mr.SetError("")
+	time.Sleep(20 * time.Millisecond)
+	limiter.Allow("key", 1)
+
+	want := []string{"closed->open", "open->half-open", "half-open->closed"}
+	if !slices.Equal(metrics.transitions, want) {
+		t.Errorf("expected transitions %v, got %v", want, metrics.transitions)
+	}
+}
+
+func TestMetrics_OnConsumeSumsAllowedCost(t *testing.T) {
+	_, client := setupMiniRedis(t)
+	metrics := &MockMetrics{}
+	limiter := NewRedisLimiter(client, 10, 0, "ratelimit:", WithMetrics(metrics))
+
+	limiter.Allow("key", 1)
+	limiter.Allow("key", 3)
+	limiter.Allow("key", 5)
+	limiter.Allow("key", 5)
+
+	if got := metrics.consumed["key"]; got != 9 {
+		t.Errorf("expected 9 tokens consumed by allowed requests, got %d", got)
+	}
+}
+
+func TestReset_DeletesRedisKey(t *testing.T) {
+	mr, client := setupMiniRedis(t)
+	limiter := NewRedisLimiter(client, 5, 0, "ratelimit:")
+
+	limiter.Allow("key", 5)
+
+	if err := limiter.Reset("key"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if mr.Exists("ratelimit:key") {
+		t.Error("expected the bucket key to be deleted")
+	}
+
+	if !limiter.Allow("key", 5) {
+		t.Error("expected the reset key to start full")
+	}
+}
+
+func TestAddTokens_CapsAtCapacityRedis(t *testing.T) {
+	_, client := setupMiniRedis(t)
+	limiter := NewRedisLimiter(client, 10, 0, "ratelimit:")
+
+	limiter.Allow("key", 8)
+
+	if got, err := limiter.AddTokens("key", 5); err != nil || got != 7 {
+		t.Errorf("expected 7 tokens after the credit, got %v, %v", got, err)
+	}
+
+	if got, _ := limiter.AddTokens("key", 50); got != 10 {
+		t.Errorf("expected the credit to cap at capacity, got %v", got)
+	}
+
+	if got, _ := limiter.SetTokens("key", -1); got != 0 {
+		t.Errorf("expected SetTokens never to go negative, got %v", got)
+	}
+
+	if got, _ := limiter.SetTokens("key", 2.5); got != 2.5 {
+		t.Errorf("expected 2.5 tokens, got %v", got)
+	}
+
+	if limiter.Allow("key", 3) {
+		t.Error("expected the set level to be enforced")
+	}
+}
+
+func TestKeyTTL_CoversRefillTime(t *testing.T) {
+	mr, client := setupMiniRedis(t)
+	limiter := NewRedisLimiter(client, 10, 1, "ratelimit:")
+
+	limiter.Allow("key", 4)
+
+	if ttl := mr.TTL("ratelimit:key"); ttl < 3900*time.Millisecond || ttl > 4*time.Second {
+		t.Errorf("expected the key to expire once refilled, about 4s, got %v", ttl)
+	}
+
+	withTTL := NewRedisLimiter(client, 10, 1, "ratelimit:", WithKeyTTL(time.Hour))
+	withTTL.Allow("key", 1)
+
+	if ttl := mr.TTL("ratelimit:key"); ttl != time.Hour {
+		t.Errorf("expected the configured TTL when it's longer, got %v", ttl)
+	}
+
+	withTTL.Allow("key", 1)
+	limiter.Allow("key", 1)
+
+	if ttl := mr.TTL("ratelimit:key"); ttl > 10*time.Second {
+		t.Errorf("expected the TTL to follow the limiter's configuration, got %v", ttl)
+	}
+}
+
+func TestKeyTTL_ZeroRate(t *testing.T) {
+	mr, client := setupMiniRedis(t)
+
+	NewRedisLimiter(client, 10, 0, "ratelimit:").Allow("key", 1)
+
+	if ttl := mr.TTL("ratelimit:key"); ttl != 0 {
+		t.Errorf("expected a bucket that never refills not to expire, got %v", ttl)
+	}
+
+	NewRedisLimiter(client, 10, 0, "ratelimit:", WithKeyTTL(time.Minute)).Allow("key", 1)
+
+	if ttl := mr.TTL("ratelimit:key"); ttl != time.Minute {
+		t.Errorf("expected the configured TTL, got %v", ttl)
+	}
+}
+
+type recordingLogger struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *recordingLogger) record(level, format string, args ...any) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, level+" "+fmt.Sprintf(format, args...))
+}
+
+func (l *recordingLogger) Debugf(format string, args ...any) { l.record("DEBUG", format, args...) }
+func (l *recordingLogger) Warnf(format string, args ...any)  { l.record("WARN", format, args...) }
+func (l *recordingLogger) Errorf(format string, args ...any) { l.record("ERROR", format, args...) }
+
+func TestRedisLimiter_WithLogger(t *testing.T) {
+	mr, client := setupMiniRedis(t)
+	logger := &recordingLogger{}
+	limiter := NewRedisLimiter(client, 5, 1, "ratelimit:",
+		WithLogger(logger),
+		WithFailureMode(FailClosed),
+		WithCircuitBreaker(1, time.Minute))
+
+	mr.SetError("LOADING Redis is loading the dataset in memory")
+	limiter.Allow("key", 1)
+	limiter.Allow("key", 1)
+
+	want := []string{
+		`ERROR ratelimit: redis error for key "key": LOADING Redis is loading the dataset in memory`,
+		"WARN ratelimit: circuit breaker closed -> open",
+		`DEBUG ratelimit: redis error, failed over for key "key": allowed=false`,
+		`DEBUG ratelimit: circuit open, failed over for key "key": allowed=false`,
+	}
+	if !slices.Equal(logger.lines, want) {
+		t.Errorf("expected log lines %q, got %q", want, logger.lines)
+	}
+}
+
+func TestRedisLimiter_WithKeyNamespaceFunc(t *testing.T) {
+	mr, client := setupMiniRedis(t)
+	limiter := NewRedisLimiter(client, 5, 1, "ratelimit:",
+		WithKeyNamespaceFunc(func(key string) string {
+			tenant, user, _ := strings.Cut(key, "/")
+			return tenant + ":ratelimit:" + user
+		}))
+
+	limiter.Allow("tenant123/user-1", 2)
+
+	if !mr.Exists("tenant123:ratelimit:user-1") || mr.Exists("ratelimit:tenant123/user-1") {
+		t.Fatalf("expected the namespaced key to be used, got keys %v", mr.Keys())
+	}
+
+	if limiter.ShardFor("tenant123/user-1") != keySlot("tenant123:ratelimit:user-1") {
+		t.Error("expected ShardFor to use the namespaced key")
+	}
+
+	if err := limiter.Reset("tenant123/user-1"); err != nil || mr.Exists("tenant123:ratelimit:user-1") {
+		t.Errorf("expected Reset to delete the namespaced key, got %v", err)
+	}
+}
+
+func TestRedisLimiter_ServerClockMovesBackward(t *testing.T) {
+	mr, client := setupMiniRedis(t)
+	limiter := NewRedisLimiter(client, 5, 1, "ratelimit:")
+	start := time.Now()
+
+	mr.SetTime(start)
+	limiter.Allow("key", 5)
+
+	mr.SetTime(start.Add(-time.Minute))
+	if remaining, _ := limiter.Remaining("key"); remaining != 0 {
+		t.Fatalf("expected a backward jump to leave the bucket empty, got %f", remaining)
+	}
+	limiter.Allow("key", 0)
+
+	mr.SetTime(start.Add(-time.Minute + time.Second))
+	if !limiter.Allow("key", 1) {
+		t.Error("expected refills to resume from the jumped-back time")
+	}
+}
+
+func TestRedisLimiter_InstancesShareServerClock(t *testing.T) {
+	mr, client := setupMiniRedis(t)
+	start := time.Now().Add(-time.Hour)
+	mr.SetTime(start)
+
+	// The server's clock is an hour off the test's, as an app server's might
+	// be from Redis; refills follow the server alone.
+	a := NewRedisLimiter(client, 10, 2, "ratelimit:")
+	b := NewRedisLimiter(client, 10, 2, "ratelimit:")
+
+	a.Allow("shared", 8)
+	mr.SetTime(start.Add(time.Second))
+
+	remainingA, _ := a.Remaining("shared")
+	remainingB, _ := b.Remaining("shared")
+	if remainingA != 4 || remainingB != 4 {
+		t.Fatalf("expected both instances to see 4 tokens, got %f and %f", remainingA, remainingB)
+	}
+
+	b.Allow("shared", 4)
+	if a.Allow("shared", 1) {
+		t.Error("expected a to see b's consumption")
+	}
+}
+
+func TestRedisLimiter_CheckOneRoundTrip(t *testing.T) {
+	_, client := setupMiniRedis(t)
+	limiter := NewRedisLimiter(client, 5, 2, "ratelimit:")
+	limiter.Allow("Check", 0)
+	trips := &roundTripCounter{}
+	client.AddHook(trips)
+
+	res, err := limiter.Check("Check", 4)
+	want := Result{Allowed: true, Remaining: 1, Limit: 5, ResetAfter: 2 * time.Second}
+	if err != nil || res.Allowed != want.Allowed || res.Limit != want.Limit ||
+		math.Abs(res.Remaining-want.Remaining) > 0.01 || math.Abs(float64(res.ResetAfter-want.ResetAfter)) > float64(10*time.Millisecond) {
+		t.Errorf("expected about %+v, got %+v, %v", want, res, err)
+	}
+
+	if trips.commands != 1 || trips.pipelines != 0 {
+		t.Errorf("expected one round trip, got %d commands and %d pipelines", trips.commands, trips.pipelines)
+	}
+
+	res, _ = limiter.Check("Check", 3)
+	if res.Allowed || res.RetryAfter <= 0 {
+		t.Errorf("expected a denial with a retry duration, got %+v", res)
+	}
+}
+
+func TestRedisLimiter_CheckFailover(t *testing.T) {
+	mr, client := setupMiniRedis(t)
+	limiter := NewRedisLimiter(client, 5, 2, "ratelimit:")
+
+	mr.SetError("LOADING Redis is loading the dataset in memory")
+
+	res, err := limiter.Check("Check", 1)
+	if err == nil || !res.Allowed || res.Limit != 5 {
+		t.Errorf("expected a fail open with the limit and error, got %+v, %v", res, err)
+	}
+}
+
+func TestRedisLimiter_CloseDeniesLaterCalls(t *testing.T) {
+	_, client := setupMiniRedis(t)
+	limiter := NewRedisLimiter(client, 5, 1, "ratelimit:", WithFailureMode(FailDegrade))
+
+	if !limiter.Allow("user1", 1) {
+		t.Fatal("expected allow before Close")
+	}
+
+	if err := limiter.Close(); err != nil {
+		t.Fatalf("expected no error from Close, got %v", err)
+	}
+	if err := limiter.Close(); err != nil {
+		t.Fatalf("expected a second Close to be a no-op, got %v", err)
+	}
+
+	if limiter.Allow("user1", 1) {
+		t.Error("expected Allow to deny after Close")
+	}
+	if _, err := limiter.AllowE("user1", 1); !errors.Is(err, ErrLimiterClosed) {
+		t.Errorf("expected ErrLimiterClosed from AllowE, got %v", err)
+	}
+	if _, err := limiter.Check("user1", 1); !errors.Is(err, ErrLimiterClosed) {
+		t.Errorf("expected ErrLimiterClosed from Check, got %v", err)
+	}
+	if _, err := limiter.AllowMulti([]string{"user1"}, []int{1}); !errors.Is(err, ErrLimiterClosed) {
+		t.Errorf("expected ErrLimiterClosed from AllowMulti, got %v", err)
+	}
+	if err := limiter.Wait(context.Background(), "user1", 1); !errors.Is(err, ErrLimiterClosed) {
+		t.Errorf("expected ErrLimiterClosed from Wait, got %v", err)
+	}
+}
+
+func TestRedisLimiter_CloseWakesWaiters(t *testing.T) {
+	_, client := setupMiniRedis(t)
+	limiter := NewRedisLimiter(client, 1, 0.001, "ratelimit:", WithFailureMode(FailDegrade))
+	limiter.Allow("user1", 1)
+
+	// The server and client goroutines are the test's, not the limiter's.
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	done := make(chan error)
+	go func() {
+		done <- limiter.Wait(context.Background(), "user1", 1)
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	limiter.Close()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrLimiterClosed) {
+			t.Errorf("expected ErrLimiterClosed, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected Close to wake the blocked Wait")
+	}
+}
+
+func TestRedisLimiter_CloseDeniesInShadowMode(t *testing.T) {
+	_, client := setupMiniRedis(t)
+	limiter := NewRedisLimiter(client, 5, 1, "ratelimit:", WithShadowMode(true))
+	limiter.Close()
+
+	if limiter.Allow("user1", 1) {
+		t.Error("expected Allow to deny after Close in shadow mode")
+	}
+	if allowed, err := limiter.AllowE("user1", 1); allowed || !errors.Is(err, ErrLimiterClosed) {
+		t.Errorf("expected a denial with ErrLimiterClosed, got %v, %v", allowed, err)
+	}
+}
+
+func TestRedisLimiter_ShadowModeAllowsAndRecordsShadowDenies(t *testing.T) {
+	_, client := setupMiniRedis(t)
+	metrics := &shadowMetrics{}
+	limiter := NewRedisLimiter(client, 2, 0, "ratelimit:", WithMetrics(metrics), WithShadowMode(true))
+
+	for i := 0; i < 3; i++ {
+		if !limiter.Allow("user1", 1) {
+			t.Fatalf("expected request %d to be allowed in shadow mode", i+1)
+		}
+	}
+
+	if len(metrics.denies) != 0 {
+		t.Errorf("expected no real denies, got %v", metrics.denies)
+	}
+	if len(metrics.shadowDenies) != 1 || metrics.shadowDenies[0] != "user1" {
+		t.Errorf("expected one shadow deny for user1, got %v", metrics.shadowDenies)
+	}
+
+	res, err := limiter.Check("user1", 1)
+	if err != nil || !res.Allowed || res.Remaining != 0 || res.RetryAfter != 0 {
+		t.Errorf("expected a shadow allow reporting the empty bucket, got %+v, %v", res, err)
+	}
+
+	if err := limiter.Wait(context.Background(), "user1", 1); err != nil {
+		t.Errorf("expected Wait to return at once in shadow mode, got %v", err)
+	}
+
+	if !limiter.Config().Shadow {
+		t.Error("expected Config to report shadow mode")
+	}
+}
+
+func TestRedisLimiter_ShadowModeFallsBackToOnDeny(t *testing.T) {
+	client := redis.NewClient(&redis.Options{Addr: "localhost:9999", MaxRetries: -1})
+	defer client.Close()
+
+	metrics := &MockMetrics{}
+	limiter := NewRedisLimiter(client, 2, 1, "ratelimit:", WithMetrics(metrics), WithFailureMode(FailClosed), WithShadowMode(true))
+
+	if !limiter.Allow("user1", 1) {
+		t.Error("expected a FailClosed denial to be allowed in shadow mode")
+	}
+	if len(metrics.denies) != 1 {
+		t.Errorf("expected the would-be deny on OnDeny, got %v", metrics.denies)
+	}
+}
+
+func TestRedisLimiter_SharedCircuitBreakerTripsTogether(t *testing.T) {
+	mr, client := setupMiniRedis(t)
+	clock := &MockClock{current: time.Now()}
+	cb := NewCircuitBreaker(2, time.Second, clock)
+
+	users := NewRedisLimiter(client, 5, 1, "users:", WithSharedCircuitBreaker(cb), WithFailureMode(FailClosed))
+	orgs := NewRedisLimiter(client, 5, 1, "orgs:", WithSharedCircuitBreaker(cb), WithFailureMode(FailClosed))
+
+	mr.SetError("LOADING Redis is loading the dataset in memory")
+	users.Allow("user1", 1)
+	orgs.Allow("org1", 1)
+
+	if users.CircuitState() != CircuitOpen || orgs.CircuitState() != CircuitOpen {
+		t.Fatalf("expected failures from both limiters to trip the shared breaker, got %v and %v", users.CircuitState(), orgs.CircuitState())
+	}
+
+	mr.SetError("")
+	clock.Advance(time.Second)
+	if !users.Allow("user1", 1) {
+		t.Fatal("expected the half-open trial to reach Redis")
+	}
+
+	if orgs.CircuitState() != CircuitClosed {
+		t.Errorf("expected one limiter's recovery to close the breaker for both, got %v", orgs.CircuitState())
+	}
+	if !orgs.Allow("org1", 1) {
+		t.Error("expected the other limiter to allow once the breaker closed")
+	}
+}
+
+func TestRedisLimiter_WaitClamp(t *testing.T) {
+	_, client := setupMiniRedis(t)
+	limiter := NewRedisLimiter(client, 2, 100, "ratelimit:")
+
+	if err := limiter.Wait(context.Background(), "user1", 3); err != ErrExceedsCapacity {
+		t.Fatalf("expected Wait to reject more than the capacity, got %v", err)
+	}
+
+	taken, err := limiter.WaitClamp(context.Background(), "user1", 3)
+	if err != nil || taken != 2 {
+		t.Errorf("expected the request clamped to 2 tokens, got %v, %v", taken, err)
+	}
+}
+
+// clockAdvancer is a hook that advances a MockClock by step on every command,
+// standing in for a round trip's duration.
+type clockAdvancer struct {
+	clock *MockClock
+	step  time.Duration
+}
+
+func (c *clockAdvancer) DialHook(next redis.DialHook) redis.DialHook { return next }
+
+func (c *clockAdvancer) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		c.clock.Advance(c.step)
+		return next(ctx, cmd)
+	}
+}
+
+func (c *clockAdvancer) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return next
+}
+
+func TestRedisLimiter_WithClockMeasuresLatency(t *testing.T) {
+	_, client := setupMiniRedis(t)
+	clock := &MockClock{current: time.Now()}
+	metrics := &MockMetrics{}
+	limiter := NewRedisLimiter(client, 5, 1, "ratelimit:", WithMetrics(metrics), WithClock(clock))
+
+	limiter.Allow("user1", 1)
+	client.AddHook(&clockAdvancer{clock: clock, step: 7 * time.Millisecond})
+	limiter.Allow("user1", 1)
+
+	if len(metrics.latencies) != 2 || metrics.latencies[1] != 7*time.Millisecond {
+		t.Errorf("expected the second latency to be exactly 7ms, got %v", metrics.latencies)
+	}
+}
+
+func TestRedisLimiter_WithClockDrivesCircuitBreaker(t *testing.T) {
+	mr, client := setupMiniRedis(t)
+	clock := &MockClock{current: time.Now()}
+	limiter := NewRedisLimiter(client, 5, 1, "ratelimit:", WithCircuitBreaker(1, time.Minute), WithClock(clock), WithFailureMode(FailClosed))
+
+	mr.SetError("LOADING Redis is loading the dataset in memory")
+	limiter.Allow("user1", 1)
+	mr.SetError("")
+
+	if limiter.Allow("user1", 1) {
+		t.Fatal("expected the open breaker to fail closed")
+	}
+
+	clock.Advance(time.Minute)
+	if !limiter.Allow("user1", 1) {
+		t.Error("expected the breaker to half-open once the mock clock passed its timeout")
+	}
+}
+
+func TestRedisLimiter_AllowPartial(t *testing.T) {
+	_, client := setupMiniRedis(t)
+	metrics := &MockMetrics{}
+	limiter := NewRedisLimiter(client, 5, 0, "ratelimit:", WithMetrics(metrics))
+
+	if granted := limiter.AllowPartial("user1", 3); granted != 3 {
+		t.Fatalf("expected the full request to be granted, got %d", granted)
+	}
+	if granted := limiter.AllowPartial("user1", 10); granted != 2 {
+		t.Fatalf("expected only the 2 tokens left to be granted, got %d", granted)
+	}
+	if granted := limiter.AllowPartial("user1", 1); granted != 0 {
+		t.Fatalf("expected a fully throttled key to grant nothing, got %d", granted)
+	}
+
+	if metrics.consumed["user1"] != 5 || len(metrics.denies) != 1 {
+		t.Errorf("expected 5 tokens consumed and one deny, got %v and %v", metrics.consumed, metrics.denies)
+	}
+}
+
+func TestRedisLimiter_AllowPartialFailureModes(t *testing.T) {
+	client := redis.NewClient(&redis.Options{Addr: "localhost:9999", MaxRetries: -1})
+	defer client.Close()
+
+	tests := []struct {
+		mode FailureMode
+		want int
+	}{
+		{FailOpen, 5},
+		{FailClosed, 0},
+		{FailDegrade, 5},
+	}
+
+	for _, tt := range tests {
+		limiter := NewRedisLimiter(client, 5, 0, "ratelimit:", WithFailureMode(tt.mode))
+		if granted := limiter.AllowPartial("user1", 8); granted != tt.want {
+			t.Errorf("mode %v: expected %d granted, got %d", tt.mode, tt.want, granted)
+		}
+	}
+}
+
+// cancelHook cancels the caller's context on the first command and fails it
+// with the context's error, as if the caller gave up mid round trip.
+type cancelHook struct {
+	cancel context.CancelFunc
+}
+
+func (c *cancelHook) DialHook(next redis.DialHook) redis.DialHook { return next }
+
+func (c *cancelHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		if c.cancel == nil {
+			return next(ctx, cmd)
+		}
+
+		c.cancel()
+		c.cancel = nil
+		return ctx.Err()
+	}
+}
+
+func (c *cancelHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return next
+}
+
+func TestRedisLimiter_HalfOpenTrialReleasedOnEveryExit(t *testing.T) {
+	wrongType := func(mr *miniredis.Miniredis) { mr.Set("ratelimit:user1", "not a hash") }
+
+	tests := []struct {
+		name     string
+		classify ErrorClass
+		trial    func(t *testing.T, mr *miniredis.Miniredis, client *redis.Client, l *RedisLimiter)
+	}{
+		{"ignored error", Ignore, func(t *testing.T, mr *miniredis.Miniredis, _ *redis.Client, l *RedisLimiter) {
+			wrongType(mr)
+			l.Allow("user1", 1)
+		}},
+		{"fatal error", Fatal, func(t *testing.T, mr *miniredis.Miniredis, _ *redis.Client, l *RedisLimiter) {
+			wrongType(mr)
+			l.Allow("user1", 1)
+		}},
+		{"partial error", Ignore, func(t *testing.T, mr *miniredis.Miniredis, _ *redis.Client, l *RedisLimiter) {
+			wrongType(mr)
+			l.AllowPartial("user1", 1)
+		}},
+		{"cancelled", Transient, func(t *testing.T, _ *miniredis.Miniredis, client *redis.Client, l *RedisLimiter) {
+			ctx, cancel := context.WithCancel(context.Background())
+			client.AddHook(&cancelHook{cancel: cancel})
+
+			if _, err := l.AllowCtx(ctx, "user1", 1); err != context.Canceled {
+				t.Fatalf("expected context.Canceled, got %v", err)
+			}
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mr, client := setupMiniRedis(t)
+			clock := &MockClock{current: time.Now()}
+			limiter := NewRedisLimiter(client, 5, 1, "ratelimit:",
+				WithCircuitBreaker(1, time.Second, WithHalfOpenMaxRequests(1)),
+				WithClock(clock),
+				WithFailureMode(FailClosed),
+				WithErrorClassifier(func(err error) ErrorClass {
+					if strings.HasPrefix(err.Error(), "LOADING") {
+						return Transient
+					}
+					return tt.classify
+				}))
+			limiter.Allow("warmup", 1)
+
+			mr.SetError("LOADING Redis is loading the dataset in memory")
+			limiter.Allow("user2", 1)
+			mr.SetError("")
+			clock.Advance(time.Second)
+
+			tt.trial(t, mr, client, limiter)
+
+			if !limiter.Allow("user2", 1) {
+				t.Fatalf("expected the released trial slot to let the next request reach Redis, breaker %v", limiter.CircuitState())
+			}
+			if limiter.CircuitState() != CircuitClosed {
+				t.Errorf("expected the breaker to close, got %v", limiter.CircuitState())
+			}
+		})
+	}
+}
+
+func TestDetectClockDrift_ReportsThroughSampledMetrics(t *testing.T) {
+	for name, opt := range map[string]Option{
+		"sampling":    WithMetricsSampling(0.01),
+		"deny detail": WithDenyDetailMetrics(0.01),
+	} {
+		t.Run(name, func(t *testing.T) {
+			mr, client := setupMiniRedis(t)
+			mr.SetTime(time.Now().Add(5 * time.Second))
+			metrics := &MockDriftMetrics{}
+			limiter := NewRedisLimiter(client, 5, 1, "ratelimit:", WithMetrics(metrics), opt)
+
+			if _, err := limiter.DetectClockDrift(context.Background()); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			if len(metrics.drifts) != 1 {
+				t.Errorf("expected the drift gauge to reach the wrapped metrics, got %v", metrics.drifts)
+			}
+		})
+	}
+}
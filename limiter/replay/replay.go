@@ -0,0 +1,282 @@
+// Package replay records the script calls a RedisLimiter makes against a real
+// Redis and replays them later without one, so Redis-dependent behavior can be
+// tested deterministically in CI.
+//
+// Both sides are go-redis hooks, so they work with a plain *redis.Client: a
+// Recorder is added to a client connected to Redis, and a Replayer hands out a
+// client that is answered from the cassette and never dials.
+package replay
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var ErrCassetteExhausted = errors.New("replay: no recorded interactions left")
+
+// Interaction is a single recorded script call and its reply.
+type Interaction struct {
+	Command string      `json:"command"`
+	Keys    []string    `json:"keys"`
+	Args    []string    `json:"args"`
+	Result  interface{} `json:"result,omitempty"`
+	Error   string      `json:"error,omitempty"`
+}
+
+// Recorder records every script call made through the client it was added to.
+// All other commands pass through unrecorded.
+type Recorder struct {
+	mu           sync.Mutex
+	interactions []Interaction
+}
+
+// NewRecorder adds a Recorder to client as a hook.
+func NewRecorder(client *redis.Client) *Recorder {
+	r := &Recorder{}
+	client.AddHook(r)
+	return r
+}
+
+func (r *Recorder) DialHook(next redis.DialHook) redis.DialHook { return next }
+
+func (r *Recorder) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		err := next(ctx, cmd)
+		r.record(cmd, err)
+		return err
+	}
+}
+
+func (r *Recorder) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		err := next(ctx, cmds)
+		for _, cmd := range cmds {
+			r.record(cmd, cmd.Err())
+		}
+		return err
+	}
+}
+
+func (r *Recorder) Interactions() []Interaction {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]Interaction(nil), r.interactions...)
+}
+
+// WriteCassette writes the recorded interactions to path as JSON.
+func (r *Recorder) WriteCassette(path string) error {
+	data, err := json.MarshalIndent(r.Interactions(), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// record saves cmd's reply. err is passed in because Client.Process only
+// copies the error onto cmd after the hooks return.
+func (r *Recorder) record(cmd redis.Cmder, err error) {
+	c, ok := cmd.(*redis.Cmd)
+	if !ok || !isScriptCall(c.Name()) {
+		return
+	}
+
+	keys, args := splitArgs(c.Args())
+	interaction := Interaction{
+		Command: c.Name(),
+		Keys:    keys,
+		Args:    args,
+		Result:  c.Val(),
+	}
+	if err != nil && err != redis.Nil {
+		interaction.Error = err.Error()
+	}
+
+	r.mu.Lock()
+	r.interactions = append(r.interactions, interaction)
+	r.mu.Unlock()
+}
+
+// Replayer serves recorded interactions in order to the clients returned by
+// Client. Only script calls are supported; any other command fails, since
+// there is no Redis behind them.
+type Replayer struct {
+	mu           sync.Mutex
+	interactions []Interaction
+	pos          int
+}
+
+func NewReplayer(interactions []Interaction) *Replayer {
+	return &Replayer{interactions: interactions}
+}
+
+// LoadCassette reads a cassette written by Recorder.WriteCassette.
+func LoadCassette(path string) (*Replayer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var interactions []Interaction
+	if err := decoder.Decode(&interactions); err != nil {
+		return nil, err
+	}
+
+	for i := range interactions {
+		interactions[i].Result = normalize(interactions[i].Result)
+	}
+
+	return NewReplayer(interactions), nil
+}
+
+// Client returns a client answered from the cassette.
+func (r *Replayer) Client() *redis.Client {
+	client := redis.NewClient(&redis.Options{Addr: "replay:0"})
+	client.AddHook(r)
+	return client
+}
+
+// DialHook refuses to dial, so nothing can reach a real Redis by mistake.
+func (r *Replayer) DialHook(next redis.DialHook) redis.DialHook {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return nil, errors.New("replay: no Redis to dial")
+	}
+}
+
+func (r *Replayer) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		r.serve(cmd)
+		return cmd.Err()
+	}
+}
+
+func (r *Replayer) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		var firstErr error
+		for _, cmd := range cmds {
+			r.serve(cmd)
+			if err := cmd.Err(); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+		return firstErr
+	}
+}
+
+// Remaining returns how many recorded interactions have not been replayed.
+func (r *Replayer) Remaining() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return len(r.interactions) - r.pos
+}
+
+// serve answers cmd with the next interaction, failing it if the command, keys
+// or args differ from what was recorded.
+func (r *Replayer) serve(cmd redis.Cmder) {
+	c, ok := cmd.(*redis.Cmd)
+	if !ok || !isScriptCall(c.Name()) {
+		cmd.SetErr(fmt.Errorf("replay: %s is not a script call", cmd.Name()))
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.pos >= len(r.interactions) {
+		c.SetErr(ErrCassetteExhausted)
+		return
+	}
+
+	interaction := r.interactions[r.pos]
+	r.pos++
+
+	keys, args := splitArgs(c.Args())
+	if interaction.Command != c.Name() ||
+		fmt.Sprint(interaction.Keys) != fmt.Sprint(keys) ||
+		fmt.Sprint(interaction.Args) != fmt.Sprint(args) {
+		c.SetErr(fmt.Errorf("replay: expected %s %v %v, got %s %v %v",
+			interaction.Command, interaction.Keys, interaction.Args, c.Name(), keys, args))
+		return
+	}
+
+	if interaction.Error != "" {
+		c.SetErr(replayError(interaction.Error))
+		return
+	}
+
+	c.SetVal(interaction.Result)
+}
+
+// replayError is a recorded Redis error. It implements redis.Error so prefix
+// checks such as the NOSCRIPT fallback in Script.Run behave as they did live.
+type replayError string
+
+func (e replayError) Error() string { return string(e) }
+
+func (replayError) RedisError() {}
+
+func isScriptCall(name string) bool {
+	switch name {
+	case "eval", "evalsha", "eval_ro", "evalsha_ro":
+		return true
+	}
+	return false
+}
+
+// splitArgs splits a script call's arguments, laid out as command, script or
+// SHA, key count, keys, then ARGV, into its keys and formatted ARGV.
+func splitArgs(cmdArgs []interface{}) ([]string, []string) {
+	if len(cmdArgs) < 3 {
+		return nil, nil
+	}
+
+	numKeys, _ := cmdArgs[2].(int)
+	numKeys = min(numKeys, len(cmdArgs)-3)
+
+	keys := make([]string, numKeys)
+	for i := range keys {
+		keys[i] = fmt.Sprint(cmdArgs[3+i])
+	}
+
+	return keys, formatArgs(cmdArgs[3+numKeys:])
+}
+
+func formatArgs(args []interface{}) []string {
+	formatted := make([]string, len(args))
+	for i, arg := range args {
+		formatted[i] = fmt.Sprint(arg)
+	}
+	return formatted
+}
+
+// normalize restores the reply types go-redis produces: integers as int64 and
+// arrays as []interface{}.
+func normalize(v interface{}) interface{} {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		return v.String()
+	case []interface{}:
+		for i := range v {
+			v[i] = normalize(v[i])
+		}
+		return v
+	default:
+		return v
+	}
+}
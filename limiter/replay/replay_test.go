@@ -0,0 +1,92 @@
+package replay
+
+import (
+	"context"
+	"flag"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/schoolboybru/distributed-rate-limiter/limiter"
+)
+
+var record = flag.Bool("record", false, "record testdata against a Redis at localhost:6379")
+
+const cassette = "testdata/allow_wait.json"
+
+// exercise drives the Allow and Wait paths the cassette covers.
+func exercise(t *testing.T, client *redis.Client) {
+	l := limiter.NewRedisLimiter(client, 5, 10, "replay:")
+
+	for i := range 5 {
+		if !l.Allow("user-1", 1) {
+			t.Errorf("request %d should be allowed", i+1)
+		}
+	}
+
+	if l.Allow("user-1", 1) {
+		t.Error("request 6 should be denied")
+	}
+
+	if err := l.Wait(context.Background(), "user-1", 1); err != nil {
+		t.Errorf("expected wait to succeed, got %v", err)
+	}
+}
+
+func TestReplay_AllowAndWait(t *testing.T) {
+	if *record {
+		client := redis.NewClient(&redis.Options{
+			Addr: "localhost:6379",
+		})
+		client.Del(context.Background(), "replay:user-1")
+		client.ScriptFlush(context.Background())
+
+		recorder := NewRecorder(client)
+		exercise(t, client)
+
+		if err := recorder.WriteCassette(cassette); err != nil {
+			t.Fatalf("failed to save cassette: %v", err)
+		}
+		return
+	}
+
+	replayer, err := LoadCassette(cassette)
+	if err != nil {
+		t.Fatalf("failed to load cassette: %v", err)
+	}
+
+	exercise(t, replayer.Client())
+
+	if replayer.Remaining() != 0 {
+		t.Errorf("expected cassette to be fully replayed, %d interactions left", replayer.Remaining())
+	}
+}
+
+func TestReplay_ExhaustedCassette(t *testing.T) {
+	l := limiter.NewRedisLimiter(NewReplayer(nil).Client(), 5, 10, "replay:", limiter.WithFailureMode(limiter.FailClosed))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if l.Allow("user-1", 1) {
+		t.Error("expected an exhausted cassette to fail closed")
+	}
+
+	if err := l.Wait(ctx, "user-1", 1); err != context.DeadlineExceeded {
+		t.Errorf("expected DeadlineExceeded, got %v", err)
+	}
+}
+
+func TestReplay_MismatchedArgsFail(t *testing.T) {
+	replayer := NewReplayer([]Interaction{{
+		Command: "evalsha",
+		Keys:    []string{"replay:user-1"},
+		Args:    []string{"1", "5", "10", "consume", "0"},
+		Result:  []interface{}{int64(1), "3", "0", "0.2", "5"},
+	}})
+	l := limiter.NewRedisLimiter(replayer.Client(), 5, 10, "replay:", limiter.WithFailureMode(limiter.FailClosed))
+
+	if l.Allow("user-1", 2) {
+		t.Error("expected a request with different args to miss the cassette and fail closed")
+	}
+}
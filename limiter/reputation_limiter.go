@@ -0,0 +1,77 @@
+package limiter
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// minReputationScore is the score below which a key is forgotten.
+const minReputationScore = 1e-6
+
+type reputation struct {
+	score   float64
+	updated time.Time
+}
+
+// ReputationLimiter keeps a per-key abuse score that decays exponentially with
+// the given half-life. Events add their weight with Record, so a failed login
+// can count for more than a page view, and a key is allowed while its score is
+// under the threshold.
+type ReputationLimiter struct {
+	mu        sync.Mutex
+	scores    map[string]*reputation
+	threshold float64
+	halfLife  time.Duration
+	clock     Clock
+}
+
+func NewReputationLimiter(threshold float64, halfLife time.Duration, clock Clock) *ReputationLimiter {
+	return &ReputationLimiter{
+		scores:    make(map[string]*reputation),
+		threshold: threshold,
+		halfLife:  halfLife,
+		clock:     clock,
+	}
+}
+
+// Record adds weight to the key's decayed score.
+func (rl *ReputationLimiter) Record(key string, weight float64) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	score := rl.decayed(key) + weight
+	rl.scores[key] = &reputation{score: score, updated: rl.clock.Now()}
+}
+
+// Allowed reports whether the key's score is under the threshold.
+func (rl *ReputationLimiter) Allowed(key string) bool {
+	return rl.Score(key) < rl.threshold
+}
+
+// Score returns the key's score decayed to now.
+func (rl *ReputationLimiter) Score(key string) float64 {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	return rl.decayed(key)
+}
+
+// decayed returns the key's current score, forgetting keys that have decayed
+// to nothing. Must be called with rl.mu held.
+func (rl *ReputationLimiter) decayed(key string) float64 {
+	r, ok := rl.scores[key]
+	if !ok {
+		return 0
+	}
+
+	elapsed := rl.clock.Now().Sub(r.updated)
+	score := r.score * math.Exp2(-elapsed.Seconds()/rl.halfLife.Seconds())
+
+	if score < minReputationScore {
+		delete(rl.scores, key)
+		return 0
+	}
+
+	return score
+}
@@ -0,0 +1,62 @@
+package limiter
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestReputationLimiter_ScoreDecays(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	limiter := NewReputationLimiter(10, time.Minute, clock)
+
+	limiter.Record("user-1", 8)
+	clock.Advance(time.Minute)
+
+	if score := limiter.Score("user-1"); math.Abs(score-4) > 1e-9 {
+		t.Errorf("expected the score to halve after one half-life, got %f", score)
+	}
+
+	clock.Advance(time.Minute)
+	limiter.Record("user-1", 1)
+
+	if score := limiter.Score("user-1"); math.Abs(score-3) > 1e-9 {
+		t.Errorf("expected decayed score plus the new weight, got %f", score)
+	}
+}
+
+func TestReputationLimiter_EnforcesThreshold(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	limiter := NewReputationLimiter(10, time.Minute, clock)
+
+	for range 5 {
+		limiter.Record("user-1", 1)
+	}
+	limiter.Record("user-1", 5)
+
+	if limiter.Allowed("user-1") {
+		t.Error("expected page views plus a heavy failed login to cross the threshold")
+	}
+
+	if !limiter.Allowed("user-2") {
+		t.Error("expected an unseen key to be allowed")
+	}
+
+	clock.Advance(time.Minute)
+	if !limiter.Allowed("user-1") {
+		t.Error("expected the key to be allowed again once its score decays")
+	}
+}
+
+func TestReputationLimiter_ForgetsDecayedKeys(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	limiter := NewReputationLimiter(10, time.Second, clock)
+
+	limiter.Record("user-1", 1)
+	clock.Advance(time.Minute)
+	limiter.Score("user-1")
+
+	if _, ok := limiter.scores["user-1"]; ok {
+		t.Error("expected a fully decayed key to be dropped")
+	}
+}
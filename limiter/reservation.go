@@ -0,0 +1,51 @@
+package limiter
+
+import (
+	"sync"
+	"time"
+)
+
+// Reservation holds tokens taken from a TokenBucket ahead of time. The tokens
+// are deducted when the reservation is made, possibly leaving the bucket in
+// debt, and the holder should wait Delay before acting on it.
+type Reservation struct {
+	bucket    *TokenBucket
+	tokens    float64
+	timeToAct time.Time
+	ok        bool
+	cancelled sync.Once
+}
+
+// OK reports whether the reservation can ever be honoured. If it is false no
+// tokens were taken.
+func (r *Reservation) OK() bool {
+	return r.ok
+}
+
+// Delay returns how long the holder should wait before acting, or
+// NeverAvailable if the reservation isn't OK.
+func (r *Reservation) Delay() time.Duration {
+	if !r.ok {
+		return NeverAvailable
+	}
+
+	return max(r.timeToAct.Sub(r.bucket.clock.Now()), 0)
+}
+
+// Cancel returns the reserved tokens to the bucket, e.g. when the work was
+// aborted before acting. Once the time to act has passed the tokens count as
+// used and nothing is returned, so a deferred Cancel is safe. The bucket is
+// refilled first and never exceeds its capacity. Only the first call has any
+// effect.
+func (r *Reservation) Cancel() {
+	if !r.ok {
+		return
+	}
+
+	r.cancelled.Do(func() {
+		if r.bucket.clock.Now().After(r.timeToAct) {
+			return
+		}
+		r.bucket.refund(r.tokens)
+	})
+}
@@ -0,0 +1,106 @@
+package limiter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReserve_ReportsDelay(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	bucket := NewTokenBucket(10, 2, clock)
+
+	r, err := bucket.Reserve(8)
+	if err != nil || !r.OK() || r.Delay() != 0 {
+		t.Errorf("expected an immediate reservation, got %v, %v", r.Delay(), err)
+	}
+
+	r, err = bucket.Reserve(6)
+	if err != nil || !r.OK() {
+		t.Fatalf("expected a delayed reservation, got %v", err)
+	}
+
+	if r.Delay() != 2*time.Second {
+		t.Errorf("expected a 2s delay, got %v", r.Delay())
+	}
+
+	clock.Advance(time.Second)
+	if r.Delay() != time.Second {
+		t.Errorf("expected the delay to count down, got %v", r.Delay())
+	}
+
+	if bucket.Allow(1) {
+		t.Error("expected the reserved tokens to be taken from the bucket")
+	}
+}
+
+func TestReserve_CancelReturnsTokens(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	bucket := NewTokenBucket(10, 2, clock)
+
+	bucket.Allow(4)
+	r, _ := bucket.Reserve(5)
+
+	r.Cancel()
+	r.Cancel()
+
+	if bucket.Tokens() != 6 {
+		t.Errorf("expected cancelling to return 5 tokens once, got %f", bucket.Tokens())
+	}
+
+	bucket.Allow(6)
+	r, _ = bucket.Reserve(4)
+	clock.Advance(time.Second)
+	r.Cancel()
+
+	if bucket.Tokens() != 2 {
+		t.Errorf("expected cancelling before the act time to return the tokens, got %f", bucket.Tokens())
+	}
+}
+
+func TestReserve_CancelAfterActTimeReturnsNothing(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	bucket := NewTokenBucket(10, 2, clock)
+
+	bucket.Allow(10)
+	r, _ := bucket.Reserve(4)
+	clock.Advance(3 * time.Second)
+	r.Cancel()
+
+	if bucket.Tokens() != 2 {
+		t.Errorf("expected the used tokens to stay spent, got %f", bucket.Tokens())
+	}
+}
+
+func TestReserve_Errors(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	bucket := NewTokenBucket(10, 0, clock)
+
+	if _, err := bucket.Reserve(11); err != ErrExceedsCapacity {
+		t.Errorf("expected ErrExceedsCapacity, got %v", err)
+	}
+
+	if _, err := bucket.Reserve(-1); err != ErrNegativeTokens {
+		t.Errorf("expected ErrNegativeTokens, got %v", err)
+	}
+
+	bucket.Allow(10)
+	r, err := bucket.Reserve(1)
+	if err != nil || r.OK() || r.Delay() != NeverAvailable {
+		t.Errorf("expected a reservation that can never be honoured, got %v, %v", r.OK(), err)
+	}
+}
+
+func TestKeyedLimiter_Reserve(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	keyedLimiter := NewKeyedLimiter(5, 1, clock)
+
+	keyedLimiter.Allow("user-1", 5)
+	r, err := keyedLimiter.Reserve("user-1", 2)
+	if err != nil || r.Delay() != 2*time.Second {
+		t.Errorf("expected a 2s delay, got %v, %v", r.Delay(), err)
+	}
+
+	if r, _ := keyedLimiter.Reserve("user-2", 2); r.Delay() != 0 {
+		t.Error("expected keys to have separate buckets")
+	}
+}
@@ -0,0 +1,101 @@
+package limiter
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+// SampledMetrics forwards only a fraction of OnAllow and OnLatency calls to the
+// wrapped Metrics. Counts derived from those hooks are sampled, not totals, and
+// should be divided by the rate if absolute numbers are needed. OnDeny and
+// OnError are rarer and always forwarded.
+type SampledMetrics struct {
+	metrics         Metrics
+	rate            float64
+	sample          func() float64
+	aggregateAllows bool
+}
+
+// AggregateKey is the key SampledMetrics reports allows and latencies under
+// when per-key detail is dropped.
+const AggregateKey = ""
+
+func NewSampledMetrics(m Metrics, rate float64) *SampledMetrics {
+	return &SampledMetrics{
+		metrics: m,
+		rate:    rate,
+		sample:  rand.Float64,
+	}
+}
+
+// NewDenyDetailMetrics keeps per-key detail only where it matters: every deny
+// and error is forwarded with its key, while allows and latencies are sampled
+// at allowRate and reported under AggregateKey, keeping label cardinality
+// bounded by the number of keys actually denied.
+func NewDenyDetailMetrics(m Metrics, allowRate float64) *SampledMetrics {
+	s := NewSampledMetrics(m, allowRate)
+	s.aggregateAllows = true
+	return s
+}
+
+func (s *SampledMetrics) OnAllow(key string) {
+	if s.sampled() {
+		s.metrics.OnAllow(s.label(key))
+	}
+}
+
+// OnConsume is always forwarded, if the wrapped Metrics implements
+// ConsumeMetrics, so consumed token totals stay exact.
+func (s *SampledMetrics) OnConsume(key string, tokens int) {
+	if cm, ok := s.metrics.(ConsumeMetrics); ok {
+		cm.OnConsume(s.label(key), tokens)
+	}
+}
+
+func (s *SampledMetrics) OnDeny(key string) {
+	s.metrics.OnDeny(key)
+}
+
+// OnShadowDeny is forwarded like OnDeny, if the wrapped Metrics implements
+// ShadowMetrics.
+func (s *SampledMetrics) OnShadowDeny(key string) {
+	recordDeny(s.metrics, key, true)
+}
+
+// OnClockDrift is always forwarded, if the wrapped Metrics implements
+// DriftMetrics, since drift is measured rarely and is a gauge.
+func (s *SampledMetrics) OnClockDrift(d time.Duration) {
+	if dm, ok := s.metrics.(DriftMetrics); ok {
+		dm.OnClockDrift(d)
+	}
+}
+
+// OnCircuitStateChange is always forwarded, if the wrapped Metrics implements
+// CircuitMetrics, so a state gauge never misses a transition.
+func (s *SampledMetrics) OnCircuitStateChange(from, to CircuitState) {
+	if cm, ok := s.metrics.(CircuitMetrics); ok {
+		cm.OnCircuitStateChange(from, to)
+	}
+}
+
+func (s *SampledMetrics) OnError(key string, err error) {
+	s.metrics.OnError(key, err)
+}
+
+func (s *SampledMetrics) OnLatency(key string, d time.Duration) {
+	if s.sampled() {
+		s.metrics.OnLatency(s.label(key), d)
+	}
+}
+
+func (s *SampledMetrics) sampled() bool {
+	return s.rate >= 1 || s.sample() < s.rate
+}
+
+func (s *SampledMetrics) label(key string) string {
+	if s.aggregateAllows {
+		return AggregateKey
+	}
+
+	return key
+}
@@ -0,0 +1,92 @@
+package limiter
+
+import (
+	"errors"
+	"math/rand/v2"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestSampledMetrics_HonorsRate(t *testing.T) {
+	inner := &MockMetrics{}
+	metrics := NewSampledMetrics(inner, 0.1)
+	metrics.sample = rand.New(rand.NewPCG(1, 2)).Float64
+
+	calls := 10000
+	for range calls {
+		metrics.OnAllow("user-1")
+		metrics.OnLatency("user-1", time.Millisecond)
+	}
+
+	if len(inner.allows) < 900 || len(inner.allows) > 1100 {
+		t.Errorf("expected roughly 1000 sampled allows, got %d", len(inner.allows))
+	}
+
+	if len(inner.latencies) < 900 || len(inner.latencies) > 1100 {
+		t.Errorf("expected roughly 1000 sampled latencies, got %d", len(inner.latencies))
+	}
+}
+
+func TestSampledMetrics_AlwaysRecordsDeniesAndErrors(t *testing.T) {
+	inner := &MockMetrics{}
+	metrics := NewSampledMetrics(inner, 0)
+
+	for range 100 {
+		metrics.OnAllow("user-1")
+		metrics.OnDeny("user-1")
+		metrics.OnError("user-1", errors.New("boom"))
+	}
+
+	if len(inner.allows) != 0 {
+		t.Errorf("expected no allows with a zero rate, got %d", len(inner.allows))
+	}
+
+	if len(inner.denies) != 100 {
+		t.Errorf("expected 100 denies, got %d", len(inner.denies))
+	}
+
+	if len(inner.errors) != 100 {
+		t.Errorf("expected 100 errors, got %d", len(inner.errors))
+	}
+}
+
+func TestDenyDetailMetrics_KeepsDenyKeysAndAggregatesAllows(t *testing.T) {
+	inner := &MockMetrics{}
+	metrics := NewDenyDetailMetrics(inner, 1)
+
+	for _, key := range []string{"user-1", "user-2", "user-3"} {
+		metrics.OnAllow(key)
+		metrics.OnLatency(key, time.Millisecond)
+	}
+	metrics.OnDeny("user-2")
+
+	for _, key := range inner.allows {
+		if key != AggregateKey {
+			t.Errorf("expected allows under the aggregate key, got %q", key)
+		}
+	}
+
+	if len(inner.allows) != 3 {
+		t.Errorf("expected every allow to still be counted, got %d", len(inner.allows))
+	}
+
+	if len(inner.denies) != 1 || inner.denies[0] != "user-2" {
+		t.Errorf("expected the deny to keep its key, got %v", inner.denies)
+	}
+}
+
+func TestSampledMetrics_ForwardsCircuitStateChanges(t *testing.T) {
+	mr, client := setupMiniRedis(t)
+	inner := &circuitMetrics{}
+	limiter := NewRedisLimiter(client, 5, 1, "ratelimit:",
+		WithMetrics(NewSampledMetrics(inner, 0)),
+		WithCircuitBreaker(1, time.Minute))
+
+	mr.SetError("LOADING Redis is loading the dataset in memory")
+	limiter.Allow("key", 1)
+
+	if want := []string{"closed->open"}; !slices.Equal(inner.transitions, want) {
+		t.Errorf("expected transitions %v through the sampler, got %v", want, inner.transitions)
+	}
+}
@@ -0,0 +1,16 @@
+local key = KEYS[1]
+local limit = tonumber(ARGV[1])
+local ttl = tonumber(ARGV[2])
+
+local count = redis.call("INCR", key)
+if count > limit then
+	if redis.call("DECR", key) <= 0 then
+		redis.call("DEL", key)
+	end
+	return 0
+end
+
+-- Every acquire pushes the expiry back, so the count only resets once no one
+-- has acquired for ttl, e.g. after its holders crashed.
+redis.call("PEXPIRE", key, ttl)
+return 1
@@ -0,0 +1,12 @@
+local key = KEYS[1]
+
+-- The key may have expired under a slow holder; never count below zero.
+local count = tonumber(redis.call("GET", key) or "0")
+if count <= 0 then
+	return 0
+end
+
+if redis.call("DECR", key) <= 0 then
+	redis.call("DEL", key)
+end
+return 1
@@ -0,0 +1,16 @@
+local key = KEYS[1]
+local requested = tonumber(ARGV[1])
+local limit = tonumber(ARGV[2])
+local ttl = tonumber(ARGV[3])
+
+local count = redis.call("INCRBY", key, requested)
+if count == requested then
+	redis.call("PEXPIRE", key, ttl)
+end
+
+if count > limit then
+	redis.call("DECRBY", key, requested)
+	return 0
+end
+
+return 1
@@ -0,0 +1,24 @@
+local key = KEYS[1]
+local requested = tonumber(ARGV[1])
+local interval = tonumber(ARGV[2])
+local burst = tonumber(ARGV[3])
+
+local time = redis.call("TIME")
+local now = tonumber(time[1]) * 1000000 + tonumber(time[2])
+
+local tat = tonumber(redis.call("GET", key)) or now
+if tat < now then
+	tat = now
+end
+
+local new_tat = tat + requested * interval
+local wait = new_tat - now - burst * interval
+if wait > 0 then
+	return { 0, math.ceil(wait) }
+end
+
+if new_tat > now then
+	redis.call("SET", key, string.format("%.3f", new_tat), "PX", math.ceil((new_tat - now) / 1000))
+end
+
+return { 1, 0 }
@@ -0,0 +1,23 @@
+local key = KEYS[1]
+local requested = tonumber(ARGV[1])
+local limit = tonumber(ARGV[2])
+local window = tonumber(ARGV[3])
+
+local time = redis.call("TIME")
+local now = tonumber(time[1]) * 1000000 + tonumber(time[2])
+
+redis.call("ZREMRANGEBYSCORE", key, "-inf", now - window)
+local count = redis.call("ZCARD", key)
+
+if count + requested > limit then
+	local oldest = redis.call("ZRANGE", key, count + requested - limit - 1, count + requested - limit - 1, "WITHSCORES")
+	return { 0, tonumber(oldest[2]) + window - now }
+end
+
+for i = 1, requested do
+	redis.call("ZADD", key, now, now .. "-" .. (count + i))
+end
+
+redis.call("PEXPIRE", key, math.ceil(window / 1000))
+
+return { 1, 0 }
@@ -2,6 +2,8 @@ local key = KEYS[1]
 local requested = tonumber(ARGV[1])
 local capacity = tonumber(ARGV[2])
 local refill_rate = tonumber(ARGV[3])
+local mode = ARGV[4] or "consume"
+local min_ttl_ms = tonumber(ARGV[5]) or 0
 
 local time = redis.call("TIME")
 local now = tonumber(time[1]) + tonumber(time[2]) / 1000000
@@ -14,15 +16,84 @@ if tokens == nil then
 	last_ts = now
 end
 
-local elapsed = now - last_ts
+-- If the server clock jumped backwards, refill nothing; saving restarts the
+-- bucket's clock from now.
+local elapsed = math.max(0, now - last_ts)
 local refill = elapsed * refill_rate
 tokens = math.min(capacity, tokens + refill)
 
+-- save stores the bucket and expires it once it would have refilled, or after
+-- min_ttl_ms if that is longer, since a full bucket is the same as none.
+local function save()
+	redis.call("HSET", key, "tokens", tokens, "ts", now)
+
+	local ttl_ms = min_ttl_ms
+	if refill_rate > 0 then
+		ttl_ms = math.max(ttl_ms, math.ceil((capacity - tokens) / refill_rate * 1000))
+	elseif ttl_ms <= 0 then
+		redis.call("PERSIST", key)
+		return
+	end
+	redis.call("PEXPIRE", key, math.max(ttl_ms, 1))
+end
+
+local function retry_after()
+	if requested > capacity or refill_rate <= 0 then
+		return "-1"
+	end
+	return tostring((requested - tokens) / refill_rate)
+end
+
+-- reset_after is how long until the bucket is full again, or -1 if it never
+-- will be.
+local function reset_after()
+	if tokens >= capacity then
+		return "0"
+	elseif refill_rate <= 0 then
+		return "-1"
+	end
+	return tostring((capacity - tokens) / refill_rate)
+end
+
+-- result is the script's reply: whether the request was allowed, the tokens
+-- left, the retry and reset durations in seconds, and the bucket's capacity.
+local function result(allowed, retry)
+	return { allowed, tostring(tokens), retry, reset_after(), tostring(capacity) }
+end
+
+if mode == "add" or mode == "set" then
+	if mode == "add" then
+		tokens = tokens + requested
+	else
+		tokens = requested
+	end
+	tokens = math.max(0, math.min(capacity, tokens))
+	save()
+	return result(1, "0")
+end
+
+if mode == "peek" then
+	return result(0, "0")
+end
+
+if requested < 0 then
+	return result(0, "-1")
+end
+
+-- partial takes as many whole tokens as are available, up to requested, and
+-- replies with the count taken in place of the allowed flag.
+if mode == "partial" then
+	local granted = math.min(requested, math.floor(tokens))
+	tokens = tokens - granted
+	save()
+	return result(granted, "0")
+end
+
 if tokens >= requested then
 	tokens = tokens - requested
-	redis.call("HSET", key, "tokens", tokens, "ts", now)
-	return { 1, tokens }
+	save()
+	return result(1, "0")
 else
-	redis.call("HSET", key, "tokens", tokens, "ts", now)
-	return { 0, tokens }
+	save()
+	return result(0, retry_after())
 end
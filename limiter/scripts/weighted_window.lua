@@ -0,0 +1,59 @@
+local log_key = KEYS[1]
+local cost_key = KEYS[2]
+local cost = tonumber(ARGV[1])
+local limit = tonumber(ARGV[2])
+local window = tonumber(ARGV[3])
+
+local time = redis.call("TIME")
+local now = tonumber(time[1]) * 1000000 + tonumber(time[2])
+local cutoff = now - window
+
+local total = tonumber(redis.call("HGET", cost_key, "total")) or 0
+
+local expired = redis.call("ZRANGEBYSCORE", log_key, "-inf", cutoff)
+for _, member in ipairs(expired) do
+	total = total - tonumber(string.match(member, ":([^:]+)$"))
+end
+
+if #expired > 0 then
+	redis.call("ZREMRANGEBYSCORE", log_key, "-inf", cutoff)
+end
+
+if total < 0 or redis.call("ZCARD", log_key) == 0 then
+	total = 0
+end
+
+local allowed = 0
+-- retry is how many microseconds until enough cost leaves the window for the
+-- request to fit, or -1 if it never will.
+local retry = 0
+if total + cost <= limit then
+	-- A zero-cost request uses none of the window, so it leaves no entry that
+	-- would only grow the set.
+	if cost > 0 then
+		local seq = redis.call("HINCRBY", cost_key, "seq", 1)
+		redis.call("ZADD", log_key, now, now .. ":" .. seq .. ":" .. cost)
+		total = total + cost
+	end
+	allowed = 1
+elseif cost > limit then
+	retry = -1
+else
+	local remaining = total
+	local entries = redis.call("ZRANGE", log_key, 0, -1, "WITHSCORES")
+	for i = 1, #entries, 2 do
+		remaining = remaining - tonumber(string.match(entries[i], ":([^:]+)$"))
+		if remaining + cost <= limit then
+			retry = tonumber(entries[i + 1]) + window - now
+			break
+		end
+	end
+end
+
+redis.call("HSET", cost_key, "total", tostring(total))
+
+local ttl = math.ceil(window / 1000)
+redis.call("PEXPIRE", log_key, ttl)
+redis.call("PEXPIRE", cost_key, ttl)
+
+return { allowed, tostring(total), retry }
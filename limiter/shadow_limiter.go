@@ -0,0 +1,64 @@
+package limiter
+
+import "context"
+
+// ShadowComparison is one decision made by both limiters of a ShadowLimiter.
+type ShadowComparison struct {
+	Key     string
+	Tokens  int
+	Primary bool
+	Shadow  bool
+}
+
+// Diverged reports whether the two limiters disagreed.
+func (c ShadowComparison) Diverged() bool {
+	return c.Primary != c.Shadow
+}
+
+// ShadowLimiter enforces the primary limiter's decisions while also asking a
+// shadow limiter about every request and reporting both answers, so a
+// migration can measure how often the two disagree before cutting over. The
+// shadow never affects traffic.
+type ShadowLimiter struct {
+	primary Limiter
+	shadow  Limiter
+	compare func(ShadowComparison)
+}
+
+func NewShadowLimiter(primary Limiter, shadow Limiter, compare func(ShadowComparison)) *ShadowLimiter {
+	return &ShadowLimiter{
+		primary: primary,
+		shadow:  shadow,
+		compare: compare,
+	}
+}
+
+func (s *ShadowLimiter) Allow(key string, tokens int) bool {
+	allowed := s.primary.Allow(key, tokens)
+	s.compare(ShadowComparison{
+		Key:     key,
+		Tokens:  tokens,
+		Primary: allowed,
+		Shadow:  s.shadow.Allow(key, tokens),
+	})
+
+	return allowed
+}
+
+// Wait blocks on the primary only. Once it admits the request the shadow is
+// checked without waiting, so a divergence means the shadow would have
+// delayed the request at that moment.
+func (s *ShadowLimiter) Wait(ctx context.Context, key string, tokens int) error {
+	if err := s.primary.Wait(ctx, key, tokens); err != nil {
+		return err
+	}
+
+	s.compare(ShadowComparison{
+		Key:     key,
+		Tokens:  tokens,
+		Primary: true,
+		Shadow:  s.shadow.Allow(key, tokens),
+	})
+
+	return nil
+}
@@ -0,0 +1,65 @@
+package limiter
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestShadowLimiter_ReportsDivergence(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	primary := NewKeyedLimiter(5, 1, clock)
+	shadow := NewKeyedLimiter(3, 1, clock)
+
+	var comparisons []ShadowComparison
+	limiter := NewShadowLimiter(primary, shadow, func(c ShadowComparison) {
+		comparisons = append(comparisons, c)
+	})
+
+	for i := range 5 {
+		if !limiter.Allow("user-1", 1) {
+			t.Errorf("request %d should follow the primary's allow", i+1)
+		}
+	}
+
+	if limiter.Allow("user-1", 1) {
+		t.Error("expected the primary's deny to be enforced")
+	}
+
+	if len(comparisons) != 6 {
+		t.Fatalf("expected 6 comparisons, got %d", len(comparisons))
+	}
+
+	diverged := 0
+	for _, c := range comparisons {
+		if c.Diverged() {
+			diverged++
+			if !c.Primary || c.Shadow {
+				t.Errorf("expected the primary to allow what the shadow denied, got %+v", c)
+			}
+		}
+	}
+
+	if diverged != 2 {
+		t.Errorf("expected 2 divergent decisions, got %d", diverged)
+	}
+}
+
+func TestShadowLimiter_WaitComparesAfterPrimary(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	primary := NewKeyedLimiter(5, 1, clock)
+	shadow := NewKeyedLimiter(0, 1, clock)
+
+	var comparison ShadowComparison
+	limiter := NewShadowLimiter(primary, shadow, func(c ShadowComparison) {
+		comparison = c
+	})
+
+	if err := limiter.Wait(context.Background(), "user-1", 1); err != nil {
+		t.Fatalf("expected wait to succeed, got %v", err)
+	}
+
+	if !comparison.Diverged() || comparison.Key != "user-1" || comparison.Tokens != 1 {
+		t.Errorf("expected a divergent comparison for user-1, got %+v", comparison)
+	}
+}
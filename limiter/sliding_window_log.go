@@ -0,0 +1,177 @@
+package limiter
+
+import (
+	"context"
+	_ "embed"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+//go:embed scripts/sliding_window_log.lua
+var slidingWindowLogScript string
+
+// SlidingWindowLog allows at most limit tokens per key within any trailing
+// window, with none of the boundary bursts of a token bucket or fixed window.
+// A request for n tokens is logged as n entries.
+//
+// Each key keeps at most limit timestamps, since entries are only logged when
+// admitted, so memory per key is O(limit). Keys whose log has emptied are
+// dropped on their next call; for very high key cardinality prefer GCRA, which
+// stores a single value per key.
+type SlidingWindowLog struct {
+	mu     sync.Mutex
+	logs   map[string][]time.Time
+	limit  int
+	window time.Duration
+	clock  Clock
+}
+
+func NewSlidingWindowLog(limit int, window time.Duration, clock Clock) *SlidingWindowLog {
+	return &SlidingWindowLog{
+		logs:   make(map[string][]time.Time),
+		limit:  limit,
+		window: window,
+		clock:  clock,
+	}
+}
+
+func (sw *SlidingWindowLog) Allow(key string, tokens int) bool {
+	allowed, _ := sw.allow(key, tokens)
+	return allowed
+}
+
+func (sw *SlidingWindowLog) Wait(ctx context.Context, key string, tokens int) error {
+	if tokens < 0 {
+		return ErrNegativeTokens
+	}
+
+	if tokens > sw.limit {
+		return ErrExceedsCapacity
+	}
+
+	for {
+		allowed, waitDuration := sw.allow(key, tokens)
+		if allowed {
+			return nil
+		}
+
+		timer := time.NewTimer(waitDuration)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// allow prunes the key's log and logs the request if it fits. On denial it
+// returns how long until enough entries expire.
+func (sw *SlidingWindowLog) allow(key string, tokens int) (bool, time.Duration) {
+	if tokens < 0 || tokens > sw.limit {
+		return false, NeverAvailable
+	}
+
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+
+	now := sw.clock.Now()
+	cutoff := now.Add(-sw.window)
+
+	log := sw.logs[key]
+	expired := 0
+	for expired < len(log) && !log[expired].After(cutoff) {
+		expired++
+	}
+	log = log[expired:]
+
+	if len(log)+tokens > sw.limit {
+		sw.logs[key] = log
+		oldest := log[len(log)+tokens-sw.limit-1]
+		return false, oldest.Add(sw.window).Sub(now)
+	}
+
+	for range tokens {
+		log = append(log, now)
+	}
+
+	if len(log) == 0 {
+		delete(sw.logs, key)
+	} else {
+		sw.logs[key] = log
+	}
+
+	return true, 0
+}
+
+// RedisSlidingWindowLog is the distributed SlidingWindowLog, storing each key's
+// log as a sorted set scored by Redis server time. Like the in-memory version,
+// a key holds at most limit entries and expires after an idle window.
+type RedisSlidingWindowLog struct {
+	client    redis.Cmdable
+	script    *redis.Script
+	limit     int
+	window    time.Duration
+	keyPrefix string
+}
+
+func NewRedisSlidingWindowLog(client redis.Cmdable, limit int, window time.Duration, keyPrefix string) *RedisSlidingWindowLog {
+	return &RedisSlidingWindowLog{
+		client:    client,
+		script:    redis.NewScript(slidingWindowLogScript),
+		limit:     limit,
+		window:    window,
+		keyPrefix: keyPrefix,
+	}
+}
+
+// Allow reports whether the request fits in the window. Redis errors fail open.
+func (sw *RedisSlidingWindowLog) Allow(key string, tokens int) bool {
+	allowed, _, err := sw.allow(context.Background(), key, tokens)
+	if err != nil {
+		return true
+	}
+
+	return allowed
+}
+
+func (sw *RedisSlidingWindowLog) Wait(ctx context.Context, key string, tokens int) error {
+	if tokens < 0 {
+		return ErrNegativeTokens
+	}
+
+	if tokens > sw.limit {
+		return ErrExceedsCapacity
+	}
+
+	for {
+		allowed, waitDuration, err := sw.allow(ctx, key, tokens)
+		if err != nil || allowed {
+			return err
+		}
+
+		timer := time.NewTimer(waitDuration)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func (sw *RedisSlidingWindowLog) allow(ctx context.Context, key string, tokens int) (bool, time.Duration, error) {
+	if tokens < 0 || tokens > sw.limit {
+		return false, NeverAvailable, nil
+	}
+
+	result, err := sw.script.Run(ctx, sw.client, []string{sw.keyPrefix + key}, tokens, sw.limit, sw.window.Microseconds()).Result()
+	if err != nil {
+		return false, 0, err
+	}
+
+	resSlice := result.([]interface{})
+	return resSlice[0].(int64) == 1, time.Duration(resSlice[1].(int64)) * time.Microsecond, nil
+}
@@ -0,0 +1,116 @@
+package limiter
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSlidingWindowLog_EnforcesLimit(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	limiter := NewSlidingWindowLog(3, time.Minute, clock)
+
+	for i := range 3 {
+		if !limiter.Allow("user-1", 1) {
+			t.Errorf("request %d should be allowed", i+1)
+		}
+	}
+
+	if limiter.Allow("user-1", 1) {
+		t.Error("request 4 should be denied")
+	}
+
+	if !limiter.Allow("user-2", 3) {
+		t.Error("expected a different key to have its own log")
+	}
+}
+
+func TestSlidingWindowLog_Slides(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	limiter := NewSlidingWindowLog(2, time.Minute, clock)
+
+	limiter.Allow("user-1", 1)
+	clock.Advance(30 * time.Second)
+	limiter.Allow("user-1", 1)
+
+	clock.Advance(29 * time.Second)
+	if limiter.Allow("user-1", 1) {
+		t.Error("expected both requests to still be in the window")
+	}
+
+	clock.Advance(2 * time.Second)
+	if !limiter.Allow("user-1", 1) {
+		t.Error("expected the first request to have left the window")
+	}
+
+	if limiter.Allow("user-1", 1) {
+		t.Error("expected no burst at the window boundary")
+	}
+}
+
+func TestSlidingWindowLog_BoundsMemory(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	limiter := NewSlidingWindowLog(5, time.Minute, clock)
+
+	for range 100 {
+		limiter.Allow("user-1", 1)
+	}
+
+	if len(limiter.logs["user-1"]) != 5 {
+		t.Errorf("expected the log to hold at most 5 entries, got %d", len(limiter.logs["user-1"]))
+	}
+
+	clock.Advance(2 * time.Minute)
+	limiter.Allow("user-1", 0)
+
+	if _, ok := limiter.logs["user-1"]; ok {
+		t.Error("expected an emptied log to be dropped")
+	}
+}
+
+func TestSlidingWindowLog_WaitExceedsCapacity(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	limiter := NewSlidingWindowLog(5, time.Minute, clock)
+
+	if err := limiter.Wait(context.Background(), "user-1", 6); err != ErrExceedsCapacity {
+		t.Errorf("expected ErrExceedsCapacity, got %v", err)
+	}
+}
+
+func TestRedisSlidingWindowLog_EnforcesLimit(t *testing.T) {
+	mr, client := setupMiniRedis(t)
+	now := time.Now()
+	mr.SetTime(now)
+	limiter := NewRedisSlidingWindowLog(client, 3, time.Minute, "swlog:")
+
+	if !limiter.Allow("user-1", 2) {
+		t.Error("expected 2 tokens to be allowed")
+	}
+
+	mr.SetTime(now.Add(30 * time.Second))
+	if !limiter.Allow("user-1", 1) {
+		t.Error("expected the third token to be allowed")
+	}
+
+	if limiter.Allow("user-1", 1) {
+		t.Error("expected the window to be full")
+	}
+
+	if !mr.Exists("swlog:user-1") {
+		t.Error("expected the log to be stored in redis")
+	}
+
+	allowed, retry, err := limiter.allow(context.Background(), "user-1", 1)
+	if allowed || err != nil || retry != 30*time.Second {
+		t.Errorf("expected deny with 30s retry, got %v, %v, %v", allowed, retry, err)
+	}
+
+	mr.SetTime(now.Add(61 * time.Second))
+	if !limiter.Allow("user-1", 2) {
+		t.Error("expected the first two tokens to have left the window")
+	}
+
+	if limiter.Allow("user-1", 1) {
+		t.Error("expected the log to still hold three entries")
+	}
+}
@@ -0,0 +1,216 @@
+// Package sqllimit paces outbound database/sql queries through a Limiter by
+// wrapping the database driver.
+package sqllimit
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+
+	"github.com/schoolboybru/distributed-rate-limiter/limiter"
+)
+
+// QueryKeyFunc derives the rate limit key for a query, e.g. a fingerprint of
+// the statement or a constant to limit all queries together.
+type QueryKeyFunc func(query string) string
+
+// GlobalKey puts every query under the same key.
+func GlobalKey(query string) string {
+	return "global"
+}
+
+// Wrap returns a driver whose connections Wait on l for one token before each
+// query or exec, keyed by keyFunc. The wait honors the query's context, so a
+// query that can't be admitted before its deadline fails with the context's
+// error without reaching the database. Register the result with sql.Register.
+func Wrap(d driver.Driver, l limiter.Limiter, keyFunc QueryKeyFunc) driver.Driver {
+	return &limitedDriver{driver: d, limiter: l, keyFunc: keyFunc}
+}
+
+type limitedDriver struct {
+	driver  driver.Driver
+	limiter limiter.Limiter
+	keyFunc QueryKeyFunc
+}
+
+func (d *limitedDriver) Open(name string) (driver.Conn, error) {
+	conn, err := d.driver.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &limitedConn{Conn: conn, driver: d}, nil
+}
+
+func (d *limitedDriver) wait(ctx context.Context, query string) error {
+	return d.limiter.Wait(ctx, d.keyFunc(query), 1)
+}
+
+// limitedConn forwards the optional connection interfaces explicitly, since
+// embedding driver.Conn alone would hide them from database/sql. Each falls
+// back to what database/sql does when the interface is missing.
+type limitedConn struct {
+	driver.Conn
+	driver *limitedDriver
+}
+
+func (c *limitedConn) Ping(ctx context.Context) error {
+	if p, ok := c.Conn.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+
+	return nil
+}
+
+func (c *limitedConn) ResetSession(ctx context.Context) error {
+	if r, ok := c.Conn.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+
+	return nil
+}
+
+func (c *limitedConn) IsValid() bool {
+	if v, ok := c.Conn.(driver.Validator); ok {
+		return v.IsValid()
+	}
+
+	return true
+}
+
+func (c *limitedConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if n, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return n.CheckNamedValue(nv)
+	}
+
+	return driver.ErrSkip
+}
+
+func (c *limitedConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *limitedConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	var stmt driver.Stmt
+	var err error
+	if p, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		stmt, err = p.PrepareContext(ctx, query)
+	} else {
+		stmt, err = c.Conn.Prepare(query)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &limitedStmt{Stmt: stmt, driver: c.driver, query: query}, nil
+}
+
+func (c *limitedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if b, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return b.BeginTx(ctx, opts)
+	}
+
+	// Begin can't honor options, so refuse them as database/sql would.
+	if opts.Isolation != driver.IsolationLevel(0) {
+		return nil, errors.New("sqllimit: driver does not support non-default isolation level")
+	}
+	if opts.ReadOnly {
+		return nil, errors.New("sqllimit: driver does not support read-only transactions")
+	}
+
+	return c.Conn.Begin()
+}
+
+// ExecContext waits only if the underlying connection can exec directly.
+// Otherwise database/sql falls back to a prepared statement, which waits.
+func (c *limitedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+
+	if err := c.driver.wait(ctx, query); err != nil {
+		return nil, err
+	}
+
+	return execer.ExecContext(ctx, query, args)
+}
+
+func (c *limitedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+
+	if err := c.driver.wait(ctx, query); err != nil {
+		return nil, err
+	}
+
+	return queryer.QueryContext(ctx, query, args)
+}
+
+type limitedStmt struct {
+	driver.Stmt
+	driver *limitedDriver
+	query  string
+}
+
+func (s *limitedStmt) Exec(args []driver.Value) (driver.Result, error) {
+	if err := s.driver.wait(context.Background(), s.query); err != nil {
+		return nil, err
+	}
+
+	return s.Stmt.Exec(args)
+}
+
+func (s *limitedStmt) Query(args []driver.Value) (driver.Rows, error) {
+	if err := s.driver.wait(context.Background(), s.query); err != nil {
+		return nil, err
+	}
+
+	return s.Stmt.Query(args)
+}
+
+func (s *limitedStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	if err := s.driver.wait(ctx, s.query); err != nil {
+		return nil, err
+	}
+
+	if e, ok := s.Stmt.(driver.StmtExecContext); ok {
+		return e.ExecContext(ctx, args)
+	}
+
+	values, err := namedToValues(args)
+	if err != nil {
+		return nil, err
+	}
+	return s.Stmt.Exec(values)
+}
+
+func (s *limitedStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	if err := s.driver.wait(ctx, s.query); err != nil {
+		return nil, err
+	}
+
+	if q, ok := s.Stmt.(driver.StmtQueryContext); ok {
+		return q.QueryContext(ctx, args)
+	}
+
+	values, err := namedToValues(args)
+	if err != nil {
+		return nil, err
+	}
+	return s.Stmt.Query(values)
+}
+
+func namedToValues(args []driver.NamedValue) ([]driver.Value, error) {
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		if arg.Name != "" {
+			return nil, driver.ErrSkip
+		}
+		values[i] = arg.Value
+	}
+
+	return values, nil
+}
@@ -0,0 +1,189 @@
+package sqllimit
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/schoolboybru/distributed-rate-limiter/limiter"
+)
+
+type fakeDriver struct {
+	executed *atomic.Int64
+}
+
+func (d fakeDriver) Open(name string) (driver.Conn, error) {
+	return fakeConn(d), nil
+}
+
+// fakeConn execs directly but has no QueryerContext, so queries go through a
+// prepared statement.
+type fakeConn struct {
+	executed *atomic.Int64
+}
+
+func (c fakeConn) Prepare(query string) (driver.Stmt, error) { return fakeStmt(c), nil }
+func (c fakeConn) Close() error                              { return nil }
+func (c fakeConn) Begin() (driver.Tx, error)                 { return nil, errors.New("not supported") }
+
+func (c fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.executed.Add(1)
+	return driver.RowsAffected(1), nil
+}
+
+type fakeStmt struct {
+	executed *atomic.Int64
+}
+
+func (s fakeStmt) Close() error  { return nil }
+func (s fakeStmt) NumInput() int { return -1 }
+
+func (s fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.executed.Add(1)
+	return driver.RowsAffected(1), nil
+}
+
+func (s fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.executed.Add(1)
+	return fakeRows{}, nil
+}
+
+type fakeRows struct{}
+
+func (fakeRows) Columns() []string              { return nil }
+func (fakeRows) Close() error                   { return nil }
+func (fakeRows) Next(dest []driver.Value) error { return io.EOF }
+
+func openDB(t *testing.T, l limiter.Limiter) (*sql.DB, *atomic.Int64) {
+	executed := &atomic.Int64{}
+	name := "sqllimit-" + t.Name()
+	sql.Register(name, Wrap(fakeDriver{executed}, l, GlobalKey))
+
+	db, err := sql.Open(name, "")
+	if err != nil {
+		t.Fatalf("open failed: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return db, executed
+}
+
+func TestWrap_PacesQueries(t *testing.T) {
+	db, executed := openDB(t, limiter.NewKeyedLimiter(1, 20, limiter.RealClock{}))
+
+	start := time.Now()
+	for i := range 6 {
+		var err error
+		if i%2 == 0 {
+			_, err = db.ExecContext(context.Background(), "UPDATE users SET seen = now()")
+		} else {
+			var rows *sql.Rows
+			rows, err = db.QueryContext(context.Background(), "SELECT 1")
+			if err == nil {
+				rows.Close()
+			}
+		}
+		if err != nil {
+			t.Fatalf("query %d failed: %v", i, err)
+		}
+	}
+	elapsed := time.Since(start)
+
+	if executed.Load() != 6 {
+		t.Errorf("expected 6 queries to reach the driver, got %d", executed.Load())
+	}
+
+	if elapsed < 200*time.Millisecond {
+		t.Errorf("expected 6 queries at 20/s to take at least 250ms, took %v", elapsed)
+	}
+}
+
+func TestWrap_DeadlineStopsQuery(t *testing.T) {
+	db, executed := openDB(t, limiter.NewKeyedLimiter(1, 0.1, limiter.RealClock{}))
+
+	db.ExecContext(context.Background(), "DELETE FROM sessions")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if _, err := db.ExecContext(ctx, "DELETE FROM sessions"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the deadline to be exceeded, got %v", err)
+	}
+
+	if executed.Load() != 1 {
+		t.Errorf("expected the throttled query not to reach the driver, got %d executions", executed.Load())
+	}
+}
+
+// optionalConn implements the optional connection interfaces that embedding
+// driver.Conn would hide.
+type optionalConn struct {
+	fakeConn
+	pinged, reset, checked bool
+}
+
+func (c *optionalConn) Ping(ctx context.Context) error         { c.pinged = true; return nil }
+func (c *optionalConn) ResetSession(ctx context.Context) error { c.reset = true; return nil }
+func (c *optionalConn) IsValid() bool                          { return false }
+func (c *optionalConn) CheckNamedValue(nv *driver.NamedValue) error {
+	c.checked = true
+	return nil
+}
+
+func TestLimitedConn_ForwardsOptionalInterfaces(t *testing.T) {
+	inner := &optionalConn{}
+	conn := &limitedConn{Conn: inner, driver: &limitedDriver{}}
+
+	if err := conn.Ping(context.Background()); err != nil || !inner.pinged {
+		t.Errorf("expected Ping to reach the driver, got %v", err)
+	}
+	if err := conn.ResetSession(context.Background()); err != nil || !inner.reset {
+		t.Errorf("expected ResetSession to reach the driver, got %v", err)
+	}
+	if conn.IsValid() {
+		t.Error("expected IsValid to report the driver's answer")
+	}
+	if err := conn.CheckNamedValue(&driver.NamedValue{Value: 1}); err != nil || !inner.checked {
+		t.Errorf("expected CheckNamedValue to reach the driver, got %v", err)
+	}
+}
+
+func TestLimitedConn_FallsBackWithoutOptionalInterfaces(t *testing.T) {
+	conn := &limitedConn{Conn: fakeConn{}, driver: &limitedDriver{}}
+
+	if err := conn.Ping(context.Background()); err != nil {
+		t.Errorf("expected Ping to succeed, got %v", err)
+	}
+	if err := conn.ResetSession(context.Background()); err != nil {
+		t.Errorf("expected ResetSession to succeed, got %v", err)
+	}
+	if !conn.IsValid() {
+		t.Error("expected the connection to be valid")
+	}
+	if err := conn.CheckNamedValue(&driver.NamedValue{Value: 1}); !errors.Is(err, driver.ErrSkip) {
+		t.Errorf("expected ErrSkip, got %v", err)
+	}
+}
+
+func TestLimitedConn_BeginTxRejectsOptionsWithoutConnBeginTx(t *testing.T) {
+	conn := &limitedConn{Conn: fakeConn{}, driver: &limitedDriver{}}
+
+	opts := []driver.TxOptions{
+		{Isolation: driver.IsolationLevel(sql.LevelSerializable)},
+		{ReadOnly: true},
+	}
+	for _, o := range opts {
+		if _, err := conn.BeginTx(context.Background(), o); err == nil || err.Error() == "not supported" {
+			t.Errorf("expected options %+v to be rejected before Begin, got %v", o, err)
+		}
+	}
+
+	if _, err := conn.BeginTx(context.Background(), driver.TxOptions{}); err == nil || err.Error() != "not supported" {
+		t.Errorf("expected default options to fall through to Begin, got %v", err)
+	}
+}
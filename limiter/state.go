@@ -0,0 +1,58 @@
+package limiter
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"time"
+)
+
+// BucketState is the exported state of a TokenBucket.
+type BucketState struct {
+	Capacity   float64
+	RefillRate float64
+	Tokens     float64
+	LastRefill time.Time
+}
+
+// StateProvider is implemented by limiters that can report their observable
+// state, such as TokenBucket, so tests outside this package can assert on it
+// without reaching into unexported fields.
+type StateProvider interface {
+	Snapshot() BucketState
+}
+
+// StateCodec serializes exported state such as BucketState and BreakerSnapshot
+// so it can be checkpointed to disk or a KV store in any format.
+type StateCodec interface {
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+// JSONCodec encodes state as JSON. Times keep nanosecond precision and their
+// UTC offset, and floats round-trip exactly.
+type JSONCodec struct{}
+
+func (JSONCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JSONCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+// GobCodec encodes state with encoding/gob.
+type GobCodec struct{}
+
+func (GobCodec) Marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (GobCodec) Unmarshal(data []byte, v any) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
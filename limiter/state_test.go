@@ -0,0 +1,71 @@
+package limiter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStateCodec_RoundTripsBucketState(t *testing.T) {
+	clock := &MockClock{current: time.Date(2025, 3, 14, 15, 9, 26, 535897932, time.FixedZone("EST", -5*3600))}
+	bucket := NewTokenBucket(10, 0.3, clock)
+	bucket.Allow(3)
+	clock.Advance(1234567 * time.Nanosecond)
+	bucket.Allow(1)
+
+	for name, codec := range map[string]StateCodec{"json": JSONCodec{}, "gob": GobCodec{}} {
+		t.Run(name, func(t *testing.T) {
+			state := bucket.ExportState()
+
+			data, err := codec.Marshal(state)
+			if err != nil {
+				t.Fatalf("marshal failed: %v", err)
+			}
+
+			var decoded BucketState
+			if err := codec.Unmarshal(data, &decoded); err != nil {
+				t.Fatalf("unmarshal failed: %v", err)
+			}
+
+			if decoded.Tokens != state.Tokens || decoded.Capacity != state.Capacity || decoded.RefillRate != state.RefillRate {
+				t.Errorf("expected floats to round-trip exactly, got %+v want %+v", decoded, state)
+			}
+
+			if !decoded.LastRefill.Equal(state.LastRefill) {
+				t.Errorf("expected lastRefill %v, got %v", state.LastRefill, decoded.LastRefill)
+			}
+
+			restored := NewTokenBucket(1, 1, clock)
+			restored.ImportState(decoded)
+
+			if restored.Tokens() != bucket.Tokens() {
+				t.Errorf("expected the restored bucket to match, got %f want %f", restored.Tokens(), bucket.Tokens())
+			}
+		})
+	}
+}
+
+func TestStateCodec_RoundTripsBreakerSnapshot(t *testing.T) {
+	snapshot := BreakerSnapshot{
+		State:       CircuitOpen,
+		Failures:    3,
+		LastFailure: time.Date(2025, 1, 2, 3, 4, 5, 6, time.UTC),
+	}
+
+	for name, codec := range map[string]StateCodec{"json": JSONCodec{}, "gob": GobCodec{}} {
+		t.Run(name, func(t *testing.T) {
+			data, err := codec.Marshal(snapshot)
+			if err != nil {
+				t.Fatalf("marshal failed: %v", err)
+			}
+
+			var decoded BreakerSnapshot
+			if err := codec.Unmarshal(data, &decoded); err != nil {
+				t.Fatalf("unmarshal failed: %v", err)
+			}
+
+			if decoded.State != snapshot.State || decoded.Failures != snapshot.Failures || !decoded.LastFailure.Equal(snapshot.LastFailure) {
+				t.Errorf("expected %+v, got %+v", snapshot, decoded)
+			}
+		})
+	}
+}
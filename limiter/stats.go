@@ -0,0 +1,169 @@
+package limiter
+
+import (
+	"cmp"
+	"container/list"
+	"math"
+	"slices"
+	"sync"
+	"time"
+)
+
+// latencySamples is how many of each key's most recent latencies
+// StatsCollector keeps for its percentiles.
+const latencySamples = 256
+
+// KeyStats is a key's activity as seen by a StatsCollector. P50 and P99 are
+// computed over the key's most recent latencies, not its whole history.
+type KeyStats struct {
+	Allows int64
+	Denies int64
+	Errors int64
+	P50    time.Duration
+	P99    time.Duration
+}
+
+// StatsCollector is a Metrics that aggregates counts and latencies per key, to
+// answer questions like which keys are throttled most without an external
+// metrics system. It tracks at most maxKeys keys, dropping the least recently
+// seen, so a dropped key's counts restart if it comes back.
+type StatsCollector struct {
+	mu      sync.Mutex
+	maxKeys int
+	keys    map[string]*list.Element
+	lru     *list.List
+}
+
+type keyStats struct {
+	key       string
+	allows    int64
+	denies    int64
+	errors    int64
+	latencies []time.Duration
+	// next is where the next latency goes once latencies is full.
+	next int
+}
+
+func NewStatsCollector(maxKeys int) *StatsCollector {
+	return &StatsCollector{
+		maxKeys: maxKeys,
+		keys:    make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+func (s *StatsCollector) OnAllow(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.touch(key).allows++
+}
+
+func (s *StatsCollector) OnDeny(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.touch(key).denies++
+}
+
+func (s *StatsCollector) OnError(key string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.touch(key).errors++
+}
+
+func (s *StatsCollector) OnLatency(key string, d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ks := s.touch(key)
+	if len(ks.latencies) < latencySamples {
+		ks.latencies = append(ks.latencies, d)
+		return
+	}
+
+	ks.latencies[ks.next] = d
+	ks.next = (ks.next + 1) % latencySamples
+}
+
+// touch returns key's stats, creating them and evicting the least recently
+// seen key if needed. Must be called with s.mu held.
+func (s *StatsCollector) touch(key string) *keyStats {
+	if elem, ok := s.keys[key]; ok {
+		s.lru.MoveToFront(elem)
+		return elem.Value.(*keyStats)
+	}
+
+	for s.maxKeys > 0 && len(s.keys) >= s.maxKeys {
+		oldest := s.lru.Back()
+		s.lru.Remove(oldest)
+		delete(s.keys, oldest.Value.(*keyStats).key)
+	}
+
+	ks := &keyStats{key: key}
+	s.keys[key] = s.lru.PushFront(ks)
+	return ks
+}
+
+// Stats returns a snapshot of every tracked key's stats.
+func (s *StatsCollector) Stats() map[string]KeyStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := make(map[string]KeyStats, len(s.keys))
+	for key, elem := range s.keys {
+		stats[key] = elem.Value.(*keyStats).snapshot()
+	}
+
+	return stats
+}
+
+// KeyDenies is a key and its deny count, as returned by TopDenied.
+type KeyDenies struct {
+	Key    string
+	Denies int64
+}
+
+// TopDenied returns up to n tracked keys with the most denies, most denied
+// first. Keys that were never denied are left out, and n <= 0 returns none.
+func (s *StatsCollector) TopDenied(n int) []KeyDenies {
+	s.mu.Lock()
+	var top []KeyDenies
+	for key, elem := range s.keys {
+		if denies := elem.Value.(*keyStats).denies; denies > 0 {
+			top = append(top, KeyDenies{Key: key, Denies: denies})
+		}
+	}
+	s.mu.Unlock()
+
+	slices.SortFunc(top, func(a, b KeyDenies) int {
+		if a.Denies != b.Denies {
+			return cmp.Compare(b.Denies, a.Denies)
+		}
+		return cmp.Compare(a.Key, b.Key)
+	})
+
+	return top[:min(max(n, 0), len(top))]
+}
+
+func (ks *keyStats) snapshot() KeyStats {
+	stats := KeyStats{Allows: ks.allows, Denies: ks.denies, Errors: ks.errors}
+	if len(ks.latencies) == 0 {
+		return stats
+	}
+
+	sorted := slices.Clone(ks.latencies)
+	slices.Sort(sorted)
+	stats.P50 = percentile(sorted, 0.5)
+	stats.P99 = percentile(sorted, 0.99)
+
+	return stats
+}
+
+// percentile returns the nearest-rank percentile p of sorted, which must not
+// be empty.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(float64(len(sorted))*p)) - 1
+	return sorted[max(rank, 0)]
+}
@@ -0,0 +1,79 @@
+package limiter
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestStatsCollector_AggregatesPerKey(t *testing.T) {
+	stats := NewStatsCollector(10)
+
+	stats.OnAllow("a")
+	stats.OnAllow("a")
+	stats.OnDeny("a")
+	stats.OnError("a", errors.New("boom"))
+	for i := 1; i <= 100; i++ {
+		stats.OnLatency("a", time.Duration(i)*time.Millisecond)
+	}
+
+	got := stats.Stats()["a"]
+	want := KeyStats{Allows: 2, Denies: 1, Errors: 1, P50: 50 * time.Millisecond, P99: 99 * time.Millisecond}
+	if got != want {
+		t.Errorf("expected %+v, got %+v", want, got)
+	}
+}
+
+func TestStatsCollector_TopDenied(t *testing.T) {
+	stats := NewStatsCollector(10)
+
+	for key, denies := range map[string]int{"a": 1, "b": 3, "c": 2, "d": 2} {
+		for range denies {
+			stats.OnDeny(key)
+		}
+	}
+	stats.OnAllow("e")
+
+	want := []KeyDenies{{"b", 3}, {"c", 2}, {"d", 2}}
+	if top := stats.TopDenied(3); !slices.Equal(top, want) {
+		t.Errorf("expected %v, got %v", want, top)
+	}
+
+	if top := stats.TopDenied(10); len(top) != 4 {
+		t.Errorf("expected keys never denied to be left out, got %v", top)
+	}
+
+	if top := stats.TopDenied(-1); len(top) != 0 {
+		t.Errorf("expected a negative n to return no keys, got %v", top)
+	}
+}
+
+func TestStatsCollector_EvictsLeastRecentlySeen(t *testing.T) {
+	stats := NewStatsCollector(2)
+
+	stats.OnDeny("a")
+	stats.OnDeny("b")
+	stats.OnAllow("a")
+	stats.OnDeny("c")
+
+	got := stats.Stats()
+	if _, ok := got["b"]; ok || len(got) != 2 {
+		t.Errorf("expected b to be evicted, got %v", got)
+	}
+}
+
+func TestStatsCollector_LatencyWindow(t *testing.T) {
+	stats := NewStatsCollector(1)
+
+	for range latencySamples {
+		stats.OnLatency("a", time.Second)
+	}
+	for range latencySamples {
+		stats.OnLatency("a", time.Millisecond)
+	}
+
+	if p99 := stats.Stats()["a"].P99; p99 != time.Millisecond {
+		t.Errorf("expected old latencies to be overwritten, got p99 %v", p99)
+	}
+}
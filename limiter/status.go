@@ -0,0 +1,44 @@
+package limiter
+
+import (
+	"fmt"
+	"time"
+)
+
+// Status is a point-in-time view of a key's usage, intended for support
+// tooling rather than metrics.
+type Status struct {
+	Key       string
+	Used      float64
+	Limit     float64
+	ResetIn   time.Duration
+	Throttled bool
+}
+
+// String renders the status in plain English, e.g.
+// "Used 45 of 100 this window, resets in 12m, currently throttled." A ResetIn
+// of NeverAvailable reads "never resets".
+func (s Status) String() string {
+	reset := "resets in " + humanDuration(s.ResetIn)
+	if s.ResetIn == NeverAvailable {
+		reset = "never resets"
+	}
+
+	str := fmt.Sprintf("Used %g of %g this window, %s", s.Used, s.Limit, reset)
+	if s.Throttled {
+		str += ", currently throttled"
+	}
+	return str + "."
+}
+
+func humanDuration(d time.Duration) string {
+	switch {
+	case d >= time.Hour:
+		d = d.Round(time.Minute)
+		return fmt.Sprintf("%dh%dm", int(d.Hours()), int(d.Minutes())%60)
+	case d >= time.Minute:
+		return fmt.Sprintf("%dm", int(d.Round(time.Minute).Minutes()))
+	default:
+		return fmt.Sprintf("%ds", int(d.Round(time.Second).Seconds()))
+	}
+}
@@ -0,0 +1,44 @@
+package limiter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStatus_String(t *testing.T) {
+	cases := []struct {
+		status   Status
+		expected string
+	}{
+		{Status{Used: 45, Limit: 100, ResetIn: 12 * time.Minute, Throttled: true}, "Used 45 of 100 this window, resets in 12m, currently throttled."},
+		{Status{Used: 2.5, Limit: 10, ResetIn: 90 * time.Minute}, "Used 2.5 of 10 this window, resets in 1h30m."},
+		{Status{Used: 0, Limit: 10, ResetIn: 0}, "Used 0 of 10 this window, resets in 0s."},
+		{Status{Used: 5, Limit: 5, ResetIn: NeverAvailable, Throttled: true}, "Used 5 of 5 this window, never resets, currently throttled."},
+	}
+
+	for _, tc := range cases {
+		if s := tc.status.String(); s != tc.expected {
+			t.Errorf("expected %q, got %q", tc.expected, s)
+		}
+	}
+}
+
+func TestKeyedLimiter_StatusWithoutRefill(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	keyedLimiter := NewKeyedLimiter(5, 0, clock)
+
+	keyedLimiter.Allow("user1", 0)
+	if status := keyedLimiter.Status("user1"); status.ResetIn != 0 {
+		t.Errorf("expected a full bucket to need no reset, got %v", status.ResetIn)
+	}
+
+	keyedLimiter.Allow("user1", 5)
+	status := keyedLimiter.Status("user1")
+	if status.ResetIn != NeverAvailable {
+		t.Errorf("expected a bucket that never refills never to reset, got %v", status.ResetIn)
+	}
+
+	if got, want := keyedLimiter.Describe("user1"), "Used 5 of 5 this window, never resets, currently throttled."; got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
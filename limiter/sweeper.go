@@ -0,0 +1,82 @@
+package limiter
+
+import (
+	"sync"
+	"time"
+)
+
+// Evictor is a limiter that can drop state for idle keys.
+type Evictor interface {
+	EvictIdle() int
+}
+
+// Sweeper calls EvictIdle on every registered limiter from a single ticker
+// goroutine, so the number of sweeping goroutines doesn't grow with the
+// number of limiters.
+type Sweeper struct {
+	mu        sync.Mutex
+	evictors  map[Evictor]struct{}
+	stop      chan struct{}
+	closeOnce sync.Once
+}
+
+func NewSweeper(interval time.Duration) *Sweeper {
+	s := &Sweeper{
+		evictors: make(map[Evictor]struct{}),
+		stop:     make(chan struct{}),
+	}
+
+	go s.run(interval)
+
+	return s
+}
+
+func (s *Sweeper) Register(e Evictor) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.evictors[e] = struct{}{}
+}
+
+func (s *Sweeper) Deregister(e Evictor) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.evictors, e)
+}
+
+// Close stops the sweeper goroutine. It is safe to call more than once.
+func (s *Sweeper) Close() {
+	s.closeOnce.Do(func() {
+		close(s.stop)
+	})
+}
+
+func (s *Sweeper) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.sweep()
+		}
+	}
+}
+
+// sweep evicts idle keys from every registered limiter. The registry is
+// copied first so a slow EvictIdle doesn't block Register or Deregister.
+func (s *Sweeper) sweep() {
+	s.mu.Lock()
+	evictors := make([]Evictor, 0, len(s.evictors))
+	for e := range s.evictors {
+		evictors = append(evictors, e)
+	}
+	s.mu.Unlock()
+
+	for _, e := range evictors {
+		e.EvictIdle()
+	}
+}
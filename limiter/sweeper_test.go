@@ -0,0 +1,56 @@
+package limiter
+
+import (
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestSweeper_EvictsAllRegisteredLimiters(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	before := runtime.NumGoroutine()
+
+	sweeper := NewSweeper(time.Hour)
+	defer sweeper.Close()
+
+	var limiters []*KeyedLimiter
+	for range 10 {
+		kl := NewKeyedLimiter(5, 1, clock, WithIdleTTL(time.Minute), WithSweeper(sweeper))
+		kl.Allow("user-1", 1)
+		limiters = append(limiters, kl)
+	}
+
+	if added := runtime.NumGoroutine() - before; added > 1 {
+		t.Errorf("expected a single sweeper goroutine, got %d new goroutines", added)
+	}
+
+	clock.Advance(2 * time.Minute)
+	sweeper.sweep()
+
+	for i, kl := range limiters {
+		if kl.size() != 0 {
+			t.Errorf("expected limiter %d to be swept", i)
+		}
+	}
+}
+
+func TestSweeper_CloseDeregisters(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	sweeper := NewSweeper(time.Hour)
+	defer sweeper.Close()
+
+	kl := NewKeyedLimiter(5, 1, clock, WithIdleTTL(time.Minute), WithSweeper(sweeper))
+	kl.Allow("user-1", 1)
+	kl.Close()
+
+	clock.Advance(2 * time.Minute)
+	sweeper.sweep()
+
+	if kl.size() != 1 {
+		t.Error("expected a closed limiter not to be swept")
+	}
+
+	if len(sweeper.evictors) != 0 {
+		t.Errorf("expected no registered limiters, got %d", len(sweeper.evictors))
+	}
+}
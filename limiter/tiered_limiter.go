@@ -0,0 +1,230 @@
+package limiter
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// TieredLimiter checks a local in-memory tier before the shared remote
+// limiter, so a node over its share is denied without a round trip. The local
+// tier is provisioned at the global limit divided by the node count.
+//
+// Unlike FailDegrade, the local tier is always on: it only ever denies early,
+// and the remote limiter still decides every request it lets through. Tokens
+// taken locally for a request the remote limiter denies are refunded.
+type TieredLimiter struct {
+	mu          sync.Mutex
+	local       *KeyedLimiter
+	remote      Limiter
+	capacity    float64
+	refillRate  float64
+	clock       Clock
+	ramp        time.Duration
+	fromShare   float64
+	toShare     float64
+	rampStart   time.Time
+	lastApplied time.Time
+	fraction    float64
+	reconcile   time.Duration
+	stop        chan struct{}
+	closeOnce   sync.Once
+}
+
+// remainingLimiter is implemented by remote limiters that can report a key's
+// tokens without consuming any, such as RedisLimiter.
+type remainingLimiter interface {
+	Remaining(key string) (float64, error)
+}
+
+type TieredOption func(*TieredLimiter)
+
+// WithNodeRamp sets how long SetNodeCount takes to move the local share to
+// its new value. The default is 30s.
+func WithNodeRamp(d time.Duration) TieredOption {
+	return func(tl *TieredLimiter) {
+		tl.ramp = d
+	}
+}
+
+// WithLocalCapacityFraction provisions the local tier at f times the node's
+// share of the global limit instead of exactly its share. Above 1 a node may
+// run ahead of its share when others are idle, leaving the remote limiter to
+// enforce the global limit; below 1 more requests are denied locally.
+func WithLocalCapacityFraction(f float64) TieredOption {
+	return func(tl *TieredLimiter) {
+		tl.fraction = f
+	}
+}
+
+// WithReconcileInterval calls Reconcile every d from a background goroutine.
+// Call Close to stop it.
+func WithReconcileInterval(d time.Duration) TieredOption {
+	return func(tl *TieredLimiter) {
+		tl.reconcile = d
+	}
+}
+
+func NewTieredLimiter(remote Limiter, capacity float64, refillRate float64, nodeCount int, clock Clock, opts ...TieredOption) *TieredLimiter {
+	share := 1 / float64(max(nodeCount, 1))
+	tl := &TieredLimiter{
+		remote:     remote,
+		capacity:   capacity,
+		refillRate: refillRate,
+		clock:      clock,
+		ramp:       30 * time.Second,
+		fromShare:  share,
+		toShare:    share,
+		rampStart:  clock.Now(),
+		fraction:   1,
+	}
+
+	for _, opt := range opts {
+		opt(tl)
+	}
+
+	tl.local = NewKeyedLimiter(capacity*share*tl.fraction, refillRate*share*tl.fraction, clock)
+
+	if tl.reconcile > 0 {
+		tl.stop = make(chan struct{})
+		go tl.reconcileEvery(tl.reconcile)
+	}
+
+	return tl
+}
+
+func (tl *TieredLimiter) Allow(key string, tokens int) bool {
+	tl.applyShare()
+
+	bucket := tl.local.getOrCreateBucket(key)
+	if !bucket.Allow(tokens) {
+		return false
+	}
+
+	if !tl.remote.Allow(key, tokens) {
+		bucket.refund(float64(tokens))
+		return false
+	}
+
+	return true
+}
+
+func (tl *TieredLimiter) Wait(ctx context.Context, key string, tokens int) error {
+	tl.applyShare()
+
+	if err := tl.local.Wait(ctx, key, tokens); err != nil {
+		return err
+	}
+
+	if err := tl.remote.Wait(ctx, key, tokens); err != nil {
+		tl.local.getOrCreateBucket(key).refund(float64(tokens))
+		return err
+	}
+
+	return nil
+}
+
+// SetNodeCount changes the number of nodes sharing the global limit. The
+// local share ramps linearly to its new value rather than jumping, so scaling
+// the fleet doesn't cause a burst or a throttle.
+func (tl *TieredLimiter) SetNodeCount(n int) {
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+
+	now := tl.clock.Now()
+	tl.fromShare = tl.shareAt(now)
+	tl.toShare = 1 / float64(max(n, 1))
+	tl.rampStart = now
+}
+
+// LocalShare returns the fraction of the global limit currently provisioned
+// to the local tier.
+func (tl *TieredLimiter) LocalShare() float64 {
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+
+	return tl.shareAt(tl.clock.Now())
+}
+
+// applyShare resizes the local tier while a ramp is in progress, at most ten
+// times per ramp to avoid resizing every bucket on every call.
+func (tl *TieredLimiter) applyShare() {
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+
+	now := tl.clock.Now()
+	if tl.lastApplied.After(tl.rampStart.Add(tl.ramp)) {
+		return
+	}
+
+	if now.Sub(tl.lastApplied) < tl.ramp/10 && now.Before(tl.rampStart.Add(tl.ramp)) {
+		return
+	}
+
+	share := tl.shareAt(now) * tl.fraction
+	tl.local.SetLimits(tl.capacity*share, tl.refillRate*share)
+	tl.lastApplied = now
+}
+
+// shareAt must be called with tl.mu held.
+func (tl *TieredLimiter) shareAt(t time.Time) float64 {
+	elapsed := t.Sub(tl.rampStart)
+	if tl.ramp <= 0 || elapsed >= tl.ramp {
+		return tl.toShare
+	}
+
+	progress := float64(elapsed) / float64(tl.ramp)
+	return tl.fromShare + (tl.toShare-tl.fromShare)*progress
+}
+
+// Reconcile lowers each local bucket to the tokens the remote limiter reports
+// for its key, so once a key is exhausted globally this node denies it locally
+// too. Local buckets are never raised. It does nothing if the remote limiter
+// can't report remaining tokens, and returns the first error it gets.
+func (tl *TieredLimiter) Reconcile() error {
+	rl, ok := tl.remote.(remainingLimiter)
+	if !ok {
+		return nil
+	}
+
+	var firstErr error
+	for _, key := range tl.local.keys() {
+		remaining, err := rl.Remaining(key)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+
+		tl.local.lowerTokens(key, remaining)
+	}
+
+	return firstErr
+}
+
+// Close stops background reconciliation. The limiter keeps working
+// afterwards. It is safe to call more than once and always returns nil.
+func (tl *TieredLimiter) Close() error {
+	tl.closeOnce.Do(func() {
+		if tl.stop != nil {
+			close(tl.stop)
+		}
+	})
+
+	return nil
+}
+
+func (tl *TieredLimiter) reconcileEvery(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-tl.stop:
+			return
+		case <-ticker.C:
+			tl.Reconcile()
+		}
+	}
+}
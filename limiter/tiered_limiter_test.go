@@ -0,0 +1,147 @@
+package limiter
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTieredLimiter_LocalTierDeniesOverShare(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	remote := NewKeyedLimiter(100, 0, clock)
+	limiter := NewTieredLimiter(remote, 100, 0, 4, clock)
+
+	if !limiter.Allow("user-1", 25) {
+		t.Error("expected allow within the local share")
+	}
+
+	if limiter.Allow("user-1", 1) {
+		t.Error("expected the local tier to deny over its share")
+	}
+
+	if remote.Status("user-1").Used != 25 {
+		t.Errorf("expected the remote to only see admitted requests, got %f", remote.Status("user-1").Used)
+	}
+}
+
+func TestTieredLimiter_RemoteDenyRefundsLocalTier(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	remote := NewKeyedLimiter(100, 0, clock)
+	remote.Allow("user-1", 95)
+	limiter := NewTieredLimiter(remote, 100, 0, 4, clock)
+
+	if limiter.Allow("user-1", 10) {
+		t.Fatal("expected the remote limiter to deny")
+	}
+
+	if used := limiter.local.Status("user-1").Used; used != 0 {
+		t.Errorf("expected the local tokens to be refunded, got %f used", used)
+	}
+
+	if !limiter.Allow("user-1", 5) {
+		t.Error("expected the refunded local tier to admit what the remote has left")
+	}
+}
+
+func TestTieredLimiter_WaitRefundsLocalTierOnRemoteError(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	remote := NewKeyedLimiter(100, 0, clock)
+	remote.Allow("user-1", 100)
+	limiter := NewTieredLimiter(remote, 100, 0, 4, clock)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := limiter.Wait(ctx, "user-1", 10); err == nil {
+		t.Fatal("expected the remote wait to fail")
+	}
+
+	if used := limiter.local.Status("user-1").Used; used != 0 {
+		t.Errorf("expected the local tokens to be refunded, got %f used", used)
+	}
+}
+
+func TestTieredLimiter_SetNodeCountRamps(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	remote := NewKeyedLimiter(100, 0, clock)
+	limiter := NewTieredLimiter(remote, 100, 10, 4, clock, WithNodeRamp(10*time.Second))
+
+	limiter.SetNodeCount(2)
+
+	if share := limiter.LocalShare(); share != 0.25 {
+		t.Errorf("expected share to start at 0.25, got %f", share)
+	}
+
+	clock.Advance(5 * time.Second)
+	if share := limiter.LocalShare(); share != 0.375 {
+		t.Errorf("expected share to be 0.375 halfway through the ramp, got %f", share)
+	}
+
+	limiter.Allow("user-1", 0)
+	if config := limiter.local.Config(); config.Capacity != 37.5 {
+		t.Errorf("expected local capacity to be 37.5, got %f", config.Capacity)
+	}
+
+	clock.Advance(5 * time.Second)
+	limiter.Allow("user-1", 0)
+	if config := limiter.local.Config(); config.Capacity != 50 || config.RefillRate != 5 {
+		t.Errorf("expected local capacity 50 and rate 5, got %f and %f", config.Capacity, config.RefillRate)
+	}
+}
+
+func TestTieredLimiter_LocalCapacityFraction(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	remote := NewKeyedLimiter(100, 0, clock)
+	limiter := NewTieredLimiter(remote, 100, 10, 4, clock, WithLocalCapacityFraction(2))
+
+	if config := limiter.local.Config(); config.Capacity != 50 || config.RefillRate != 5 {
+		t.Errorf("expected local capacity 50 and rate 5, got %f and %f", config.Capacity, config.RefillRate)
+	}
+}
+
+func TestTieredLimiter_ReconcileLowersLocalTier(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	remote := NewKeyedLimiter(100, 0, clock)
+	limiter := NewTieredLimiter(remote, 100, 0, 4, clock)
+
+	limiter.Allow("user-1", 5)
+	limiter.Allow("user-2", 5)
+
+	// Other nodes drain user-1 globally.
+	remote.Allow("user-1", 93)
+
+	if err := limiter.Reconcile(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got, _ := limiter.local.Remaining("user-1"); got != 2 {
+		t.Errorf("expected the local tier lowered to the remote's 2 tokens, got %f", got)
+	}
+
+	if got, _ := limiter.local.Remaining("user-2"); got != 20 {
+		t.Errorf("expected the local tier never to be raised, got %f", got)
+	}
+
+	if limiter.Allow("user-1", 3) {
+		t.Error("expected the local tier to deny without asking the remote")
+	}
+}
+
+func TestTieredLimiter_BackgroundReconcile(t *testing.T) {
+	remote := NewKeyedLimiter(100, 0, RealClock{})
+	limiter := NewTieredLimiter(remote, 100, 0, 4, RealClock{}, WithReconcileInterval(5*time.Millisecond))
+	defer limiter.Close()
+
+	limiter.Allow("user-1", 1)
+	remote.Allow("user-1", 99)
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if got, _ := limiter.local.Remaining("user-1"); got == 0 {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	t.Error("expected the background reconcile to lower the local tier")
+}
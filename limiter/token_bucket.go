@@ -1,7 +1,10 @@
 package limiter
 
 import (
+	"container/heap"
 	"context"
+	"math"
+	"math/rand/v2"
 	"sync"
 	"time"
 )
@@ -13,40 +16,163 @@ type TokenBucket struct {
 	lastRefill time.Time
 	clock      Clock
 	mu         sync.Mutex
+	// changed is closed and replaced whenever the limits change, waking
+	// waiters so they recompute their delay.
+	changed chan struct{}
+	// maxRefillInterval caps the elapsed time a single refill credits.
+	maxRefillInterval time.Duration
+	waitJitter        float64
+	// waiters queues goroutines blocked in Wait, served head first.
+	waiters waitQueue
+	waitSeq uint64
 }
 
-func NewTokenBucket(capacity float64, refillRate float64, clock Clock) *TokenBucket {
-	return &TokenBucket{
+type TokenBucketOption func(*TokenBucket)
+
+// WithMaxRefillInterval caps how much time a single refill credits at d, so a
+// long process pause (GC, VM migration, sleep) doesn't grant a burst that
+// wasn't earned while the process was running. Waits longer than d take more
+// than one refill to satisfy, so d should exceed the longest expected wait.
+func WithMaxRefillInterval(d time.Duration) TokenBucketOption {
+	return func(tb *TokenBucket) {
+		tb.maxRefillInterval = d
+	}
+}
+
+// WithBucketWaitJitter randomizes each sleep in Wait by up to ±fraction, so
+// goroutines waiting on the bucket don't all wake at the same instant. A sleep
+// is never longer than the time left before the context deadline.
+func WithBucketWaitJitter(fraction float64) TokenBucketOption {
+	return func(tb *TokenBucket) {
+		tb.waitJitter = fraction
+	}
+}
+
+func NewTokenBucket(capacity float64, refillRate float64, clock Clock, opts ...TokenBucketOption) *TokenBucket {
+	tb := &TokenBucket{
 		capacity:   capacity,
 		refillRate: refillRate,
 		tokens:     capacity,
 		lastRefill: clock.Now(),
 		clock:      clock,
+		changed:    make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(tb)
 	}
+
+	return tb
+}
+
+// NewTokenBucketRate returns a TokenBucket refilling at rate that holds up to
+// burst tokens, or rate.Limit tokens if burst is zero.
+func NewTokenBucketRate(rate Rate, burst int, clock Clock, opts ...TokenBucketOption) *TokenBucket {
+	return NewTokenBucket(rate.capacity(burst), rate.TokensPerSecond(), clock, opts...)
+}
+
+// NewTokenBucketPerMinute returns a TokenBucket allowing limit tokens per
+// minute with bursts of up to burst.
+func NewTokenBucketPerMinute(limit int, burst int, clock Clock) *TokenBucket {
+	return NewTokenBucketRate(PerMinute(limit), burst, clock)
 }
 
+// refill credits tokens for the time since the last refill. If the clock has
+// gone backwards, e.g. after an NTP correction, nothing is credited and the
+// refill clock restarts from now, rather than stalling until the clock
+// catches back up.
 func (tb *TokenBucket) refill() {
-	now := tb.clock.Now()
+	tb.refillAt(tb.clock.Now())
+}
+
+// refillAt refills the bucket as of now.
+func (tb *TokenBucket) refillAt(now time.Time) {
 	elapsed := now.Sub(tb.lastRefill).Seconds()
 
+	if elapsed < 0 {
+		tb.lastRefill = now
+		return
+	}
+
 	if elapsed > 0 {
+		if tb.maxRefillInterval > 0 {
+			elapsed = min(elapsed, tb.maxRefillInterval.Seconds())
+		}
+
 		tokensToAdd := elapsed * tb.refillRate
 		tb.tokens = min(tb.tokens+tokensToAdd, tb.capacity)
 		tb.lastRefill = now
 	}
 }
 
+// Allow reports whether the requested tokens were consumed. A request for zero
+// tokens only refills the bucket and is always allowed; a negative request is
+// always denied so it can't add tokens.
 func (tb *TokenBucket) Allow(requested int) bool {
+	return tb.AllowFloat(float64(requested))
+}
+
+// AllowFloat behaves like Allow for a fractional cost, such as 0.5 for a cheap
+// read. A NaN cost is denied like a negative one.
+func (tb *TokenBucket) AllowFloat(requested float64) bool {
+	if invalidCost(requested) {
+		return false
+	}
+
 	tb.mu.Lock()
 	defer tb.mu.Unlock()
 
 	tb.refill()
 
-	if float64(requested) > tb.capacity {
+	if requested > tb.capacity {
 		return false
 	}
 
-	if tb.tokens >= float64(requested) {
+	if tb.tokens >= requested {
+		tb.tokens -= requested
+		return true
+	}
+
+	return false
+}
+
+// AllowPartial takes as many whole tokens as are available, up to requested,
+// and returns how many it took, for work that can proceed in part, such as a
+// download metered in bytes. A grant of 0 means the bucket is empty, and
+// nothing is ever granted beyond the capacity.
+func (tb *TokenBucket) AllowPartial(requested int) int {
+	if requested <= 0 {
+		return 0
+	}
+
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	tb.refill()
+
+	// A reservation can leave the bucket in debt, which grants nothing.
+	granted := max(0, min(requested, int(tb.tokens)))
+	tb.tokens -= float64(granted)
+
+	return granted
+}
+
+// AllowAt behaves like Allow as of at instead of the clock's now, for
+// replaying recorded traffic. Calls must come in non-decreasing order of at;
+// an earlier time is handled like the clock going backwards, crediting
+// nothing. Mixing AllowAt with calls that read the clock gives meaningless
+// refills.
+func (tb *TokenBucket) AllowAt(requested int, at time.Time) bool {
+	if requested < 0 {
+		return false
+	}
+
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	tb.refillAt(at)
+
+	if float64(requested) <= tb.capacity && tb.tokens >= float64(requested) {
 		tb.tokens -= float64(requested)
 		return true
 	}
@@ -54,49 +180,523 @@ func (tb *TokenBucket) Allow(requested int) bool {
 	return false
 }
 
+// AllowChecked behaves like Allow but tells an impossible request apart from a
+// throttled one: it returns ErrExceedsCapacity if requested can never fit in
+// the bucket and ErrNegativeTokens if it is negative, while (false, nil) means
+// retrying later can succeed.
+func (tb *TokenBucket) AllowChecked(requested int) (bool, error) {
+	if requested < 0 {
+		return false, ErrNegativeTokens
+	}
+
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	if float64(requested) > tb.capacity {
+		return false, ErrExceedsCapacity
+	}
+
+	tb.refill()
+
+	if tb.tokens >= float64(requested) {
+		tb.tokens -= float64(requested)
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// AllowWithLevel behaves like Allow and also returns the token count left
+// after the decision.
+func (tb *TokenBucket) AllowWithLevel(requested int) (bool, float64) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	tb.refill()
+
+	if requested < 0 || float64(requested) > tb.tokens {
+		return false, tb.tokens
+	}
+
+	tb.tokens -= float64(requested)
+	return true, tb.tokens
+}
+
+// AllowWithRetry behaves like Allow and, on denial, also returns how long until
+// enough tokens will have refilled to satisfy the request, or NeverAvailable if
+// waiting can't help.
+func (tb *TokenBucket) AllowWithRetry(requested int) (bool, time.Duration) {
+	return tb.allowWithRetry(float64(requested))
+}
+
+func (tb *TokenBucket) allowWithRetry(requested float64) (bool, time.Duration) {
+	if invalidCost(requested) {
+		return false, NeverAvailable
+	}
+
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	tb.refill()
+
+	if requested <= tb.capacity && tb.tokens >= requested {
+		tb.tokens -= requested
+		return true, 0
+	}
+
+	return false, tb.timeUntilAvailable(requested)
+}
+
+// check behaves like AllowWithRetry and returns the bucket's state with the
+// decision.
+func (tb *TokenBucket) check(requested int) (Result, error) {
+	if requested < 0 {
+		return Result{RetryAfter: NeverAvailable}, ErrNegativeTokens
+	}
+
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	tb.refill()
+
+	res := Result{Limit: tb.capacity}
+	if float64(requested) <= tb.capacity && tb.tokens >= float64(requested) {
+		tb.tokens -= float64(requested)
+		res.Allowed = true
+	} else {
+		res.RetryAfter = tb.timeUntilAvailable(float64(requested))
+	}
+
+	res.Remaining = tb.tokens
+	switch {
+	case tb.tokens >= tb.capacity:
+	case tb.refillRate <= 0:
+		res.ResetAfter = NeverAvailable
+	default:
+		res.ResetAfter = time.Duration((tb.capacity - tb.tokens) / tb.refillRate * float64(time.Second))
+	}
+
+	return res, nil
+}
+
+// Reserve takes the requested tokens now, even if that leaves the bucket in
+// debt, and returns a Reservation saying how long to wait before using them.
+// Unlike Wait it doesn't block, so callers can Cancel if the delay is too
+// long. If the bucket doesn't refill and can't cover the request, the
+// reservation is not OK and nothing is taken.
+func (tb *TokenBucket) Reserve(requested int) (*Reservation, error) {
+	if requested < 0 {
+		return nil, ErrNegativeTokens
+	}
+
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	if float64(requested) > tb.capacity {
+		return nil, ErrExceedsCapacity
+	}
+
+	delay := tb.timeUntilAvailable(float64(requested))
+	if delay == NeverAvailable {
+		return &Reservation{bucket: tb}, nil
+	}
+
+	tb.tokens -= float64(requested)
+
+	return &Reservation{
+		bucket:    tb,
+		tokens:    float64(requested),
+		timeToAct: tb.clock.Now().Add(delay),
+		ok:        true,
+	}, nil
+}
+
 // Wait blocks until the requested tokens are available or the context is cancelled.
-// Returns ErrExceedsCapacity if requested tokens exceed bucket capacity.
+// Returns ErrNegativeTokens if requested is negative.
+// Returns ErrExceedsCapacity if requested tokens exceed bucket capacity; a
+// request for exactly the capacity waits for a full bucket. See WaitClamp.
 // Returns ctx.Err() if context is cancelled or times out while waiting.
 func (tb *TokenBucket) Wait(ctx context.Context, requested int) error {
-	if float64(requested) > tb.capacity {
-		return ErrExceedsCapacity
+	_, err := tb.WaitDetailed(ctx, requested)
+	return err
+}
+
+// WaitDetailed behaves like Wait and also reports how many times it checked
+// the bucket, how long it blocked and whether it had to wait for a refill.
+func (tb *TokenBucket) WaitDetailed(ctx context.Context, requested int) (WaitResult, error) {
+	return tb.waitPriority(ctx, float64(requested), 0)
+}
+
+// WaitFloat behaves like Wait for a fractional cost.
+func (tb *TokenBucket) WaitFloat(ctx context.Context, requested float64) error {
+	_, err := tb.waitPriority(ctx, requested, 0)
+	return err
+}
+
+// WaitClamp behaves like Wait, but a request larger than the bucket's capacity
+// is clamped to it, waiting for a full bucket and taking all of it instead of
+// failing with ErrExceedsCapacity. It returns the tokens taken.
+func (tb *TokenBucket) WaitClamp(ctx context.Context, requested int) (float64, error) {
+	if requested < 0 {
+		return 0, ErrNegativeTokens
+	}
+
+	tb.mu.Lock()
+	tokens := min(float64(requested), tb.capacity)
+	tb.mu.Unlock()
+
+	if _, err := tb.waitPriority(ctx, tokens, 0); err != nil {
+		return 0, err
 	}
 
-	for {
+	return tokens, nil
+}
+
+// WaitPriority behaves like Wait, but when tokens are scarce waiters with a
+// higher priority are served first, and waiters with equal priority in the
+// order they arrived. Wait queues at priority 0. Allow doesn't queue, so it can
+// still take tokens ahead of waiters.
+func (tb *TokenBucket) WaitPriority(ctx context.Context, requested int, priority int) error {
+	_, err := tb.waitPriority(ctx, float64(requested), priority)
+	return err
+}
+
+func (tb *TokenBucket) waitPriority(ctx context.Context, requested float64, priority int) (WaitResult, error) {
+	var result WaitResult
+	start := time.Now()
+
+	if invalidCost(requested) {
+		return result, ErrNegativeTokens
+	}
+
+	var w *waiter
+	for attempts := 1; ; attempts++ {
 		tb.mu.Lock()
 
+		if requested > tb.capacity {
+			tb.leave(w)
+			tb.mu.Unlock()
+			return result, ErrExceedsCapacity
+		}
+
+		// Only the waiter at the head of the queue may take tokens, so join
+		// it unless it's empty and the request can be served now.
 		tb.refill()
-		if tb.tokens >= float64(requested) {
-			tb.tokens -= float64(requested)
+		if w == nil && (len(tb.waiters) > 0 || tb.tokens < requested) {
+			w = &waiter{priority: priority, seq: tb.waitSeq}
+			tb.waitSeq++
+			heap.Push(&tb.waiters, w)
+		}
+
+		head := w == nil || tb.waiters[0] == w
+		if head && tb.tokens >= requested {
+			tb.tokens -= requested
+			tb.leave(w)
 			tb.mu.Unlock()
-			return nil
+
+			result.Iterations = attempts
+			result.Waited = time.Since(start)
+			result.Path = WaitFastPath
+			if attempts > 1 {
+				result.Path = WaitAfterRefill
+			}
+			return result, nil
 		}
 
-		waitDuration := tb.timeUntilAvailable(requested)
+		// Waiters behind the head sleep until the queue or the limits change.
+		waitDuration := NeverAvailable
+		if head {
+			waitDuration = tb.timeUntilAvailable(requested)
+		}
+		changed := tb.changed
 		tb.mu.Unlock()
 
+		if tb.waitJitter > 0 && waitDuration != NeverAvailable {
+			waitDuration = jittered(waitDuration, tb.waitJitter, rand.Float64)
+			if deadline, ok := ctx.Deadline(); ok {
+				waitDuration = min(waitDuration, time.Until(deadline))
+			}
+		}
+
 		timer := time.NewTimer(waitDuration)
 		select {
 		case <-ctx.Done():
 			timer.Stop()
-			return ctx.Err()
+			tb.mu.Lock()
+			tb.leave(w)
+			tb.mu.Unlock()
+			return result, ctx.Err()
+		case <-changed:
+			timer.Stop()
 		case <-timer.C:
 			// Continue loop to try again
 		}
 	}
 }
 
+// leave removes w from the wait queue, if it is queued, and wakes the other
+// waiters so the new head starts its wait. Must be called with tb.mu held.
+func (tb *TokenBucket) leave(w *waiter) {
+	if w == nil || w.index < 0 {
+		return
+	}
+
+	heap.Remove(&tb.waiters, w.index)
+	tb.notifyChanged()
+}
+
+// waiter is a goroutine queued in WaitPriority.
+type waiter struct {
+	priority int
+	seq      uint64
+	index    int
+}
+
+// waitQueue is a heap of waiters, highest priority first and FIFO within a
+// priority.
+type waitQueue []*waiter
+
+func (q waitQueue) Len() int { return len(q) }
+
+func (q waitQueue) Less(i, j int) bool {
+	if q[i].priority != q[j].priority {
+		return q[i].priority > q[j].priority
+	}
+	return q[i].seq < q[j].seq
+}
+
+func (q waitQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *waitQueue) Push(x any) {
+	w := x.(*waiter)
+	w.index = len(*q)
+	*q = append(*q, w)
+}
+
+func (q *waitQueue) Pop() any {
+	old := *q
+	w := old[len(old)-1]
+	old[len(old)-1] = nil
+	w.index = -1
+	*q = old[:len(old)-1]
+	return w
+}
+
 // timeUntilAvailable calculates the duration until the requested tokens are available
 // Must be called with tb.mu held.
-func (tb *TokenBucket) timeUntilAvailable(requested int) time.Duration {
+func (tb *TokenBucket) timeUntilAvailable(requested float64) time.Duration {
 	tb.refill()
 
-	deficit := float64(requested) - tb.tokens
+	deficit := requested - tb.tokens
 
 	if deficit <= 0 {
 		return 0
 	}
 
+	if requested > tb.capacity || tb.refillRate <= 0 {
+		return NeverAvailable
+	}
+
 	seconds := deficit / tb.refillRate
 	return time.Duration(seconds * float64(time.Second))
 }
+
+// invalidCost reports whether a fractional cost is negative or NaN.
+func invalidCost(tokens float64) bool {
+	return tokens < 0 || math.IsNaN(tokens)
+}
+
+// SetLimits changes the bucket's capacity and refill rate, clamping tokens to
+// the new capacity. Tokens accrued so far are credited at the old rate, and
+// goroutines blocked in Wait recompute their delay against the new limits.
+func (tb *TokenBucket) SetLimits(capacity float64, refillRate float64) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	tb.refill()
+	tb.capacity = capacity
+	tb.refillRate = refillRate
+	tb.tokens = min(tb.tokens, capacity)
+	tb.notifyChanged()
+}
+
+// scaleLimits behaves like SetLimits but keeps the bucket at the same fraction
+// of its capacity.
+func (tb *TokenBucket) scaleLimits(capacity float64, refillRate float64) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	tb.refill()
+	if tb.capacity > 0 {
+		tb.tokens = tb.tokens / tb.capacity * capacity
+	} else {
+		tb.tokens = capacity
+	}
+	tb.capacity = capacity
+	tb.refillRate = refillRate
+	tb.notifyChanged()
+}
+
+// notifyChanged wakes waiters. Must be called with tb.mu held.
+func (tb *TokenBucket) notifyChanged() {
+	close(tb.changed)
+	tb.changed = make(chan struct{})
+}
+
+// refund returns tokens to the bucket without exceeding its capacity.
+func (tb *TokenBucket) refund(tokens float64) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	tb.refill()
+	tb.tokens = min(tb.tokens+tokens, tb.capacity)
+}
+
+// ExportState returns the bucket's state as of its last refill, for
+// checkpointing with a StateCodec.
+func (tb *TokenBucket) ExportState() BucketState {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	return BucketState{
+		Capacity:   tb.capacity,
+		RefillRate: tb.refillRate,
+		Tokens:     tb.tokens,
+		LastRefill: tb.lastRefill,
+	}
+}
+
+// Snapshot returns the bucket's state refilled to now. Unlike ExportState it
+// reflects the tokens a request would see, which makes it the one to assert on
+// in tests.
+func (tb *TokenBucket) Snapshot() BucketState {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	tb.refill()
+
+	return BucketState{
+		Capacity:   tb.capacity,
+		RefillRate: tb.refillRate,
+		Tokens:     tb.tokens,
+		LastRefill: tb.lastRefill,
+	}
+}
+
+// ImportState restores state taken with ExportState. Tokens accrue from
+// LastRefill, so a bucket restored after downtime refills for that time.
+func (tb *TokenBucket) ImportState(s BucketState) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	tb.capacity = s.Capacity
+	tb.refillRate = s.RefillRate
+	tb.tokens = s.Tokens
+	tb.lastRefill = s.LastRefill
+	tb.notifyChanged()
+}
+
+// DelayFor returns how long until requested tokens will be available, without
+// consuming any: 0 if they are available now, or NeverAvailable if waiting
+// can't help.
+func (tb *TokenBucket) DelayFor(requested int) time.Duration {
+	if requested < 0 {
+		return NeverAvailable
+	}
+
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	return tb.timeUntilAvailable(float64(requested))
+}
+
+// Acquire takes the tokens now if they are available, waits for them if they
+// will be before ctx's deadline, and otherwise returns ErrWouldExceedDeadline
+// straight away rather than waiting only to time out. Without a deadline it
+// behaves like Wait.
+func (tb *TokenBucket) Acquire(ctx context.Context, requested int) error {
+	delay := tb.DelayFor(requested)
+
+	if deadline, ok := ctx.Deadline(); ok && delay != NeverAvailable && time.Until(deadline) < delay {
+		return ErrWouldExceedDeadline
+	}
+
+	// Wait reports negative and over-capacity requests.
+	return tb.Wait(ctx, requested)
+}
+
+// AllowOrWait takes the requested tokens now if available, and otherwise
+// waits up to maxWait for them. See the package-level AllowOrWait.
+func (tb *TokenBucket) AllowOrWait(ctx context.Context, requested int, maxWait time.Duration) (bool, time.Duration, error) {
+	return allowOrWait(ctx, maxWait,
+		func() bool { return tb.Allow(requested) },
+		func(ctx context.Context) error { return tb.Wait(ctx, requested) })
+}
+
+// AddTokens credits the bucket with tokens without exceeding its capacity. A
+// negative amount debits it, down to zero. It returns the new token count.
+func (tb *TokenBucket) AddTokens(tokens float64) float64 {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	tb.refill()
+	return tb.setTokens(tb.tokens + tokens)
+}
+
+// SetTokens sets the bucket to tokens, clamped between zero and its capacity,
+// and returns the new token count.
+func (tb *TokenBucket) SetTokens(tokens float64) float64 {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	tb.refill()
+	return tb.setTokens(tokens)
+}
+
+// setTokens must be called with tb.mu held.
+func (tb *TokenBucket) setTokens(tokens float64) float64 {
+	tb.tokens = max(0, min(tokens, tb.capacity))
+	tb.notifyChanged()
+	return tb.tokens
+}
+
+// Reset refills the bucket to capacity, as if newly created.
+func (tb *TokenBucket) Reset() {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	tb.tokens = tb.capacity
+	tb.lastRefill = tb.clock.Now()
+	tb.notifyChanged()
+}
+
+// Tokens returns the current token count, refilled to now.
+func (tb *TokenBucket) Tokens() float64 {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	tb.refill()
+	return tb.tokens
+}
+
+func (tb *TokenBucket) Rate() float64 {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	return tb.refillRate
+}
+
+// SetRate changes the refill rate. Tokens accrued so far are credited at the
+// old rate first.
+func (tb *TokenBucket) SetRate(refillRate float64) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	tb.refill()
+	tb.refillRate = refillRate
+	tb.notifyChanged()
+}
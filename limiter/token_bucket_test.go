@@ -2,6 +2,8 @@ package limiter
 
 import (
 	"context"
+	"math"
+	"slices"
 	"sync"
 	"testing"
 	"time"
@@ -56,6 +58,42 @@ func TestAllow_DeniesWhenInsufficient(t *testing.T) {
 
 }
 
+func TestTokenBucket_AllowFloat(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	bucket := NewTokenBucket(3, 1, clock)
+
+	if !bucket.AllowFloat(2.5) || !bucket.AllowFloat(0.5) {
+		t.Fatal("expected fractional costs summing to capacity to be allowed")
+	}
+
+	if bucket.AllowFloat(0.5) {
+		t.Error("expected deny on an empty bucket")
+	}
+
+	clock.Advance(500 * time.Millisecond)
+
+	if !bucket.AllowFloat(0.5) {
+		t.Error("expected half a token to refill in 500ms")
+	}
+
+	if bucket.AllowFloat(-0.5) || bucket.AllowFloat(math.NaN()) {
+		t.Error("expected negative and NaN costs to be denied")
+	}
+}
+
+func TestTokenBucket_WaitFloat(t *testing.T) {
+	bucket := NewTokenBucket(1, 20, RealClock{})
+	bucket.AllowFloat(1)
+
+	if err := bucket.WaitFloat(context.Background(), 0.5); err != nil {
+		t.Fatalf("expected wait for half a token to succeed, got %v", err)
+	}
+
+	if err := bucket.WaitFloat(context.Background(), 1.5); err != ErrExceedsCapacity {
+		t.Errorf("expected ErrExceedsCapacity, got %v", err)
+	}
+}
+
 func TestAllow_DeniesWhenExceedsCapacity(t *testing.T) {
 	clock := &MockClock{current: time.Now()}
 	bucket := NewTokenBucket(10, 2, clock)
@@ -247,3 +285,432 @@ func TestWait_ConcurrentWaiters(t *testing.T) {
 		}
 	}
 }
+
+func TestAllow_DeniesNegativeTokens(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	bucket := NewTokenBucket(10, 2, clock)
+
+	bucket.Allow(5)
+
+	if bucket.Allow(-5) {
+		t.Error("expected negative request to be denied")
+	}
+
+	if bucket.tokens != 5 {
+		t.Errorf("expected 5 tokens remaining, got %f", bucket.tokens)
+	}
+}
+
+func TestAllow_ZeroTokensAlwaysAllowed(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	bucket := NewTokenBucket(10, 2, clock)
+
+	bucket.Allow(10)
+
+	if !bucket.Allow(0) {
+		t.Error("expected zero token request to be allowed on an empty bucket")
+	}
+
+	if bucket.tokens != 0 {
+		t.Errorf("expected 0 tokens remaining, got %f", bucket.tokens)
+	}
+}
+
+func TestWait_ReturnsErrNegativeTokens(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	bucket := NewTokenBucket(10, 2, clock)
+
+	err := bucket.Wait(context.Background(), -1)
+
+	if err != ErrNegativeTokens {
+		t.Errorf("expected ErrNegativeTokens, got %v", err)
+	}
+}
+
+func TestTokens_AppliesRefill(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	bucket := NewTokenBucket(10, 2, clock)
+
+	bucket.Allow(10)
+	clock.Advance(1500 * time.Millisecond)
+
+	if tokens := bucket.Tokens(); tokens != 3 {
+		t.Errorf("expected 3 tokens after refill, got %f", tokens)
+	}
+
+	if !bucket.Allow(3) {
+		t.Error("expected Tokens not to consume anything")
+	}
+}
+
+func TestWaitDetailed_FastPath(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	bucket := NewTokenBucket(10, 2, clock)
+
+	result, err := bucket.WaitDetailed(context.Background(), 5)
+
+	if err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+
+	if result.Path != WaitFastPath || result.Iterations != 1 {
+		t.Errorf("expected fast path on the first iteration, got %+v", result)
+	}
+}
+
+func TestWaitDetailed_AfterRefill(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	bucket := NewTokenBucket(10, 100, clock)
+
+	bucket.Allow(10)
+
+	done := make(chan WaitResult)
+	go func() {
+		result, _ := bucket.WaitDetailed(context.Background(), 5)
+		done <- result
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	clock.Advance(100 * time.Millisecond)
+
+	select {
+	case result := <-done:
+		if result.Path != WaitAfterRefill || result.Iterations < 2 {
+			t.Errorf("expected to succeed after a refill, got %+v", result)
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Error("WaitDetailed did not return in time")
+	}
+}
+
+func TestAllowWithRetry_ReturnsRefillTime(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	bucket := NewTokenBucket(10, 2, clock)
+
+	if allowed, retry := bucket.AllowWithRetry(8); !allowed || retry != 0 {
+		t.Errorf("expected allow with no retry, got %v, %v", allowed, retry)
+	}
+
+	if allowed, retry := bucket.AllowWithRetry(5); allowed || retry != 1500*time.Millisecond {
+		t.Errorf("expected deny with 1.5s retry, got %v, %v", allowed, retry)
+	}
+
+	if allowed, retry := bucket.AllowWithRetry(15); allowed || retry != NeverAvailable {
+		t.Errorf("expected deny with NeverAvailable above capacity, got %v, %v", allowed, retry)
+	}
+}
+
+func TestAllowWithLevel(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	bucket := NewTokenBucket(10, 2, clock)
+
+	if allowed, level := bucket.AllowWithLevel(4); !allowed || level != 6 {
+		t.Errorf("expected allow with 6 tokens left, got %v, %f", allowed, level)
+	}
+
+	if allowed, level := bucket.AllowWithLevel(7); allowed || level != 6 {
+		t.Errorf("expected deny with 6 tokens left, got %v, %f", allowed, level)
+	}
+}
+
+func TestAllowChecked_DistinguishesThrottledFromImpossible(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	bucket := NewTokenBucket(10, 1, clock)
+
+	if allowed, err := bucket.AllowChecked(8); !allowed || err != nil {
+		t.Errorf("expected 8 tokens to be allowed, got %v, %v", allowed, err)
+	}
+
+	if allowed, err := bucket.AllowChecked(5); allowed || err != nil {
+		t.Errorf("expected a throttled request to return (false, nil), got %v, %v", allowed, err)
+	}
+
+	if _, err := bucket.AllowChecked(11); err != ErrExceedsCapacity {
+		t.Errorf("expected ErrExceedsCapacity, got %v", err)
+	}
+
+	if _, err := bucket.AllowChecked(-1); err != ErrNegativeTokens {
+		t.Errorf("expected ErrNegativeTokens, got %v", err)
+	}
+}
+
+func TestSetLimits_ClampsTokens(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	bucket := NewTokenBucket(10, 1, clock)
+
+	bucket.SetLimits(4, 2)
+
+	if bucket.Tokens() != 4 {
+		t.Errorf("expected tokens to be clamped to 4, got %f", bucket.Tokens())
+	}
+
+	bucket.Allow(4)
+	clock.Advance(time.Second)
+
+	if bucket.Tokens() != 2 {
+		t.Errorf("expected refill at the new rate, got %f", bucket.Tokens())
+	}
+}
+
+func TestSetLimits_WakesWaiters(t *testing.T) {
+	bucket := NewTokenBucket(1, 0, RealClock{})
+	bucket.Allow(1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	time.AfterFunc(10*time.Millisecond, func() { bucket.SetLimits(1, 1000) })
+
+	result, err := bucket.WaitDetailed(ctx, 1)
+	if err != nil {
+		t.Fatalf("expected the waiter to recompute against the new rate, got %v", err)
+	}
+
+	if result.Waited > 500*time.Millisecond {
+		t.Errorf("expected the wait to end soon after the rate change, got %v", result.Waited)
+	}
+}
+
+func TestSetLimits_WaiterExceedsShrunkCapacity(t *testing.T) {
+	bucket := NewTokenBucket(5, 0, RealClock{})
+	bucket.Allow(5)
+
+	time.AfterFunc(10*time.Millisecond, func() { bucket.SetLimits(2, 0) })
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := bucket.Wait(ctx, 3); err != ErrExceedsCapacity {
+		t.Errorf("expected ErrExceedsCapacity after the capacity shrank, got %v", err)
+	}
+}
+
+func TestTokenBucket_MaxRefillIntervalCapsBurst(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	bucket := NewTokenBucket(100, 10, clock, WithMaxRefillInterval(time.Second))
+
+	bucket.Allow(100)
+	clock.Advance(time.Hour)
+
+	if got := bucket.Tokens(); got != 10 {
+		t.Errorf("expected a long pause to credit only 10 tokens, got %v", got)
+	}
+
+	clock.Advance(500 * time.Millisecond)
+
+	if got := bucket.Tokens(); got != 15 {
+		t.Errorf("expected refills under the cap to be unaffected, got %v", got)
+	}
+}
+
+func TestTokenBucket_WaitJitterRespectsDeadline(t *testing.T) {
+	bucket := NewTokenBucket(1, 10, RealClock{}, WithBucketWaitJitter(1))
+	bucket.Allow(1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err := bucket.Wait(ctx, 1)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+
+	if waited := time.Since(start); waited > 80*time.Millisecond {
+		t.Errorf("expected the wait to end at the deadline, took %v", waited)
+	}
+}
+
+func TestTokenBucket_Reset(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	bucket := NewTokenBucket(5, 0, clock)
+
+	bucket.Allow(5)
+	bucket.Reset()
+
+	if !bucket.Allow(5) {
+		t.Error("expected a reset bucket to be full")
+	}
+}
+
+func TestTokenBucket_WaitPriorityServesHigherFirst(t *testing.T) {
+	bucket := NewTokenBucket(1, 20, RealClock{})
+	bucket.Allow(1)
+
+	var mu sync.Mutex
+	var order []int
+	var wg sync.WaitGroup
+
+	// Queue low priority waiters first so arrival order alone would serve them.
+	for _, priority := range []int{0, 0, 5, 10} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := bucket.WaitPriority(context.Background(), 1, priority); err != nil {
+				t.Errorf("unexpected error: %v", err)
+				return
+			}
+			mu.Lock()
+			order = append(order, priority)
+			mu.Unlock()
+		}()
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	wg.Wait()
+
+	want := []int{10, 5, 0, 0}
+	if !slices.Equal(order, want) {
+		t.Errorf("expected waiters served in order %v, got %v", want, order)
+	}
+}
+
+func TestTokenBucket_WaitPriorityCancelledWaiterLeavesQueue(t *testing.T) {
+	bucket := NewTokenBucket(1, 20, RealClock{})
+	bucket.Allow(1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- bucket.WaitPriority(context.Background(), 1, 0)
+	}()
+
+	if err := bucket.WaitPriority(ctx, 1, 10); err != context.DeadlineExceeded {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the remaining waiter to be served once the head left")
+	}
+
+	if len(bucket.waiters) != 0 {
+		t.Errorf("expected an empty wait queue, got %d waiters", len(bucket.waiters))
+	}
+}
+
+func TestTokenBucket_Snapshot(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	bucket := NewTokenBucket(10, 2, clock)
+	bucket.Allow(10)
+	clock.Advance(time.Second)
+
+	var provider StateProvider = bucket
+	state := provider.Snapshot()
+
+	want := BucketState{Capacity: 10, RefillRate: 2, Tokens: 2, LastRefill: clock.Now()}
+	if state != want {
+		t.Errorf("expected %+v, got %+v", want, state)
+	}
+}
+
+func TestTokenBucket_ClockMovesBackward(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	bucket := NewTokenBucket(10, 1, clock)
+	bucket.Allow(10)
+
+	clock.Advance(-time.Minute)
+
+	if bucket.Allow(1) {
+		t.Fatal("expected a backward jump not to add tokens")
+	}
+
+	clock.Advance(time.Second)
+
+	if !bucket.Allow(1) {
+		t.Error("expected refills to resume from the jumped-back time")
+	}
+}
+
+func TestTokenBucket_AllowAtRefillsFromGivenTime(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	tb := NewTokenBucket(2, 1, clock)
+	start := clock.Now().Add(-time.Hour)
+
+	if !tb.AllowAt(2, start) {
+		t.Fatal("expected the full bucket to allow")
+	}
+	if tb.AllowAt(1, start.Add(500*time.Millisecond)) {
+		t.Error("expected deny half a second later")
+	}
+	if !tb.AllowAt(1, start.Add(time.Second)) {
+		t.Error("expected a token a second after the first request")
+	}
+}
+
+func TestTokenBucket_WaitCapacityBoundary(t *testing.T) {
+	bucket := NewTokenBucket(2, 40, RealClock{})
+	bucket.Allow(2)
+
+	if err := bucket.Wait(context.Background(), 2); err != nil {
+		t.Errorf("expected a wait for exactly the capacity to succeed after a full refill, got %v", err)
+	}
+
+	if err := bucket.WaitFloat(context.Background(), 2+1e-9); err != ErrExceedsCapacity {
+		t.Errorf("expected ErrExceedsCapacity just above the capacity, got %v", err)
+	}
+}
+
+func TestTokenBucket_WaitClamp(t *testing.T) {
+	bucket := NewTokenBucket(2, 40, RealClock{})
+	bucket.Allow(1)
+
+	taken, err := bucket.WaitClamp(context.Background(), 5)
+	if err != nil || taken != 2 {
+		t.Fatalf("expected the request clamped to 2 tokens, got %v, %v", taken, err)
+	}
+
+	if remaining := bucket.Snapshot().Tokens; remaining >= 1 {
+		t.Errorf("expected the clamped wait to drain the bucket, %v left", remaining)
+	}
+
+	if taken, err := bucket.WaitClamp(context.Background(), -1); err != ErrNegativeTokens || taken != 0 {
+		t.Errorf("expected ErrNegativeTokens, got %v, %v", taken, err)
+	}
+}
+
+func TestTokenBucket_AllowPartial(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	bucket := NewTokenBucket(5, 2, clock)
+
+	if granted := bucket.AllowPartial(3); granted != 3 {
+		t.Fatalf("expected the full request to be granted, got %d", granted)
+	}
+	if granted := bucket.AllowPartial(10); granted != 2 {
+		t.Fatalf("expected only the 2 tokens left to be granted, got %d", granted)
+	}
+	if granted := bucket.AllowPartial(1); granted != 0 {
+		t.Fatalf("expected an empty bucket to grant nothing, got %d", granted)
+	}
+
+	clock.Advance(750 * time.Millisecond)
+	if granted := bucket.AllowPartial(5); granted != 1 {
+		t.Errorf("expected only whole tokens to be granted, got %d", granted)
+	}
+	if granted := bucket.AllowPartial(-1); granted != 0 {
+		t.Errorf("expected a negative request to grant nothing, got %d", granted)
+	}
+}
+
+func TestTokenBucket_AllowPartialAfterReserve(t *testing.T) {
+	clock := &MockClock{current: time.Now()}
+	bucket := NewTokenBucket(10, 1, clock)
+	bucket.Allow(10)
+
+	if _, err := bucket.Reserve(5); err != nil {
+		t.Fatalf("expected the reservation to succeed, got %v", err)
+	}
+
+	if granted := bucket.AllowPartial(3); granted != 0 {
+		t.Errorf("expected a bucket in debt to grant nothing, got %d", granted)
+	}
+	if tokens := bucket.Snapshot().Tokens; tokens != -5 {
+		t.Errorf("expected the debt to be untouched, got %v tokens", tokens)
+	}
+}
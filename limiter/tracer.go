@@ -0,0 +1,28 @@
+package limiter
+
+import (
+	"context"
+	"time"
+)
+
+// Tracer starts a span around each RedisLimiter call. It keeps the core free
+// of any tracing dependency; the tracing subpackage adapts OpenTelemetry.
+type Tracer interface {
+	Start(ctx context.Context, operation string, key string) (context.Context, Span)
+}
+
+// Span receives the outcome of one Allow or Wait call. redisLatency is the
+// duration of the last Redis round trip, or zero if Redis wasn't reached. err
+// is set when the circuit was open or Redis failed, even if the failure mode
+// went on to allow the request.
+type Span interface {
+	End(allowed bool, tokens float64, redisLatency time.Duration, err error)
+}
+
+// WithTracer wraps each Allow, AllowClassed, AllowWithRetry and Wait call in a
+// span from t.
+func WithTracer(t Tracer) Option {
+	return func(r *RedisLimiter) {
+		r.tracer = t
+	}
+}
@@ -0,0 +1,33 @@
+package tracing
+
+import (
+	"context"
+
+	"github.com/schoolboybru/distributed-rate-limiter/limiter"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// BreakerEventName is the span event recorded for requests affected by a
+// circuit breaker that isn't closed.
+const BreakerEventName = "ratelimit.circuit_breaker"
+
+// WithBreakerEvents adds a span event to the request's active span whenever
+// the circuit breaker is open or half-open, noting the breaker state, the key
+// and whether the limiter failed over instead of asking Redis.
+func WithBreakerEvents() limiter.Option {
+	return limiter.WithBreakerHook(recordBreakerEvent)
+}
+
+func recordBreakerEvent(ctx context.Context, key string, state limiter.CircuitState, failedOver bool) {
+	span := trace.SpanFromContext(ctx)
+	if !span.IsRecording() {
+		return
+	}
+
+	span.AddEvent(BreakerEventName, trace.WithAttributes(
+		attribute.String("ratelimit.key", key),
+		attribute.String("ratelimit.circuit_state", state.String()),
+		attribute.Bool("ratelimit.failed_over", failedOver),
+	))
+}
@@ -0,0 +1,71 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/schoolboybru/distributed-rate-limiter/limiter"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestWithBreakerEvents_RecordsFailover(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer("test")
+
+	client := redis.NewClient(&redis.Options{Addr: "localhost:9999", MaxRetries: -1})
+	l := limiter.NewRedisLimiter(client, 10, 1, "ratelimit:",
+		limiter.WithCircuitBreaker(1, time.Minute),
+		WithBreakerEvents(),
+	)
+
+	ctx, span := tracer.Start(context.Background(), "closed")
+	l.Wait(ctx, "Traced", 1)
+	span.End()
+
+	ctx, span = tracer.Start(context.Background(), "open")
+	l.Wait(ctx, "Traced", 1)
+	span.End()
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(spans))
+	}
+
+	if events := spans[0].Events(); len(events) != 0 {
+		t.Errorf("expected no event while the breaker was closed, got %d", len(events))
+	}
+
+	events := spans[1].Events()
+	if len(events) != 1 || events[0].Name != BreakerEventName {
+		t.Fatalf("expected a %s event, got %v", BreakerEventName, events)
+	}
+
+	want := map[attribute.Key]attribute.Value{
+		"ratelimit.key":           attribute.StringValue("Traced"),
+		"ratelimit.circuit_state": attribute.StringValue("open"),
+		"ratelimit.failed_over":   attribute.BoolValue(true),
+	}
+	for _, attr := range events[0].Attributes {
+		if want[attr.Key] != attr.Value {
+			t.Errorf("expected %s=%v, got %v", attr.Key, want[attr.Key].Emit(), attr.Value.Emit())
+		}
+	}
+}
+
+func TestWithBreakerEvents_IgnoresUnsampledSpans(t *testing.T) {
+	client := redis.NewClient(&redis.Options{Addr: "localhost:9999", MaxRetries: -1})
+	l := limiter.NewRedisLimiter(client, 10, 1, "ratelimit:",
+		limiter.WithCircuitBreaker(1, time.Minute),
+		WithBreakerEvents(),
+	)
+
+	l.Allow("Untraced", 1)
+
+	if !l.Allow("Untraced", 1) {
+		t.Error("expected the limiter to fail open without an active span")
+	}
+}
@@ -0,0 +1,75 @@
+package tracing
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel/metric"
+)
+
+// Metrics implements limiter.Metrics with OTel instruments. Counters aren't
+// broken down by key, since every distinct attribute value is a separate
+// series; per-key detail belongs on spans. Shadow denies are counted apart
+// from real denies.
+type Metrics struct {
+	allows  metric.Int64Counter
+	denies  metric.Int64Counter
+	shadow  metric.Int64Counter
+	errors  metric.Int64Counter
+	latency metric.Float64Histogram
+}
+
+func NewMetrics(meter metric.Meter) (*Metrics, error) {
+	allows, err := meter.Int64Counter("ratelimit.allowed", metric.WithDescription("Requests allowed by the rate limiter."))
+	if err != nil {
+		return nil, err
+	}
+
+	denies, err := meter.Int64Counter("ratelimit.denied", metric.WithDescription("Requests denied by the rate limiter."))
+	if err != nil {
+		return nil, err
+	}
+
+	shadow, err := meter.Int64Counter("ratelimit.shadow_denied", metric.WithDescription("Requests a rate limiter in shadow mode would have denied."))
+	if err != nil {
+		return nil, err
+	}
+
+	errors, err := meter.Int64Counter("ratelimit.errors", metric.WithDescription("Errors talking to the rate limiter backend."))
+	if err != nil {
+		return nil, err
+	}
+
+	latency, err := meter.Float64Histogram("ratelimit.latency", metric.WithUnit("s"), metric.WithDescription("Latency of rate limiter backend calls."))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Metrics{
+		allows:  allows,
+		denies:  denies,
+		shadow:  shadow,
+		errors:  errors,
+		latency: latency,
+	}, nil
+}
+
+func (m *Metrics) OnAllow(key string) {
+	m.allows.Add(context.Background(), 1)
+}
+
+func (m *Metrics) OnDeny(key string) {
+	m.denies.Add(context.Background(), 1)
+}
+
+func (m *Metrics) OnShadowDeny(key string) {
+	m.shadow.Add(context.Background(), 1)
+}
+
+func (m *Metrics) OnError(key string, err error) {
+	m.errors.Add(context.Background(), 1)
+}
+
+func (m *Metrics) OnLatency(key string, d time.Duration) {
+	m.latency.Record(context.Background(), d.Seconds())
+}
@@ -0,0 +1,51 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/schoolboybru/distributed-rate-limiter/limiter"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+var _ limiter.Metrics = (*Metrics)(nil)
+var _ limiter.ShadowMetrics = (*Metrics)(nil)
+
+func TestMetrics_MirrorsHooks(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	meter := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)).Meter("test")
+
+	m, err := NewMetrics(meter)
+	if err != nil {
+		t.Fatalf("expected instruments to be created, got %v", err)
+	}
+
+	m.OnAllow("user-1")
+	m.OnAllow("user-2")
+	m.OnDeny("user-1")
+	m.OnShadowDeny("user-2")
+	m.OnError("user-1", errors.New("boom"))
+	m.OnLatency("user-1", time.Millisecond)
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatalf("collect failed: %v", err)
+	}
+
+	sums := make(map[string]int64)
+	for _, metric := range rm.ScopeMetrics[0].Metrics {
+		if sum, ok := metric.Data.(metricdata.Sum[int64]); ok {
+			sums[metric.Name] = sum.DataPoints[0].Value
+		}
+	}
+
+	expected := map[string]int64{"ratelimit.allowed": 2, "ratelimit.denied": 1, "ratelimit.shadow_denied": 1, "ratelimit.errors": 1}
+	for name, want := range expected {
+		if sums[name] != want {
+			t.Errorf("expected %s to be %d, got %d", name, want, sums[name])
+		}
+	}
+}
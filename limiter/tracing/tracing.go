@@ -0,0 +1,102 @@
+// Package tracing instruments rate limiters with OpenTelemetry spans and
+// metrics. It lives outside the limiter package so the core doesn't depend on
+// OTel.
+package tracing
+
+import (
+	"context"
+	"time"
+
+	"github.com/schoolboybru/distributed-rate-limiter/limiter"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const scopeName = "github.com/schoolboybru/distributed-rate-limiter/limiter"
+
+// WithTracing starts a span around each RedisLimiter Allow and Wait call,
+// recording the key, tokens, decision and Redis latency. The span's status is
+// set to error when the circuit is open or Redis fails.
+func WithTracing(tp trace.TracerProvider) limiter.Option {
+	return limiter.WithTracer(tracer{tp.Tracer(scopeName)})
+}
+
+type tracer struct {
+	tracer trace.Tracer
+}
+
+func (t tracer) Start(ctx context.Context, operation string, key string) (context.Context, limiter.Span) {
+	ctx, s := t.tracer.Start(ctx, "ratelimit."+operation, trace.WithAttributes(attribute.String("ratelimit.key", key)))
+	return ctx, span{s}
+}
+
+type span struct {
+	span trace.Span
+}
+
+func (s span) End(allowed bool, tokens float64, redisLatency time.Duration, err error) {
+	s.span.SetAttributes(
+		attribute.Bool("ratelimit.allowed", allowed),
+		attribute.Float64("ratelimit.tokens", tokens),
+		attribute.Float64("ratelimit.redis_latency_ms", float64(redisLatency)/float64(time.Millisecond)),
+	)
+
+	if err != nil {
+		s.span.RecordError(err)
+		s.span.SetStatus(codes.Error, err.Error())
+	}
+
+	s.span.End()
+}
+
+// Limiter wraps any Limiter, such as the in-memory ones, with a span per call.
+type Limiter struct {
+	limiter limiter.Limiter
+	tracer  tracer
+}
+
+func NewLimiter(l limiter.Limiter, tp trace.TracerProvider) *Limiter {
+	return &Limiter{
+		limiter: l,
+		tracer:  tracer{tp.Tracer(scopeName)},
+	}
+}
+
+// Allow starts a root span; use AllowCtx to attach it to a trace.
+func (l *Limiter) Allow(key string, tokens int) bool {
+	allowed, _ := l.AllowCtx(context.Background(), key, tokens)
+	return allowed
+}
+
+// ctxLimiter is implemented by limiters that can bind a decision to a context,
+// such as limiter.RedisLimiter.
+type ctxLimiter interface {
+	AllowCtx(ctx context.Context, key string, tokens int) (bool, error)
+}
+
+// AllowCtx behaves like Allow with its span parented by ctx. If the wrapped
+// limiter has an AllowCtx of its own, ctx and its error are passed through;
+// otherwise the error is always nil.
+func (l *Limiter) AllowCtx(ctx context.Context, key string, tokens int) (bool, error) {
+	ctx, s := l.tracer.Start(ctx, "Allow", key)
+
+	var allowed bool
+	var err error
+	if cl, ok := l.limiter.(ctxLimiter); ok {
+		allowed, err = cl.AllowCtx(ctx, key, tokens)
+	} else {
+		allowed = l.limiter.Allow(key, tokens)
+	}
+	s.End(allowed, float64(tokens), 0, err)
+
+	return allowed, err
+}
+
+func (l *Limiter) Wait(ctx context.Context, key string, tokens int) error {
+	ctx, s := l.tracer.Start(ctx, "Wait", key)
+	err := l.limiter.Wait(ctx, key, tokens)
+	s.End(err == nil, float64(tokens), 0, err)
+
+	return err
+}
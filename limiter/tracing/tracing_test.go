@@ -0,0 +1,90 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/schoolboybru/distributed-rate-limiter/limiter"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func spanAttrs(s sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+	attrs := make(map[attribute.Key]attribute.Value)
+	for _, attr := range s.Attributes() {
+		attrs[attr.Key] = attr.Value
+	}
+	return attrs
+}
+
+func TestWithTracing_RecordsDecisions(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr(), MaxRetries: -1})
+	l := limiter.NewRedisLimiter(client, 5, 0, "ratelimit:", WithTracing(tp))
+
+	l.Allow("Traced", 5)
+	l.Allow("Traced", 1)
+
+	mr.SetError("LOADING Redis is loading the dataset in memory")
+	l.Wait(context.Background(), "Traced", 1)
+
+	spans := recorder.Ended()
+	if len(spans) != 3 {
+		t.Fatalf("expected 3 spans, got %d", len(spans))
+	}
+
+	if spans[0].Name() != "ratelimit.Allow" || spans[2].Name() != "ratelimit.Wait" {
+		t.Errorf("unexpected span names %s, %s", spans[0].Name(), spans[2].Name())
+	}
+
+	attrs := spanAttrs(spans[0])
+	if !attrs["ratelimit.allowed"].AsBool() || attrs["ratelimit.tokens"].AsFloat64() != 5 || attrs["ratelimit.key"].AsString() != "Traced" {
+		t.Errorf("unexpected attributes %v", attrs)
+	}
+
+	if attrs["ratelimit.redis_latency_ms"].AsFloat64() <= 0 {
+		t.Error("expected the redis latency to be recorded")
+	}
+
+	if spanAttrs(spans[1])["ratelimit.allowed"].AsBool() || spans[1].Status().Code == codes.Error {
+		t.Error("expected a plain deny without error status")
+	}
+
+	if spans[2].Status().Code != codes.Error {
+		t.Error("expected a redis failure to set error status")
+	}
+}
+
+func TestLimiter_WrapsInMemoryLimiter(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	l := NewLimiter(limiter.NewKeyedLimiter(1, 0, limiter.RealClock{}), tp)
+
+	ctx, parent := tp.Tracer("test").Start(context.Background(), "request")
+	l.AllowCtx(ctx, "user-1", 1)
+	parent.End()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	l.Wait(ctx, "user-1", 1)
+
+	spans := recorder.Ended()
+	if len(spans) != 3 {
+		t.Fatalf("expected 3 spans, got %d", len(spans))
+	}
+
+	if spans[0].Parent().SpanID() != parent.SpanContext().SpanID() {
+		t.Error("expected the allow span to be a child of the request span")
+	}
+
+	if spans[2].Name() != "ratelimit.Wait" || spans[2].Status().Code != codes.Error {
+		t.Error("expected a cancelled wait to set error status")
+	}
+}
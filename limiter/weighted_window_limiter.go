@@ -0,0 +1,145 @@
+package limiter
+
+import (
+	"context"
+	_ "embed"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+//go:embed scripts/weighted_window.lua
+var weightedWindowScript string
+
+// RedisWeightedWindow is a distributed sliding window that limits the summed
+// cost of requests, rather than their count, over the trailing window.
+//
+// Each key is stored as a sorted set of admitted requests scored by time, plus
+// a hash holding the running cost total so a call only walks the entries that
+// expired since the last one. Both share a {hash tag} so the script stays
+// cluster-safe. Memory per key is bounded by the number of requests admitted
+// in one window, i.e. limit divided by the smallest cost. Zero-cost requests
+// are admitted without adding an entry.
+type RedisWeightedWindow struct {
+	client    redis.Cmdable
+	script    *redis.Script
+	limit     float64
+	window    time.Duration
+	keyPrefix string
+	metrics   Metrics
+}
+
+type WeightedWindowOption func(*RedisWeightedWindow)
+
+func WithWeightedWindowMetrics(m Metrics) WeightedWindowOption {
+	return func(w *RedisWeightedWindow) {
+		w.metrics = m
+	}
+}
+
+// weightedWindowRetryFloor is how long Wait sleeps when the script has no
+// retry estimate for a denied request.
+const weightedWindowRetryFloor = 20 * time.Millisecond
+
+func NewRedisWeightedWindow(client redis.Cmdable, limit float64, window time.Duration, keyPrefix string, opts ...WeightedWindowOption) *RedisWeightedWindow {
+	w := &RedisWeightedWindow{
+		client:    client,
+		script:    redis.NewScript(weightedWindowScript),
+		limit:     limit,
+		window:    window,
+		keyPrefix: keyPrefix,
+		metrics:   NoopMetrics{},
+	}
+
+	for _, opt := range opts {
+		opt(w)
+	}
+
+	return w
+}
+
+// Allow reports whether cost fits in the window. Redis errors are reported to
+// Metrics and fail open.
+func (w *RedisWeightedWindow) Allow(key string, cost int) bool {
+	if cost < 0 {
+		return false
+	}
+
+	allowed, _, err := w.allowCost(context.Background(), key, float64(cost))
+	if err != nil {
+		return true
+	}
+
+	return allowed
+}
+
+// AllowCost admits the request if the window's cost plus cost stays within
+// the limit.
+func (w *RedisWeightedWindow) AllowCost(ctx context.Context, key string, cost float64) (bool, error) {
+	if cost < 0 {
+		return false, ErrNegativeTokens
+	}
+
+	allowed, _, err := w.allowCost(ctx, key, cost)
+	return allowed, err
+}
+
+// allowCost runs the script and records the outcome. A denial comes with how
+// long until enough cost leaves the window, or NeverAvailable.
+func (w *RedisWeightedWindow) allowCost(ctx context.Context, key string, cost float64) (bool, time.Duration, error) {
+	start := time.Now()
+	base := w.keyPrefix + "{" + key + "}"
+	result, err := w.script.Run(ctx, w.client, []string{base + ":log", base + ":cost"},
+		strconv.FormatFloat(cost, 'f', -1, 64), w.limit, w.window.Microseconds()).Result()
+	w.metrics.OnLatency(key, time.Since(start))
+
+	if err != nil {
+		w.metrics.OnError(key, err)
+		return false, 0, err
+	}
+
+	resSlice := result.([]interface{})
+	if resSlice[0].(int64) == 1 {
+		recordAllow(w.metrics, key, cost)
+		return true, 0, nil
+	}
+
+	w.metrics.OnDeny(key)
+
+	retry := resSlice[2].(int64)
+	if retry < 0 {
+		return false, NeverAvailable, nil
+	}
+	return false, time.Duration(retry) * time.Microsecond, nil
+}
+
+// Wait blocks until cost fits, sleeping on each denial until the script
+// expects enough cost to have left the window. Redis errors fail open.
+func (w *RedisWeightedWindow) Wait(ctx context.Context, key string, cost int) error {
+	if cost < 0 {
+		return ErrNegativeTokens
+	}
+
+	if float64(cost) > w.limit {
+		return ErrExceedsCapacity
+	}
+
+	for {
+		allowed, retry, err := w.allowCost(ctx, key, float64(cost))
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil || allowed {
+			return nil
+		}
+
+		timer := time.NewTimer(max(retry, weightedWindowRetryFloor))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
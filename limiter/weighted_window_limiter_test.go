@@ -0,0 +1,175 @@
+package limiter
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestWeightedWindow_LimitsCost(t *testing.T) {
+	mr, client := setupMiniRedis(t)
+	mr.SetTime(time.Now())
+	limiter := NewRedisWeightedWindow(client, 10, time.Minute, "weighted:")
+
+	if !limiter.Allow("user-1", 7) {
+		t.Error("expected cost 7 to be allowed")
+	}
+
+	if limiter.Allow("user-1", 4) {
+		t.Error("expected cost 4 to be denied with 7 of 10 used")
+	}
+
+	if !limiter.Allow("user-1", 3) {
+		t.Error("expected cost 3 to fill the window")
+	}
+
+	if !limiter.Allow("user-2", 10) {
+		t.Error("expected a different key to have its own window")
+	}
+}
+
+func TestWeightedWindow_FractionalCost(t *testing.T) {
+	mr, client := setupMiniRedis(t)
+	mr.SetTime(time.Now())
+	limiter := NewRedisWeightedWindow(client, 1, time.Minute, "weighted:")
+
+	for range 4 {
+		if allowed, err := limiter.AllowCost(context.Background(), "user-1", 0.25); !allowed || err != nil {
+			t.Errorf("expected cost 0.25 to be allowed, got %v, %v", allowed, err)
+		}
+	}
+
+	if allowed, _ := limiter.AllowCost(context.Background(), "user-1", 0.25); allowed {
+		t.Error("expected the window to be full")
+	}
+}
+
+func TestWeightedWindow_ZeroCostAddsNoEntry(t *testing.T) {
+	mr, client := setupMiniRedis(t)
+	mr.SetTime(time.Now())
+	limiter := NewRedisWeightedWindow(client, 10, time.Minute, "weighted:")
+
+	for range 100 {
+		if !limiter.Allow("user-1", 0) {
+			t.Fatal("expected a zero-cost request to be allowed")
+		}
+	}
+
+	if mr.Exists("weighted:{user-1}:log") {
+		members, _ := mr.ZMembers("weighted:{user-1}:log")
+		t.Errorf("expected no log entries for zero-cost requests, got %d", len(members))
+	}
+
+	if !limiter.Allow("user-1", 10) {
+		t.Error("expected zero-cost requests to leave the whole limit free")
+	}
+}
+
+func TestWeightedWindow_ExpiresOldCost(t *testing.T) {
+	mr, client := setupMiniRedis(t)
+	now := time.Now()
+	mr.SetTime(now)
+	limiter := NewRedisWeightedWindow(client, 10, time.Minute, "weighted:")
+
+	limiter.Allow("user-1", 6)
+
+	mr.SetTime(now.Add(30 * time.Second))
+	limiter.Allow("user-1", 4)
+
+	mr.SetTime(now.Add(61 * time.Second))
+	if !limiter.Allow("user-1", 6) {
+		t.Error("expected the first request's cost to have left the window")
+	}
+
+	if limiter.Allow("user-1", 1) {
+		t.Error("expected the second request's cost to still count")
+	}
+
+	mr.SetTime(now.Add(91 * time.Second))
+	if !limiter.Allow("user-1", 4) {
+		t.Error("expected the second request's cost to have left the window")
+	}
+}
+
+func TestWeightedWindow_WaitExceedsCapacity(t *testing.T) {
+	_, client := setupMiniRedis(t)
+	limiter := NewRedisWeightedWindow(client, 10, time.Minute, "weighted:")
+
+	err := limiter.Wait(context.Background(), "user-1", 11)
+
+	if err != ErrExceedsCapacity {
+		t.Errorf("expected ErrExceedsCapacity, got %v", err)
+	}
+}
+
+func TestWeightedWindow_ReportsRedisErrors(t *testing.T) {
+	mr, client := setupMiniRedis(t)
+	metrics := &MockMetrics{}
+	limiter := NewRedisWeightedWindow(client, 10, time.Minute, "weighted:", WithWeightedWindowMetrics(metrics))
+
+	mr.SetError("LOADING Redis is loading the dataset in memory")
+	if !limiter.Allow("user-1", 1) {
+		t.Error("expected a Redis error to fail open")
+	}
+	if err := limiter.Wait(context.Background(), "user-1", 1); err != nil {
+		t.Errorf("expected Wait to fail open, got %v", err)
+	}
+
+	if len(metrics.errors) != 2 || len(metrics.latencies) != 2 {
+		t.Errorf("expected 2 errors and 2 latencies, got %d and %d", len(metrics.errors), len(metrics.latencies))
+	}
+
+	mr.SetError("")
+	limiter.Allow("user-1", 10)
+	limiter.Allow("user-1", 1)
+	if len(metrics.allows) != 1 || len(metrics.denies) != 1 {
+		t.Errorf("expected 1 allow and 1 deny, got %d and %d", len(metrics.allows), len(metrics.denies))
+	}
+}
+
+func TestWeightedWindow_DenyReturnsRetryTime(t *testing.T) {
+	mr, client := setupMiniRedis(t)
+	now := time.Now()
+	mr.SetTime(now)
+	limiter := NewRedisWeightedWindow(client, 10, time.Minute, "weighted:")
+
+	limiter.Allow("user-1", 6)
+	mr.SetTime(now.Add(30 * time.Second))
+	limiter.Allow("user-1", 4)
+
+	mr.SetTime(now.Add(40 * time.Second))
+	allowed, retry, err := limiter.allowCost(context.Background(), "user-1", 5)
+	if allowed || err != nil || retry != 20*time.Second {
+		t.Errorf("expected a denial until the first request leaves in 20s, got %v, %v, %v", allowed, retry, err)
+	}
+
+	allowed, retry, _ = limiter.allowCost(context.Background(), "user-1", 11)
+	if allowed || retry != NeverAvailable {
+		t.Errorf("expected cost over the limit never to fit, got %v, %v", allowed, retry)
+	}
+}
+
+func TestWeightedWindow_WaitSleepsUntilRetry(t *testing.T) {
+	_, client := setupMiniRedis(t)
+	metrics := &MockMetrics{}
+	limiter := NewRedisWeightedWindow(client, 10, 300*time.Millisecond, "weighted:", WithWeightedWindowMetrics(metrics))
+
+	limiter.Allow("user-1", 10)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	start := time.Now()
+	if err := limiter.Wait(ctx, "user-1", 1); err != nil {
+		t.Fatalf("expected Wait to succeed, got %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed < 250*time.Millisecond {
+		t.Errorf("expected Wait to last until the window moved, took %v", elapsed)
+	}
+
+	// Polling every 20ms would have been denied about 15 times.
+	if denies := len(metrics.denies); denies > 2 {
+		t.Errorf("expected Wait to sleep for the retry time, got %d denials", denies)
+	}
+}
@@ -6,18 +6,17 @@ import (
 	"time"
 
 	"github.com/schoolboybru/distributed-rate-limiter/limiter"
+	"github.com/schoolboybru/distributed-rate-limiter/limiter/middleware"
 )
 
 func main() {
 	bucket := limiter.NewTokenBucket(5, 1, limiter.RealClock{})
+	keyed := limiter.NewKeyedLimiter(5, 1, limiter.RealClock{})
+	rateLimit := middleware.RateLimit(keyed, middleware.RemoteIPKey)
 
-	http.HandleFunc("/ping", func(w http.ResponseWriter, r *http.Request) {
-		if !bucket.Allow(1) {
-			w.WriteHeader(http.StatusTooManyRequests)
-			w.Write([]byte("Rate limited! Try again later.\n"))
-		}
+	http.Handle("/ping", rateLimit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte("pong\n"))
-	})
+	})))
 
 	http.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
 		ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)